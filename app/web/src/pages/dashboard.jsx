@@ -8,6 +8,8 @@ export default function Dashboard() {
 
   const handleLogout = () => {
     localStorage.removeItem("ls_session");
+    localStorage.removeItem("ls_session_id");
+    localStorage.removeItem("ls_refresh");
     localStorage.removeItem("ls_user");
     localStorage.removeItem("ls_company");
     sessionStorage.removeItem("ls_company_key");
@@ -1,18 +1,22 @@
 import { useState, useRef } from "react";
-import { recoverKeys } from "../utils/crypto";
+import { recoverKeys, answerResetChallenge } from "../utils/crypto";
 
 const API_URL = "http://localhost:8080/api/execute";
 
 export default function ForgotPassword() {
+    // The emailed reset link carries a single-use token
+    const token = new URLSearchParams(window.location.search).get("token");
+
     const [loading, setLoading] = useState(false);
     const [error, setError] = useState("");
-    const [step, setStep] = useState("upload"); // upload | reset | done
+    const [step, setStep] = useState(token ? "upload" : "request"); // request | sent | upload | reset | done
     const [showPassword, setShowPassword] = useState(false);
     const [showConfirm, setShowConfirm] = useState(false);
     const [recoveryFile, setRecoveryFile] = useState(null);
     const [recoveryData, setRecoveryData] = useState(null);
     const [keyDownloaded, setKeyDownloaded] = useState(false);
     const [newRecoveryKeys, setNewRecoveryKeys] = useState(null);
+    const [lostCompanies, setLostCompanies] = useState(0);
     const fileInputRef = useRef(null);
 
     const [form, setForm] = useState({
@@ -57,8 +61,34 @@ export default function ForgotPassword() {
         reader.readAsText(file);
     };
 
-    const handleReset = async () => {
+    const handleRequest = async () => {
         if (!form.email.trim()) { setError("Email is required"); return; }
+
+        setLoading(true);
+        setError("");
+
+        try {
+            const res = await fetch(`${API_URL}?action=request_password_reset`, {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({ email: form.email }),
+            });
+
+            const data = await res.json();
+            if (!data.success) {
+                setError(data.error || "Could not request a password reset");
+                setLoading(false);
+                return;
+            }
+
+            setStep("sent");
+        } catch {
+            setError("Cannot connect to server");
+        }
+        setLoading(false);
+    };
+
+    const handleReset = async () => {
         if (!form.password) { setError("New password is required"); return; }
         if (form.password.length < 8) { setError("Password must be at least 8 characters"); return; }
         if (form.password !== form.confirm_password) { setError("Passwords do not match"); return; }
@@ -67,6 +97,30 @@ export default function ForgotPassword() {
         setError("");
 
         try {
+            // Prove possession of the recovery key: decrypt one of the server's challenges
+            const beginRes = await fetch(`${API_URL}?action=begin_password_reset`, {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({ token }),
+            });
+            const begin = await beginRes.json();
+            if (!begin.success) {
+                setError(begin.error || "This reset link is invalid or has expired");
+                setLoading(false);
+                return;
+            }
+
+            let answered = null;
+            for (const c of begin.data.challenges) {
+                const proof = await answerResetChallenge(c.challenge, recoveryData.keys.privateKey);
+                if (proof) { answered = { companyId: c.company_id, keyVersion: c.key_version, proof }; break; }
+            }
+            if (!answered) {
+                setError("This recovery file does not belong to the account for this reset link.");
+                setLoading(false);
+                return;
+            }
+
             // Generate new salt
             const newSalt = crypto.randomUUID();
 
@@ -82,15 +136,22 @@ export default function ForgotPassword() {
             localStorage.setItem("ls_private_key", keys.privateKey);
 
             // Send to server
-            const res = await fetch(`${API_URL}?action=reset_password`, {
+            const res = await fetch(`${API_URL}?action=complete_password_reset`, {
                 method: "POST",
                 headers: { "Content-Type": "application/json" },
                 body: JSON.stringify({
-                    email: form.email,
+                    token,
+                    company_id: answered.companyId,
+                    proof: answered.proof,
                     password: form.password,
                     salt: newSalt,
-                    wrapped_company_key: keys.wrappedCompanyKey,
-                    key_wrap_algorithm: "AES-KW",
+                    wrapped_keys: {
+                        [answered.companyId]: {
+                            wrapped_company_key: keys.wrappedCompanyKey,
+                            key_wrap_algorithm: "AES-KW",
+                            key_version: answered.keyVersion,
+                        },
+                    },
                     public_key: keys.publicKey,
                 }),
             });
@@ -110,6 +171,8 @@ export default function ForgotPassword() {
                 salt: newSalt,
             });
 
+            // Companies this recovery file could not unlock need an admin to re-share the key
+            setLostCompanies(data.data?.lost_key_companies?.length || 0);
             setStep("done");
         } catch (e) {
             setError("Recovery failed. The key file may be corrupted or for a different account.");
@@ -176,6 +239,38 @@ export default function ForgotPassword() {
                         <span className="logo-text">LETTER<span className="logo-bold">SHEETS</span></span>
                     </div>
 
+                    {/* ===== Request a reset link ===== */}
+                    {step === "request" && (
+                        <>
+                            <h2 className="form-title">Reset Your Password</h2>
+                            <p className="form-subtitle">Enter your email and we will send you a reset link.</p>
+
+                            <div className="form-fields">
+                                <div className="field">
+                                    <label className="field-label">Email</label>
+                                    <input className="field-input" type="email" value={form.email} onChange={update("email")} />
+                                </div>
+                            </div>
+
+                            {error && <div className="error-box">{error}</div>}
+
+                            <button className="btn-primary" onClick={handleRequest} disabled={loading}>
+                                {loading ? <span className="spinner"/> : "Send Reset Link"}
+                            </button>
+
+                            <a className="btn-back-link" href="/">← Back to sign in</a>
+                        </>
+                    )}
+
+                    {step === "sent" && (
+                        <>
+                            <h2 className="form-title">Check Your Email</h2>
+                            <p className="form-subtitle">If an account exists for <strong>{form.email}</strong>, a reset link is on its way. Open it and have your recovery key file ready.</p>
+
+                            <a className="btn-back-link" href="/">← Back to sign in</a>
+                        </>
+                    )}
+
                     {/* ===== STEP 1: Upload recovery file ===== */}
                     {step === "upload" && (
                         <>
@@ -307,6 +402,12 @@ export default function ForgotPassword() {
                             <h2 className="form-title">Password Reset Complete</h2>
                             <p className="form-subtitle">Your password and encryption keys have been updated.</p>
 
+                            {lostCompanies > 0 && (
+                                <div className="error-box">
+                                    Your access to {lostCompanies} other {lostCompanies === 1 ? "company" : "companies"} could not be recovered with this file. Ask an admin there to share the company key with you again.
+                                </div>
+                            )}
+
                             {/* New recovery key download */}
                             <div className="key-box">
                                 <div className="key-icon">
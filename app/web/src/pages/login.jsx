@@ -12,11 +12,13 @@ export default function Login() {
   const [slide, setSlide] = useState(0);
 
   // Login state
-  const [form, setForm] = useState({ email: "", password: "" });
+  const [form, setForm] = useState({ email: "", password: "", totp_code: "" });
+  const [needsTotp, setNeedsTotp] = useState(false);
 
   // Post-login: company selection
   const [user, setUser] = useState(null);
   const [companies, setCompanies] = useState([]);
+  const [preAuthToken, setPreAuthToken] = useState("");
   const [step, setStep] = useState("login"); // "login" | "select_company"
 
   const update = (field) => (e) => {
@@ -35,27 +37,38 @@ export default function Login() {
       const res = await fetch(`${API_URL}?action=login`, {
         method: "POST",
         headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ email: form.email, password: form.password }),
+        body: JSON.stringify({
+          email: form.email,
+          password: form.password,
+          ...(form.totp_code && { totp_code: form.totp_code }),
+        }),
       });
       const data = await res.json();
       if (!data.success) {
-        setError(data.error || "Login failed");
+        if (data.error === "totp code required") {
+          setNeedsTotp(true);
+          setError("Enter the code from your authenticator app");
+        } else {
+          setError(data.error || "Login failed");
+        }
         setLoading(false);
         return;
       }
 
       const loginUser = data.data.user;
       const loginCompanies = data.data.companies;
+      const preAuthToken = data.data.pre_auth_token;
 
       setUser(loginUser);
       localStorage.setItem("ls_user", JSON.stringify(loginUser));
 
       if (loginCompanies.length === 1) {
         // Auto-select single company
-        await selectCompany(loginUser, loginCompanies[0]);
+        await selectCompany(loginUser, loginCompanies[0], preAuthToken);
       } else {
         // Show company picker
         setCompanies(loginCompanies);
+        setPreAuthToken(preAuthToken);
         setStep("select_company");
       }
     } catch (e) {
@@ -64,7 +77,7 @@ export default function Login() {
     setLoading(false);
   };
 
-  const selectCompany = async (loginUser, company) => {
+  const selectCompany = async (loginUser, company, token = preAuthToken) => {
     setLoading(true);
     setError("");
 
@@ -73,7 +86,7 @@ export default function Login() {
         method: "POST",
         headers: { "Content-Type": "application/json" },
         body: JSON.stringify({
-          user_id: loginUser.id,
+          pre_auth_token: token,
           company_id: company.company_id,
         }),
       });
@@ -87,7 +100,9 @@ export default function Login() {
       const session = data.data;
 
       // Store session
-      localStorage.setItem("ls_session", session.session_id);
+      localStorage.setItem("ls_session", session.token);
+      localStorage.setItem("ls_session_id", session.session_id);
+      localStorage.setItem("ls_refresh", session.refresh_token);
       localStorage.setItem("ls_company", JSON.stringify({
         id: company.company_id,
         name: company.company_name,
@@ -277,6 +292,23 @@ export default function Login() {
                         <a className="forgot-link" href="/forgot-password">Forgot password?</a>
                       </div>
                     </div>
+
+                    {needsTotp && (
+                        <div className="field">
+                          <label className="field-label">Authentication code</label>
+                          <input
+                              className="field-input"
+                              type="text"
+                              inputMode="numeric"
+                              autoComplete="one-time-code"
+                              placeholder="123456"
+                              value={form.totp_code}
+                              onChange={update("totp_code")}
+                              onKeyDown={handleKeyDown}
+                              autoFocus
+                          />
+                        </div>
+                    )}
                   </div>
 
                   {error && <div className="error-box">{error}</div>}
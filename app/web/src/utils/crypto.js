@@ -362,6 +362,26 @@ export async function recoverKeys(recoveryWrappedKeyB64, privateKeyB64, newPassw
   };
 }
 
+/**
+ * Answer a password reset challenge by decrypting it with the recovery private key
+ * @param {string} challengeB64 - RSA-encrypted nonce from begin_password_reset
+ * @param {string} privateKeyB64 - RSA private key from recovery file
+ * @returns {Promise<string|null>} Base64 nonce, or null if this key cannot decrypt it
+ */
+export async function answerResetChallenge(challengeB64, privateKeyB64) {
+  const privateKey = await importPrivateKey(base64ToBuffer(privateKeyB64));
+  try {
+    const nonce = await crypto.subtle.decrypt(
+        { name: "RSA-OAEP" },
+        privateKey,
+        base64ToBuffer(challengeB64)
+    );
+    return bufferToBase64(nonce);
+  } catch {
+    return null;
+  }
+}
+
 // ============================================================
 // UTILITY
 // ============================================================
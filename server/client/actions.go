@@ -0,0 +1,420 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"lettersheets/models"
+)
+
+// Action and Route mirror the entries of list_actions
+type Action struct {
+	Name                 string  `json:"name"`
+	Group                string  `json:"group"`
+	Permission           string  `json:"permission,omitempty"`
+	Public               bool    `json:"public"`
+	RateLimited          bool    `json:"rate_limited"`
+	AllowExpiredPassword bool    `json:"allow_expired_password,omitempty"`
+	AllowMFAPending      bool    `json:"allow_mfa_pending,omitempty"`
+	Idempotent           bool    `json:"idempotent,omitempty"`
+	Routes               []Route `json:"routes,omitempty"`
+}
+
+type Route struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+}
+
+// ==================== AUTH ====================
+
+func (c *Client) Register(ctx context.Context, req *models.RegisterRequest) (*models.RegisterResponse, error) {
+	var resp models.RegisterResponse
+	if err := c.do(ctx, once(call{method: http.MethodPost, path: "/api/v1/register", body: req, public: true}), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Login checks the credentials and returns a pre-auth token for SelectCompany
+func (c *Client) Login(ctx context.Context, req *models.LoginRequest) (*models.LoginResponse, error) {
+	var resp models.LoginResponse
+	if err := c.sendPublic(ctx, "/api/v1/auth/login", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SelectCompany opens a session and makes it the client's session
+func (c *Client) SelectCompany(ctx context.Context, req *models.SelectCompanyRequest) (*models.SelectCompanyResponse, error) {
+	var resp models.SelectCompanyResponse
+	if err := c.sendPublic(ctx, "/api/v1/auth/select-company", req, &resp); err != nil {
+		return nil, err
+	}
+	c.keep(&resp)
+	return &resp, nil
+}
+
+// RefreshSession exchanges the client's refresh token for a new session.
+// Other calls do this themselves when the session has expired.
+func (c *Client) RefreshSession(ctx context.Context) (*models.RefreshSessionResponse, error) {
+	c.refreshing.Lock()
+	defer c.refreshing.Unlock()
+	return c.refreshSession(ctx)
+}
+
+// refreshSession is RefreshSession for a caller holding c.refreshing
+func (c *Client) refreshSession(ctx context.Context) (*models.RefreshSessionResponse, error) {
+	_, refresh := c.Tokens()
+	var resp models.RefreshSessionResponse
+	if err := c.sendPublic(ctx, "/api/v1/auth/refresh", &models.RefreshSessionRequest{RefreshToken: refresh}, &resp); err != nil {
+		return nil, err
+	}
+	c.SetTokens(resp.Token, resp.RefreshToken)
+	return &resp, nil
+}
+
+func (c *Client) WebAuthnLoginBegin(ctx context.Context, req *models.WebAuthnLoginBeginRequest) (*models.WebAuthnOptionsResponse, error) {
+	var resp models.WebAuthnOptionsResponse
+	if err := c.sendPublic(ctx, "/api/v1/auth/passkey/begin", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) WebAuthnLoginFinish(ctx context.Context, req *models.WebAuthnLoginRequest) (*models.LoginResponse, error) {
+	var resp models.LoginResponse
+	if err := c.sendPublic(ctx, "/api/v1/auth/passkey/finish", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) RequestPasswordReset(ctx context.Context, req *models.RequestPasswordResetRequest) (*models.MessageResponse, error) {
+	var resp models.MessageResponse
+	if err := c.sendPublic(ctx, "/api/v1/auth/password-reset", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) BeginPasswordReset(ctx context.Context, req *models.BeginPasswordResetRequest) (*models.BeginPasswordResetResponse, error) {
+	var resp models.BeginPasswordResetResponse
+	if err := c.sendPublic(ctx, "/api/v1/auth/password-reset/begin", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) CompletePasswordReset(ctx context.Context, req *models.CompletePasswordResetRequest) (*models.CompletePasswordResetResponse, error) {
+	var resp models.CompletePasswordResetResponse
+	if err := c.sendPublic(ctx, "/api/v1/auth/password-reset/complete", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Logout ends the client's session and forgets its tokens
+func (c *Client) Logout(ctx context.Context) (*models.MessageResponse, error) {
+	var resp models.MessageResponse
+	if err := c.send(ctx, http.MethodPost, "/api/v1/auth/logout", nil, &resp); err != nil {
+		return nil, err
+	}
+	c.SetTokens("", "")
+	return &resp, nil
+}
+
+// LogoutAll ends every session of the user and forgets the client's tokens
+func (c *Client) LogoutAll(ctx context.Context) (*models.MessageResponse, error) {
+	var resp models.MessageResponse
+	if err := c.send(ctx, http.MethodPost, "/api/v1/auth/logout-all", nil, &resp); err != nil {
+		return nil, err
+	}
+	c.SetTokens("", "")
+	return &resp, nil
+}
+
+// ==================== SESSIONS ====================
+
+func (c *Client) ListSessions(ctx context.Context) ([]models.SessionInfo, error) {
+	var resp []models.SessionInfo
+	if err := c.get(ctx, "/api/v1/sessions", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// RevokeSession ends one of the user's sessions, forgetting the client's
+// tokens when it is the current one
+func (c *Client) RevokeSession(ctx context.Context, sessionID string) (*models.RevokeSessionResponse, error) {
+	var resp models.RevokeSessionResponse
+	if err := c.send(ctx, http.MethodDelete, "/api/v1/sessions/"+url.PathEscape(sessionID), nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Current {
+		c.SetTokens("", "")
+	}
+	return &resp, nil
+}
+
+func (c *Client) ListUserSessions(ctx context.Context, userID string) ([]models.SessionInfo, error) {
+	var resp []models.SessionInfo
+	if err := c.get(ctx, "/api/v1/users/"+url.PathEscape(userID)+"/sessions", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// SwitchCompany opens a session in another company and makes it the client's session
+func (c *Client) SwitchCompany(ctx context.Context, req *models.SwitchCompanyRequest) (*models.SelectCompanyResponse, error) {
+	var resp models.SelectCompanyResponse
+	if err := c.send(ctx, http.MethodPost, "/api/v1/sessions/switch", req, &resp); err != nil {
+		return nil, err
+	}
+	c.keep(&resp)
+	return &resp, nil
+}
+
+// ==================== COMPANY ====================
+
+func (c *Client) GetCompany(ctx context.Context) (*models.Company, error) {
+	var resp models.Company
+	if err := c.get(ctx, "/api/v1/companies/me", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) UpdateCompany(ctx context.Context, req *models.UpdateCompanyRequest) (*models.MessageResponse, error) {
+	var resp models.MessageResponse
+	if err := c.send(ctx, http.MethodPatch, "/api/v1/companies/me", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) DeleteCompany(ctx context.Context) (*models.MessageResponse, error) {
+	var resp models.MessageResponse
+	if err := c.send(ctx, http.MethodDelete, "/api/v1/companies/me", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ==================== SECURITY POLICY ====================
+
+func (c *Client) GetSecurityPolicy(ctx context.Context) (*models.SecurityPolicy, error) {
+	var resp models.SecurityPolicy
+	if err := c.get(ctx, "/api/v1/companies/me/security-policy", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateSecurityPolicy replaces the whole policy, so it is retried like a read
+func (c *Client) UpdateSecurityPolicy(ctx context.Context, req *models.SecurityPolicy) (*models.MessageResponse, error) {
+	var resp models.MessageResponse
+	if err := c.do(ctx, call{method: http.MethodPut, path: "/api/v1/companies/me/security-policy", body: req, idempotent: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ==================== USER ====================
+
+func (c *Client) GetUser(ctx context.Context) (*models.User, error) {
+	var resp models.User
+	if err := c.get(ctx, "/api/v1/users/me", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) UpdateUser(ctx context.Context, req *models.UpdateUserRequest) (*models.MessageResponse, error) {
+	var resp models.MessageResponse
+	if err := c.send(ctx, http.MethodPatch, "/api/v1/users/me", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) ChangePassword(ctx context.Context, req *models.ChangePasswordRequest) (*models.ChangePasswordResponse, error) {
+	var resp models.ChangePasswordResponse
+	if err := c.send(ctx, http.MethodPut, "/api/v1/users/me/password", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteUser removes a user from the session's company
+func (c *Client) DeleteUser(ctx context.Context, userID string) (*models.MessageResponse, error) {
+	var resp models.MessageResponse
+	if err := c.send(ctx, http.MethodDelete, "/api/v1/users/"+url.PathEscape(userID), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) ListUsers(ctx context.Context) ([]models.UserCompanyAccess, error) {
+	var resp []models.UserCompanyAccess
+	if err := c.get(ctx, "/api/v1/users", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) CreateUser(ctx context.Context, req *models.CreateUserRequest) (*models.CreateUserResponse, error) {
+	var resp models.CreateUserResponse
+	if err := c.do(ctx, once(call{method: http.MethodPost, path: "/api/v1/users", body: req}), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ==================== TWO-FACTOR ====================
+
+func (c *Client) EnrollTOTP(ctx context.Context) (*models.TOTPEnrollResponse, error) {
+	var resp models.TOTPEnrollResponse
+	if err := c.send(ctx, http.MethodPost, "/api/v1/users/me/totp", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) ConfirmTOTP(ctx context.Context, req *models.TOTPCodeRequest) (*models.ConfirmTOTPResponse, error) {
+	var resp models.ConfirmTOTPResponse
+	if err := c.send(ctx, http.MethodPost, "/api/v1/users/me/totp/confirm", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) DisableTOTP(ctx context.Context, req *models.DisableTOTPRequest) (*models.MessageResponse, error) {
+	var resp models.MessageResponse
+	if err := c.send(ctx, http.MethodDelete, "/api/v1/users/me/totp", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) RegenerateBackupCodes(ctx context.Context, req *models.RegenerateBackupCodesRequest) (*models.BackupCodesResponse, error) {
+	var resp models.BackupCodesResponse
+	if err := c.send(ctx, http.MethodPost, "/api/v1/users/me/backup-codes", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ==================== PASSKEYS ====================
+
+func (c *Client) WebAuthnRegisterBegin(ctx context.Context) (*models.WebAuthnOptionsResponse, error) {
+	var resp models.WebAuthnOptionsResponse
+	if err := c.send(ctx, http.MethodPost, "/api/v1/users/me/passkeys/begin", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) WebAuthnRegisterFinish(ctx context.Context, req *models.WebAuthnRegisterRequest) (*models.WebAuthnCredential, error) {
+	var resp models.WebAuthnCredential
+	if err := c.send(ctx, http.MethodPost, "/api/v1/users/me/passkeys", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) ListWebAuthnCredentials(ctx context.Context) ([]models.WebAuthnCredential, error) {
+	var resp []models.WebAuthnCredential
+	if err := c.get(ctx, "/api/v1/users/me/passkeys", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) DeleteWebAuthnCredential(ctx context.Context, req *models.DeleteWebAuthnCredentialRequest) (*models.MessageResponse, error) {
+	var resp models.MessageResponse
+	if err := c.send(ctx, http.MethodDelete, "/api/v1/users/me/passkeys/"+url.PathEscape(req.ID), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ==================== LOGIN HISTORY ====================
+
+// GetLoginHistory returns the caller's login history, or that of
+// req.UserID when set
+func (c *Client) GetLoginHistory(ctx context.Context, req *models.LoginHistoryRequest) (*models.LoginHistoryResponse, error) {
+	path := "/api/v1/users/me/login-history"
+	if req.UserID != "" {
+		path = "/api/v1/users/" + url.PathEscape(req.UserID) + "/login-history"
+	}
+	var resp models.LoginHistoryResponse
+	if err := c.get(ctx, path, &models.LoginHistoryRequest{Limit: req.Limit, Offset: req.Offset}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) UnlockUser(ctx context.Context, userID string) (*models.MessageResponse, error) {
+	var resp models.MessageResponse
+	if err := c.send(ctx, http.MethodPost, "/api/v1/users/"+url.PathEscape(userID)+"/unlock", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ==================== ACCESS ====================
+
+func (c *Client) GetUserCompanies(ctx context.Context) ([]models.UserCompanyAccess, error) {
+	var resp []models.UserCompanyAccess
+	if err := c.get(ctx, "/api/v1/users/me/companies", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) UpdateUserAccess(ctx context.Context, req *models.UpdateUserAccessRequest) (*models.MessageResponse, error) {
+	var resp models.MessageResponse
+	if err := c.send(ctx, http.MethodPatch, "/api/v1/access/"+url.PathEscape(req.AccessID), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) RevokeUserAccess(ctx context.Context, accessID string) (*models.MessageResponse, error) {
+	var resp models.MessageResponse
+	if err := c.send(ctx, http.MethodDelete, "/api/v1/access/"+url.PathEscape(accessID), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ==================== HISTORY ====================
+
+func (c *Client) GetHistory(ctx context.Context, req *models.HistoryRequest) (*models.HistoryResponse, error) {
+	var resp models.HistoryResponse
+	if err := c.get(ctx, "/api/v1/history", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ==================== META ====================
+
+func (c *Client) Health(ctx context.Context) (*models.HealthResponse, error) {
+	var resp models.HealthResponse
+	if err := c.get(ctx, "/api/v1/health", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListActions returns the actions the caller's permissions allow
+func (c *Client) ListActions(ctx context.Context) ([]Action, error) {
+	var resp []Action
+	if err := c.get(ctx, "/api/v1/actions", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
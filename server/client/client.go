@@ -0,0 +1,341 @@
+// Package client calls the LetterSheets API with typed methods, one per
+// action. It keeps the session token from SelectCompany, SwitchCompany and
+// RefreshSession, refreshes it once when it expires, and retries idempotent
+// calls on transient failures. Register and CreateUser send an
+// Idempotency-Key, so their retries cannot create the account twice.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lettersheets/models"
+
+	"github.com/google/uuid"
+)
+
+type Config struct {
+	// BaseURL is the server root, e.g. "https://api.lettersheets.example"
+	BaseURL string
+
+	// HTTPClient defaults to a client with a 30 second timeout
+	HTTPClient *http.Client
+
+	// MaxRetries bounds the retries of one call; 0 means 3, negative disables retries
+	MaxRetries int
+}
+
+// Client is safe for concurrent use. All calls share its session.
+type Client struct {
+	baseURL    string
+	http       *http.Client
+	maxRetries int
+
+	mu           sync.Mutex
+	token        string
+	refreshToken string
+
+	// refreshing lets one refresh run at a time. A refresh token presented
+	// twice is taken as stolen and ends the session, so concurrent calls
+	// that all got a 401 must not each refresh.
+	refreshing sync.Mutex
+}
+
+func New(cfg Config) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(cfg.BaseURL, "/"),
+		http:       cfg.HTTPClient,
+		maxRetries: cfg.MaxRetries,
+	}
+	if c.http == nil {
+		c.http = &http.Client{Timeout: 30 * time.Second}
+	}
+	if c.maxRetries == 0 {
+		c.maxRetries = 3
+	}
+	return c
+}
+
+// SetTokens resumes a session opened elsewhere; empty strings clear it
+func (c *Client) SetTokens(token, refreshToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token, c.refreshToken = token, refreshToken
+}
+
+// Tokens returns the current session and refresh tokens
+func (c *Client) Tokens() (token, refreshToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token, c.refreshToken
+}
+
+// ==================== ERRORS ====================
+
+// Error is a call the server answered with success = false. Code, Message,
+// Data and Details mirror api.Response; Data carries details such as the
+// reasons a password was rejected. Compare Code, not Message.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Data    json.RawMessage
+
+	// Details lists the rejected fields of a VALIDATION_FAILED error
+	Details []FieldError
+
+	// RetryAfter is set on 429 from the Retry-After header
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("lettersheets: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ErrorCode returns the code of an *Error, or "" for other errors
+func ErrorCode(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+// codeSessionExpired is the code of a 401 for a session token that expired
+// or was revoked; other 401s, such as a wrong password, are not refreshed
+const codeSessionExpired = "AUTH_SESSION_EXPIRED"
+
+// StatusCode returns the HTTP status of an *Error, or 0 for other errors
+func StatusCode(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Status
+	}
+	return 0
+}
+
+// response mirrors api.Response
+type response struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Error   string          `json:"error"`
+	Code    string          `json:"code"`
+	Details []FieldError    `json:"details"`
+}
+
+// ==================== TRANSPORT ====================
+
+// call is one request to a REST route
+type call struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+
+	// idempotent calls are retried on network errors and 502, 503 and 504;
+	// every call is retried on 429, which the server sends before running it
+	idempotent bool
+
+	// public calls do not refresh the session on AUTH_SESSION_EXPIRED
+	public bool
+
+	// idempotencyKey is sent as Idempotency-Key; the server replays the
+	// first response to a retry, which makes the call idempotent
+	idempotencyKey string
+}
+
+func (c *Client) get(ctx context.Context, path string, query interface{}, out interface{}) error {
+	q, err := queryValues(query)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, call{method: http.MethodGet, path: path, query: q, idempotent: true}, out)
+}
+
+func (c *Client) send(ctx context.Context, method, path string, body, out interface{}) error {
+	return c.do(ctx, call{method: method, path: path, body: body}, out)
+}
+
+func (c *Client) sendPublic(ctx context.Context, path string, body, out interface{}) error {
+	return c.do(ctx, call{method: http.MethodPost, path: path, body: body, public: true}, out)
+}
+
+// once marks cl with a fresh Idempotency-Key, shared by all its retries
+func once(cl call) call {
+	cl.idempotencyKey = uuid.New().String()
+	cl.idempotent = true
+	return cl
+}
+
+// do runs cl, refreshing the session once when the server reports it
+// expired, and decodes the data of a successful response into out
+func (c *Client) do(ctx context.Context, cl call, out interface{}) error {
+	sent, _ := c.Tokens()
+	err := c.retry(ctx, cl, out)
+	if cl.public || StatusCode(err) != http.StatusUnauthorized || ErrorCode(err) != codeSessionExpired {
+		return err
+	}
+	if !c.refreshAfter(ctx, sent) {
+		return err
+	}
+	return c.retry(ctx, cl, out)
+}
+
+// refreshAfter renews the session that answered a call sent with token
+// sent, and reports whether there is a new token to retry with. When
+// another call has refreshed in the meantime, its token is used as is.
+func (c *Client) refreshAfter(ctx context.Context, sent string) bool {
+	c.refreshing.Lock()
+	defer c.refreshing.Unlock()
+
+	token, refresh := c.Tokens()
+	if token != sent {
+		return token != ""
+	}
+	if refresh == "" {
+		return false
+	}
+	_, err := c.refreshSession(ctx)
+	return err == nil
+}
+
+func (c *Client) retry(ctx context.Context, cl call, out interface{}) error {
+	var body []byte
+	if cl.body != nil {
+		var err error
+		if body, err = json.Marshal(cl.body); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := c.roundTrip(ctx, cl, body, out)
+		if err == nil || attempt >= c.maxRetries || !retryable(cl, err) {
+			return err
+		}
+
+		wait := time.Duration(100<<attempt) * time.Millisecond
+		var e *Error
+		if errors.As(err, &e) && e.RetryAfter > 0 {
+			wait = e.RetryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+func retryable(cl call, err error) bool {
+	var e *Error
+	if !errors.As(err, &e) {
+		return cl.idempotent && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch e.Status {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusConflict:
+		return cl.idempotencyKey != "" && e.Code == "REQUEST_IN_PROGRESS"
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return cl.idempotent
+	}
+	return false
+}
+
+func (c *Client) roundTrip(ctx context.Context, cl call, body []byte, out interface{}) error {
+	u := c.baseURL + cl.path
+	if len(cl.query) > 0 {
+		u += "?" + cl.query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, cl.method, u, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token, _ := c.Tokens(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if cl.idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", cl.idempotencyKey)
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var resp response
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return &Error{Status: res.StatusCode, Message: http.StatusText(res.StatusCode), RetryAfter: retryAfter(res)}
+	}
+	if !resp.Success || res.StatusCode >= 300 {
+		return &Error{Status: res.StatusCode, Code: resp.Code, Message: resp.Error, Data: resp.Data, Details: resp.Details, RetryAfter: retryAfter(res)}
+	}
+	if out == nil || len(resp.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Data, out)
+}
+
+func retryAfter(res *http.Response) time.Duration {
+	secs, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// queryValues encodes the set fields of a request struct as query
+// parameters under their JSON names
+func queryValues(v interface{}) (url.Values, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// Numbers stay as written: as float64, 1000000 would print as 1e+06
+	fields := map[string]interface{}{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	for name, value := range fields {
+		if value == nil || value == "" {
+			continue
+		}
+		q.Set(name, fmt.Sprint(value))
+	}
+	return q, nil
+}
+
+// keep stores the tokens of a newly opened session
+func (c *Client) keep(session *models.SelectCompanyResponse) {
+	c.SetTokens(session.Token, session.RefreshToken)
+}
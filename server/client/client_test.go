@@ -0,0 +1,268 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"lettersheets/internal/api"
+	"lettersheets/models"
+)
+
+// TestEveryAction checks that each action of the OpenAPI document has a
+// method, named after the action
+func TestEveryAction(t *testing.T) {
+	spec, err := api.OpenAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Paths map[string]map[string]struct {
+			Action string `json:"x-action"`
+		}
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	client := reflect.TypeOf(&Client{})
+	for _, item := range doc.Paths {
+		for _, op := range item {
+			if _, ok := client.MethodByName(methodName(op.Action)); !ok {
+				t.Errorf("no method %s for action %s", methodName(op.Action), op.Action)
+			}
+		}
+	}
+}
+
+var initialisms = map[string]string{"totp": "TOTP", "webauthn": "WebAuthn", "id": "ID"}
+
+func methodName(action string) string {
+	var b strings.Builder
+	for _, word := range strings.Split(action, "_") {
+		if s, ok := initialisms[word]; ok {
+			b.WriteString(s)
+		} else if word != "" {
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return b.String()
+}
+
+func reply(w http.ResponseWriter, status int, data interface{}, msg string) {
+	replyCode(w, status, data, msg, "")
+}
+
+func replyCode(w http.ResponseWriter, status int, data interface{}, msg string, code api.Code) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(api.Response{Success: status < 300, Data: data, Error: msg, Code: code})
+}
+
+func TestSessionAndRetries(t *testing.T) {
+	calls := map[string]int{}
+	keys := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.Method + " " + r.URL.Path
+		calls[route]++
+		switch route {
+		case "POST /api/v1/auth/select-company":
+			reply(w, http.StatusOK, map[string]string{"token": "t1", "refresh_token": "r1"}, "")
+		case "POST /api/v1/auth/refresh":
+			reply(w, http.StatusOK, map[string]string{"token": "t2", "refresh_token": "r2"}, "")
+		case "GET /api/v1/users/me":
+			if calls[route] == 1 {
+				reply(w, http.StatusServiceUnavailable, nil, "unavailable")
+				return
+			}
+			reply(w, http.StatusOK, map[string]string{"id": r.Header.Get("Authorization")}, "")
+		case "PATCH /api/v1/users/me":
+			reply(w, http.StatusServiceUnavailable, nil, "unavailable")
+		case "POST /api/v1/users":
+			keys[r.Header.Get("Idempotency-Key")] = true
+			if calls[route] == 1 {
+				reply(w, http.StatusServiceUnavailable, nil, "unavailable")
+				return
+			}
+			reply(w, http.StatusCreated, map[string]string{"user_id": "u1"}, "")
+		case "GET /api/v1/sessions":
+			if r.Header.Get("Authorization") != "Bearer t2" {
+				replyCode(w, http.StatusUnauthorized, nil, "session expired", api.CodeSessionExpired)
+				return
+			}
+			reply(w, http.StatusOK, []interface{}{}, "")
+		case "PUT /api/v1/users/me/password":
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(api.Response{
+				Data:  map[string]interface{}{"reasons": []string{"too_short"}},
+				Error: "password is too short",
+				Code:  api.CodePasswordRejected,
+			})
+		default:
+			reply(w, http.StatusNotFound, nil, "not found")
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := New(Config{BaseURL: srv.URL})
+
+	if _, err := c.SelectCompany(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads are retried and carry the session token
+	user, err := c.GetUser(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != "Bearer t1" || calls["GET /api/v1/users/me"] != 2 {
+		t.Fatalf("user = %q after %d calls", user.ID, calls["GET /api/v1/users/me"])
+	}
+
+	// Writes are not
+	if _, err := c.UpdateUser(ctx, nil); StatusCode(err) != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v", err)
+	}
+	if n := calls["PATCH /api/v1/users/me"]; n != 1 {
+		t.Fatalf("update_user sent %d times", n)
+	}
+
+	// unless they carry an Idempotency-Key, the same on every attempt
+	created, err := c.CreateUser(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.UserID != "u1" || calls["POST /api/v1/users"] != 2 || len(keys) != 1 || keys[""] {
+		t.Fatalf("create_user = %+v after %d calls with keys %v", created, calls["POST /api/v1/users"], keys)
+	}
+
+	// An expired session is refreshed once
+	if _, err := c.ListSessions(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if token, refresh := c.Tokens(); token != "t2" || refresh != "r2" {
+		t.Fatalf("tokens = %s, %s", token, refresh)
+	}
+
+	// Failures mirror api.Response
+	_, err = c.ChangePassword(ctx, nil)
+	e, ok := err.(*Error)
+	if !ok || e.Status != http.StatusBadRequest || e.Code != string(api.CodePasswordRejected) ||
+		e.Message != "password is too short" || !strings.Contains(string(e.Data), "too_short") {
+		t.Fatalf("err = %#v", err)
+	}
+}
+
+// TestConcurrentRefresh expires the session under several calls at once.
+// The server treats a second use of a refresh token as a replay, as
+// sp_refresh_session does, so only one call may refresh.
+func TestConcurrentRefresh(t *testing.T) {
+	var mu sync.Mutex
+	refreshes := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/auth/refresh":
+			var req models.RefreshSessionRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			refreshes++
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond) // let the other calls get their 401
+			if req.RefreshToken != "r1" {
+				replyCode(w, http.StatusUnauthorized, nil, "refresh token reused", api.CodeTokenReused)
+				return
+			}
+			reply(w, http.StatusOK, map[string]string{"token": "t2", "refresh_token": "r2"}, "")
+		case "/api/v1/sessions":
+			if r.Header.Get("Authorization") != "Bearer t2" {
+				replyCode(w, http.StatusUnauthorized, nil, "session expired", api.CodeSessionExpired)
+				return
+			}
+			reply(w, http.StatusOK, []interface{}{}, "")
+		default:
+			reply(w, http.StatusNotFound, nil, "not found")
+		}
+	}))
+	defer srv.Close()
+
+	c := New(Config{BaseURL: srv.URL})
+	c.SetTokens("t1", "r1")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.ListSessions(context.Background())
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if refreshes != 1 {
+		t.Fatalf("refreshed %d times", refreshes)
+	}
+	if token, refresh := c.Tokens(); token != "t2" || refresh != "r2" {
+		t.Fatalf("tokens = %s, %s", token, refresh)
+	}
+}
+
+// TestUnauthorizedNotExpired checks that a 401 for a wrong password is
+// returned as is, without refreshing the session
+func TestUnauthorizedNotExpired(t *testing.T) {
+	calls := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[r.URL.Path]++
+		switch r.URL.Path {
+		case "/api/v1/users/me/password":
+			replyCode(w, http.StatusUnauthorized, nil, "current password is incorrect", api.CodePasswordIncorrect)
+		case "/api/v1/auth/refresh":
+			reply(w, http.StatusOK, map[string]string{"token": "t2", "refresh_token": "r2"}, "")
+		default:
+			reply(w, http.StatusNotFound, nil, "not found")
+		}
+	}))
+	defer srv.Close()
+
+	c := New(Config{BaseURL: srv.URL})
+	c.SetTokens("t1", "r1")
+
+	_, err := c.ChangePassword(context.Background(), nil)
+	if ErrorCode(err) != string(api.CodePasswordIncorrect) {
+		t.Fatalf("err = %v", err)
+	}
+	if n := calls["/api/v1/users/me/password"]; n != 1 {
+		t.Fatalf("change_password sent %d times", n)
+	}
+	if n := calls["/api/v1/auth/refresh"]; n != 0 {
+		t.Fatalf("refreshed %d times", n)
+	}
+	if token, refresh := c.Tokens(); token != "t1" || refresh != "r1" {
+		t.Fatalf("tokens = %s, %s", token, refresh)
+	}
+}
+
+func TestQueryValues(t *testing.T) {
+	limit, offset := 1000000, 20
+	table := "users"
+	q, err := queryValues(&models.HistoryRequest{TableName: &table, Limit: &limit, Offset: &offset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := q.Encode(); got != "limit=1000000&offset=20&table_name=users" {
+		t.Fatalf("query = %s", got)
+	}
+}
@@ -0,0 +1,64 @@
+package client_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestBuildsOutsideModule compiles a program in another module that calls
+// the client with the request and response types, as internal tools do.
+// It fails if the client's signatures use a package under internal/.
+func TestBuildsOutsideModule(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a separate module")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	server, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := os.ReadFile(filepath.Join(server, "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/tool\n\ngo 1.22\n\n" +
+			"require lettersheets v0.0.0\n\nreplace lettersheets => " + server + "\n",
+		"go.sum": string(sum),
+		"main.go": `package main
+
+import (
+	"context"
+
+	"lettersheets/client"
+	"lettersheets/models"
+)
+
+func main() {
+	c := client.New(client.Config{BaseURL: "http://localhost:8080"})
+	var login *models.LoginResponse
+	login, _ = c.Login(context.Background(), &models.LoginRequest{Email: "a@example.com", Password: "pw"})
+	_ = login
+}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(gobin, "build", "-mod=mod", "-o", os.DevNull, ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=", "GOPROXY=off", "GOWORK=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("build outside the module failed: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,25 @@
+// Command openapi writes the OpenAPI document of the server to the given
+// path, or to stdout. Run it through go generate ./internal/api.
+package main
+
+import (
+	"log"
+	"os"
+
+	"lettersheets/internal/api"
+)
+
+func main() {
+	spec, err := api.OpenAPI()
+	if err != nil {
+		log.Fatal("Failed to build OpenAPI document: ", err)
+	}
+
+	if len(os.Args) < 2 {
+		os.Stdout.Write(spec)
+		return
+	}
+	if err := os.WriteFile(os.Args[1], spec, 0644); err != nil {
+		log.Fatal("Failed to write OpenAPI document: ", err)
+	}
+}
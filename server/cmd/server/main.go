@@ -7,6 +7,9 @@ import (
 	"lettersheets/internal/api"
 	"lettersheets/internal/config"
 	"lettersheets/internal/database"
+	"lettersheets/internal/mail"
+	"lettersheets/internal/password"
+	"lettersheets/internal/ratelimit"
 	"lettersheets/internal/repository"
 )
 
@@ -24,6 +27,16 @@ func main() {
 	defer db.Close()
 	log.Println("Connected to database")
 
+	breached, err := password.OpenBreachList(cfg.Server.BreachedPasswordsDir)
+	if err != nil {
+		log.Fatal("Failed to open breached password list: ", err)
+	}
+
+	var limiter ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimit.Store == "mysql" {
+		limiter = repository.NewRateLimitRepo(db)
+	}
+
 	handler := api.NewHandler(
 		repository.NewRegistrationRepo(db),
 		repository.NewCompanyRepo(db),
@@ -31,30 +44,40 @@ func main() {
 		repository.NewAccessRepo(db),
 		repository.NewSessionRepo(db),
 		repository.NewChangeHistoryRepo(db),
+		repository.NewAuthTokenRepo(db),
+		repository.NewWebAuthnRepo(db),
+		repository.NewIdempotencyRepo(db),
+		mail.New(cfg.Mail.ToMailConfig()),
+		breached,
+		limiter,
 		cfg,
 	)
 
-	http.HandleFunc("/api/execute", cors(handler.Execute))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/execute", handler.Execute)
+	handler.Routes(mux)
 
 	addr := cfg.Server.Addr()
 	log.Printf("Server starting on %s", addr)
 	log.Printf("Endpoint: POST %s/api/execute?action=<action>", addr)
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	log.Printf("REST: %s/api/v1/...", addr)
+	if err := http.ListenAndServe(addr, cors(mux)); err != nil {
 		log.Fatal("Server failed: ", err)
 	}
 }
 
-func cors(next http.HandlerFunc) http.HandlerFunc {
+func cors(next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-None-Match, Idempotency-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "Retry-After, ETag, Idempotent-Replayed")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
 
-		next(w, r)
+		next.ServeHTTP(w, r)
 	}
 }
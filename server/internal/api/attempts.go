@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+
+	"lettersheets/internal/permission"
+	"lettersheets/models"
+
+	"github.com/google/uuid"
+)
+
+// ==================== LOGIN HISTORY ====================
+
+// recordLogin logs a login attempt; best effort, a failure never blocks login
+func (h *Handler) recordLogin(r *http.Request, userID, email, outcome, reason string) {
+	ip := clientIP(r)
+	ua := r.UserAgent()
+	_ = h.userRepo.RecordLoginAttempt(r.Context(), &models.LoginAttempt{
+		ID:        uuid.New().String(),
+		UserID:    strPtr(userID),
+		Email:     email,
+		Outcome:   outcome,
+		Reason:    reason,
+		IPAddress: &ip,
+		UserAgent: &ua,
+	})
+}
+
+// getLoginHistory returns the caller's own login attempts, or, for admins,
+// those of any user in the company together with their lockout state
+func (h *Handler) getLoginHistory(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.LoginHistoryRequest) {
+	userID := req.UserID
+	if userID == "" {
+		userID = session.UserID
+	}
+	if userID != session.UserID && !h.permissions(session).Has(permission.LoginHistoryRead) {
+		ErrorCode(w, http.StatusForbidden, CodePermissionDenied, "insufficient permissions")
+		return
+	}
+
+	limit := 50
+	if req.Limit != nil && *req.Limit > 0 && *req.Limit <= 200 {
+		limit = *req.Limit
+	}
+
+	offset := 0
+	if req.Offset != nil && *req.Offset >= 0 {
+		offset = *req.Offset
+	}
+
+	attempts, lockedUntil, found, err := h.userRepo.GetLockState(r.Context(), tenant(session), userID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to get login history")
+		return
+	}
+	if !found {
+		Error(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	history, err := h.userRepo.GetLoginHistory(r.Context(), tenant(session), userID, limit, offset)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to get login history")
+		return
+	}
+
+	JSON(w, http.StatusOK, models.LoginHistoryResponse{
+		Records:             history,
+		FailedLoginAttempts: attempts,
+		LockedUntil:         lockedUntil,
+		Limit:               limit,
+		Offset:              offset,
+	})
+}
+
+// unlockUser clears a lockout and the failed attempt counter
+func (h *Handler) unlockUser(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.UserIDRequest) {
+	meta := getMeta(r, session)
+	unlocked, err := h.userRepo.Unlock(r.Context(), tenant(session), req.UserID, meta)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to unlock user")
+		return
+	}
+	if !unlocked {
+		Error(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	JSON(w, http.StatusOK, models.MessageResponse{Message: "user unlocked"})
+}
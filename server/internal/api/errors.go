@@ -0,0 +1,136 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"lettersheets/internal/repository"
+)
+
+// Code is the stable, machine-readable kind of an error response
+type Code string
+
+// Generic codes, used when no specific code applies
+const (
+	CodeInvalidRequest   Code = "INVALID_REQUEST"
+	CodeUnauthorized     Code = "UNAUTHORIZED"
+	CodeForbidden        Code = "FORBIDDEN"
+	CodeNotFound         Code = "NOT_FOUND"
+	CodeMethodNotAllowed Code = "METHOD_NOT_ALLOWED"
+	CodeConflict         Code = "CONFLICT"
+	CodeRateLimited      Code = "RATE_LIMITED"
+	CodeInternal         Code = "INTERNAL_ERROR"
+	CodeUnavailable      Code = "SERVICE_UNAVAILABLE"
+)
+
+// Request codes
+const (
+	CodeInvalidBody      Code = "INVALID_BODY"
+	CodeUnknownAction    Code = "UNKNOWN_ACTION"
+	CodeValidationFailed Code = "VALIDATION_FAILED"
+
+	// An Idempotency-Key sent again with another request, or while its first request runs
+	CodeIdempotencyKeyReused Code = "IDEMPOTENCY_KEY_REUSED"
+	CodeRequestInProgress    Code = "REQUEST_IN_PROGRESS"
+)
+
+// Authentication codes
+const (
+	CodeAuthRequired       Code = "AUTH_REQUIRED"
+	CodeSessionExpired     Code = "AUTH_SESSION_EXPIRED"
+	CodeInvalidCredentials Code = "AUTH_INVALID_CREDENTIALS"
+	CodeMFARequired        Code = "AUTH_MFA_REQUIRED"
+	CodeInvalidMFA         Code = "AUTH_INVALID_MFA"
+	CodeInvalidToken       Code = "AUTH_INVALID_TOKEN"
+	CodeTokenReused        Code = "AUTH_TOKEN_REUSED"
+	CodeAccountLocked      Code = "ACCOUNT_LOCKED"
+	CodeAccountDeactivated Code = "ACCOUNT_DEACTIVATED"
+)
+
+// Password and policy codes
+const (
+	CodePasswordIncorrect     Code = "PASSWORD_INCORRECT"
+	CodePasswordRejected      Code = "PASSWORD_REJECTED"
+	CodePasswordExpired       Code = "PASSWORD_EXPIRED"
+	CodeMFAEnrollmentRequired Code = "MFA_ENROLLMENT_REQUIRED"
+	CodeIPNotAllowed          Code = "IP_NOT_ALLOWED"
+)
+
+// Authorization and data codes
+const (
+	CodePermissionDenied Code = "PERMISSION_DENIED"
+	CodeNoCompanyAccess  Code = "NO_COMPANY_ACCESS"
+	CodeEmailTaken       Code = "EMAIL_TAKEN"
+	CodeUsernameTaken    Code = "USERNAME_TAKEN"
+)
+
+// Codes lists every code, for the OpenAPI document
+var Codes = []Code{
+	CodeInvalidRequest, CodeUnauthorized, CodeForbidden, CodeNotFound, CodeMethodNotAllowed,
+	CodeConflict, CodeRateLimited, CodeInternal, CodeUnavailable,
+	CodeInvalidBody, CodeUnknownAction, CodeValidationFailed, CodeIdempotencyKeyReused, CodeRequestInProgress,
+	CodeAuthRequired, CodeSessionExpired, CodeInvalidCredentials, CodeMFARequired, CodeInvalidMFA,
+	CodeInvalidToken, CodeTokenReused, CodeAccountLocked, CodeAccountDeactivated,
+	CodePasswordIncorrect, CodePasswordRejected, CodePasswordExpired, CodeMFAEnrollmentRequired, CodeIPNotAllowed,
+	CodePermissionDenied, CodeNoCompanyAccess, CodeEmailTaken, CodeUsernameTaken,
+}
+
+// FieldError is one rejected field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (f *FieldError) Error() string {
+	return f.Field + ": " + f.Message
+}
+
+// statusCode is the generic code of an HTTP status
+func statusCode(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+	return CodeInternal
+}
+
+// uniqueKeyCodes names the value a unique key violation means is taken
+var uniqueKeyCodes = map[string]Code{
+	"uk_users_email":    CodeEmailTaken,
+	"uk_users_username": CodeUsernameTaken,
+}
+
+var uniqueKeyMessages = map[Code]string{
+	CodeEmailTaken:    "email already registered",
+	CodeUsernameTaken: "username already taken",
+}
+
+// writeFailed answers a failed write. A unique key violation is a 409 saying
+// what is taken; anything else is logged and reported only as msg, so
+// database errors never reach the client.
+func writeFailed(w http.ResponseWriter, err error, msg string) {
+	if key, ok := repository.DuplicateKey(err); ok {
+		if code, ok := uniqueKeyCodes[key]; ok {
+			ErrorCode(w, http.StatusConflict, code, uniqueKeyMessages[code])
+			return
+		}
+		Error(w, http.StatusConflict, "already exists")
+		return
+	}
+	log.Printf("%s: %v", msg, err)
+	Error(w, http.StatusInternalServerError, msg)
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestWriteFailed(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   Code
+	}{
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a@example.com' for key 'users.uk_users_email'"}, http.StatusConflict, CodeEmailTaken},
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'alice' for key 'uk_users_username'"}, http.StatusConflict, CodeUsernameTaken},
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'x' for key 'uk_other'"}, http.StatusConflict, CodeConflict},
+		{&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row"}, http.StatusInternalServerError, CodeInternal},
+		{errors.New("dial tcp 10.0.0.5:3306: connection refused"), http.StatusInternalServerError, CodeInternal},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		writeFailed(rec, c.err, "registration failed")
+
+		var resp Response
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != c.status || resp.Code != c.code {
+			t.Errorf("%v: got %d %s, want %d %s", c.err, rec.Code, resp.Code, c.status, c.code)
+		}
+		for _, internal := range []string{"Duplicate", "for key", "child row", "3306"} {
+			if strings.Contains(rec.Body.String(), internal) {
+				t.Errorf("%v: response leaks %q: %s", c.err, internal, rec.Body.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"lettersheets/models"
+)
+
+// fakeDB answers the stored procedure calls of the tenant tests from memory.
+// Each procedure matches rows the way its migration does, so the tests check
+// that handlers and repositories pass the session's company to every call.
+type fakeDB struct {
+	mu       sync.Mutex
+	members  []*fakeMember
+	sessions []*fakeSession
+
+	// idempotency keys by scope + "/" + key
+	idempotent map[string]*fakeIdempotent
+
+	// passkey login challenges by hash and credentials by ID, to user ID
+	challenges  map[string]string
+	credentials map[string]string
+
+	// COSE public keys by credential ID, and the credential IDs whose
+	// signature counter was stored
+	credentialKeys  map[string][]byte
+	usedCredentials []string
+
+	// users that sign in by email, by email
+	users map[string]*fakeUser
+
+	loginAttempts []fakeLoginAttempt
+}
+
+type fakeUser struct {
+	id          string
+	active      bool
+	lockedUntil driver.Value // nil or a time.Time
+}
+
+type fakeLoginAttempt struct {
+	userID  driver.Value // nil when the user is unknown
+	email   string
+	outcome string
+	reason  string
+}
+
+type fakeMember struct {
+	accessID  string
+	userID    string
+	companyID string
+	role      string
+	active    bool
+}
+
+type fakeIdempotent struct {
+	requestHash string
+	status      driver.Value // nil while running
+	body        []byte
+}
+
+type fakeSession struct {
+	id        string
+	tokenHash string
+	userID    string
+	companyID string
+	active    bool
+}
+
+// fakeJoinedAt is when every member joined, so listings are stable
+var fakeJoinedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	fakeDBs   = map[string]*fakeDB{}
+	fakeDBsMu sync.Mutex
+	fakeSeq   int
+)
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+// openFakeDB returns a *sql.DB backed by a fresh fakeDB
+func openFakeDB() (*sql.DB, *fakeDB) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	fakeSeq++
+	name := fmt.Sprintf("fake-%d", fakeSeq)
+	f := &fakeDB{
+		idempotent:     map[string]*fakeIdempotent{},
+		challenges:     map[string]string{},
+		credentials:    map[string]string{},
+		credentialKeys: map[string][]byte{},
+		users:          map[string]*fakeUser{},
+	}
+	fakeDBs[name] = f
+	db, _ := sql.Open("fakedb", name)
+	return db, f
+}
+
+func (f *fakeDB) member(accessID, userID, companyID, role string) {
+	f.members = append(f.members, &fakeMember{accessID: accessID, userID: userID, companyID: companyID, role: role, active: true})
+}
+
+func (f *fakeDB) session(id, tokenHash, userID, companyID string) {
+	f.sessions = append(f.sessions, &fakeSession{id: id, tokenHash: tokenHash, userID: userID, companyID: companyID, active: true})
+}
+
+func (f *fakeDB) accessActive(accessID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range f.members {
+		if m.accessID == accessID {
+			return m.active
+		}
+	}
+	return false
+}
+
+func (f *fakeDB) sessionActive(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range f.sessions {
+		if s.id == id {
+			return s.active
+		}
+	}
+	return false
+}
+
+var callPattern = regexp.MustCompile(`^CALL (\w+)\(`)
+
+// call runs one procedure and returns its result set
+func (f *fakeDB) call(query string, args []driver.Value) (*fakeRows, error) {
+	m := callPattern.FindStringSubmatch(query)
+	if m == nil {
+		return nil, fmt.Errorf("fakedb: unsupported query %q", query)
+	}
+	str := func(i int) string {
+		s, _ := args[i].(string)
+		return s
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch m[1] {
+	case "sp_validate_session":
+		for _, s := range f.sessions {
+			if s.tokenHash != str(0) || !s.active {
+				continue
+			}
+			for _, mb := range f.members {
+				if mb.userID == s.userID && mb.companyID == s.companyID && mb.active {
+					now := time.Now()
+					return oneRow(s.id, s.userID, s.companyID, s.id,
+						now.Add(time.Hour), now.Add(8*time.Hour),
+						s.userID+"@example.com", s.userID, true,
+						mb.role, nil, []byte("key"), "AES-256-KW", int64(1), []byte("pub")), nil
+				}
+			}
+		}
+		return noRows(), nil
+
+	case "sp_get_security_policy":
+		return noRows(), nil
+
+	case "sp_update_user_company_access":
+		mb := f.activeAccess(str(0), str(7))
+		if mb != nil && args[1] != nil {
+			mb.role = str(1)
+		}
+		return flag("updated", mb != nil), nil
+
+	case "sp_delete_user_company_access":
+		mb := f.activeAccess(str(0), str(1))
+		if mb != nil {
+			mb.active = false
+			f.endSessions(mb.userID, mb.companyID)
+		}
+		return flag("revoked", mb != nil), nil
+
+	case "sp_delete_user":
+		mb := f.activeMember(str(0), str(1))
+		if mb != nil {
+			mb.active = false
+			f.endSessions(mb.userID, mb.companyID)
+		}
+		return flag("removed", mb != nil), nil
+
+	case "sp_unlock_user":
+		return flag("unlocked", f.activeMember(str(0), str(1)) != nil), nil
+
+	case "sp_get_user_lock_state":
+		for _, mb := range f.members {
+			if mb.userID == str(0) && mb.companyID == str(1) {
+				return oneRow(int64(0), nil), nil
+			}
+		}
+		return noRows(), nil
+
+	case "sp_begin_idempotent_request":
+		k := str(0) + "/" + str(1)
+		row, found := f.idempotent[k]
+		if !found {
+			row = &fakeIdempotent{requestHash: str(3)}
+			f.idempotent[k] = row
+		}
+		return oneRow(!found, row.requestHash, row.status, row.body), nil
+
+	case "sp_complete_idempotent_request":
+		if row := f.idempotent[str(0)+"/"+str(1)]; row != nil && row.status == nil {
+			row.status, row.body = args[2], args[3].([]byte)
+		}
+		return noRows(), nil
+
+	case "sp_release_idempotent_request":
+		k := str(0) + "/" + str(1)
+		if row := f.idempotent[k]; row != nil && row.status == nil {
+			delete(f.idempotent, k)
+		}
+		return noRows(), nil
+
+	case "sp_create_webauthn_challenge":
+		f.challenges[str(2)] = str(1)
+		return noRows(), nil
+
+	case "sp_consume_webauthn_challenge":
+		userID, found := f.challenges[str(0)]
+		delete(f.challenges, str(0))
+		var user driver.Value
+		if userID != "" {
+			user = userID
+		}
+		return oneRow(found, user), nil
+
+	case "sp_get_webauthn_credential":
+		credID, _ := args[0].([]byte)
+		userID, found := f.credentials[string(credID)]
+		if !found {
+			return noRows(), nil
+		}
+		publicKey, found := f.credentialKeys[string(credID)]
+		if !found {
+			publicKey = []byte("not a COSE key")
+		}
+		return oneRow(string(credID), userID, credID, publicKey, int64(0),
+			"Laptop", nil, fakeJoinedAt, nil, userID+"@example.com"), nil
+
+	case "sp_use_webauthn_credential":
+		f.usedCredentials = append(f.usedCredentials, str(0))
+		return flag("accepted", true), nil
+
+	case "sp_record_login_attempt":
+		f.loginAttempts = append(f.loginAttempts, fakeLoginAttempt{userID: args[1], email: str(2), outcome: str(3), reason: str(4)})
+		return noRows(), nil
+
+	case "sp_get_user_by_email":
+		u := f.users[str(0)]
+		if u == nil {
+			return noRows(), nil
+		}
+		return oneRow(u.id, str(0), u.id, "hash", "salt", nil,
+			u.active, int64(0), u.lockedUntil, nil, fakeJoinedAt, fakeJoinedAt), nil
+
+	case "sp_register":
+		// company, user and access IDs are arguments 0, 8 and 13
+		f.members = append(f.members, &fakeMember{accessID: str(13), userID: str(8), companyID: str(0), role: models.RoleSuperAdmin, active: true})
+		return noRows(), nil
+
+	case "sp_get_login_history", "sp_get_change_history":
+		return noRows(), nil
+
+	case "sp_get_company_users":
+		rows := &fakeRows{}
+		for _, mb := range f.members {
+			if mb.companyID == str(0) && mb.active {
+				rows.data = append(rows.data, []driver.Value{
+					mb.accessID, mb.userID, mb.role, nil, fakeJoinedAt,
+					mb.userID + "@example.com", mb.userID, nil,
+				})
+			}
+		}
+		return rows, nil
+
+	case "sp_get_user_sessions":
+		rows := &fakeRows{}
+		for _, s := range f.sessions {
+			if s.userID == str(0) && (args[1] == nil || s.companyID == str(1)) && s.active {
+				now := time.Now()
+				rows.data = append(rows.data, []driver.Value{
+					s.id, s.userID, s.companyID, nil, nil,
+					now, now, now, now, nil,
+				})
+			}
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("fakedb: unexpected procedure %s", m[1])
+}
+
+func (f *fakeDB) activeAccess(accessID, companyID string) *fakeMember {
+	for _, mb := range f.members {
+		if mb.accessID == accessID && mb.companyID == companyID && mb.active {
+			return mb
+		}
+	}
+	return nil
+}
+
+func (f *fakeDB) activeMember(userID, companyID string) *fakeMember {
+	for _, mb := range f.members {
+		if mb.userID == userID && mb.companyID == companyID && mb.active {
+			return mb
+		}
+	}
+	return nil
+}
+
+func (f *fakeDB) endSessions(userID, companyID string) {
+	for _, s := range f.sessions {
+		if s.userID == userID && s.companyID == companyID {
+			s.active = false
+		}
+	}
+}
+
+// ==================== DRIVER ====================
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	f, ok := fakeDBs[name]
+	if !ok {
+		return nil, fmt.Errorf("fakedb: unknown database %s", name)
+	}
+	return &fakeConn{db: f}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("fakedb: transactions not supported")
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.db.call(query, values(args))
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if _, err := c.db.call(query, values(args)); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+// CheckNamedValue accepts any argument type, as the MySQL driver does
+func (c *fakeConn) CheckNamedValue(nv *driver.NamedValue) error {
+	switch v := nv.Value.(type) {
+	case *string:
+		if v == nil {
+			nv.Value = nil
+		} else {
+			nv.Value = *v
+		}
+	case *int:
+		if v == nil {
+			nv.Value = nil
+		} else {
+			nv.Value = int64(*v)
+		}
+	case int:
+		nv.Value = int64(v)
+	}
+	return nil
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	v := make([]driver.Value, len(args))
+	for i, a := range args {
+		v[i] = a.Value
+	}
+	return v
+}
+
+type fakeRows struct {
+	cols []string
+	data [][]driver.Value
+	pos  int
+}
+
+func noRows() *fakeRows {
+	return &fakeRows{}
+}
+
+func oneRow(v ...driver.Value) *fakeRows {
+	return &fakeRows{data: [][]driver.Value{v}}
+}
+
+func flag(name string, set bool) *fakeRows {
+	v := int64(0)
+	if set {
+		v = 1
+	}
+	return &fakeRows{cols: []string{name}, data: [][]driver.Value{{v}}}
+}
+
+func (r *fakeRows) Columns() []string {
+	if r.cols != nil {
+		return r.cols
+	}
+	n := 0
+	if len(r.data) > 0 {
+		n = len(r.data[0])
+	}
+	cols := make([]string, n)
+	for i := range cols {
+		cols[i] = fmt.Sprintf("c%d", i)
+	}
+	return cols
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.data) {
+		return io.EOF
+	}
+	copy(dest, r.data[r.pos])
+	r.pos++
+	return nil
+}
@@ -1,26 +1,38 @@
 package api
 
 import (
-	"fmt"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"net/http"
 	"time"
 
 	"lettersheets/internal/config"
-	"lettersheets/internal/models"
+	"lettersheets/internal/mail"
+	"lettersheets/internal/password"
+	"lettersheets/internal/ratelimit"
 	"lettersheets/internal/repository"
+	"lettersheets/models"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/argon2"
 )
 
 type Handler struct {
-	regRepo     *repository.RegistrationRepo
-	companyRepo *repository.CompanyRepo
-	userRepo    *repository.UserRepo
-	accessRepo  *repository.AccessRepo
-	sessionRepo *repository.SessionRepo
-	historyRepo *repository.ChangeHistoryRepo
-	cfg         *config.AppConfig
+	regRepo         *repository.RegistrationRepo
+	companyRepo     *repository.CompanyRepo
+	userRepo        *repository.UserRepo
+	accessRepo      *repository.AccessRepo
+	sessionRepo     *repository.SessionRepo
+	historyRepo     *repository.ChangeHistoryRepo
+	tokenRepo       *repository.AuthTokenRepo
+	webauthnRepo    *repository.WebAuthnRepo
+	idempotencyRepo *repository.IdempotencyRepo
+	mailer          mail.Sender
+	breached        *password.BreachList
+	limiter         ratelimit.Store
+	cfg             *config.AppConfig
+	registry        map[string]*Action
 }
 
 func NewHandler(
@@ -30,17 +42,31 @@ func NewHandler(
 	accessRepo *repository.AccessRepo,
 	sessionRepo *repository.SessionRepo,
 	historyRepo *repository.ChangeHistoryRepo,
+	tokenRepo *repository.AuthTokenRepo,
+	webauthnRepo *repository.WebAuthnRepo,
+	idempotencyRepo *repository.IdempotencyRepo,
+	mailer mail.Sender,
+	breached *password.BreachList,
+	limiter ratelimit.Store,
 	cfg *config.AppConfig,
 ) *Handler {
-	return &Handler{
-		regRepo:     regRepo,
-		companyRepo: companyRepo,
-		userRepo:    userRepo,
-		accessRepo:  accessRepo,
-		sessionRepo: sessionRepo,
-		historyRepo: historyRepo,
-		cfg:         cfg,
-	}
+	h := &Handler{
+		regRepo:         regRepo,
+		companyRepo:     companyRepo,
+		userRepo:        userRepo,
+		accessRepo:      accessRepo,
+		sessionRepo:     sessionRepo,
+		historyRepo:     historyRepo,
+		tokenRepo:       tokenRepo,
+		webauthnRepo:    webauthnRepo,
+		idempotencyRepo: idempotencyRepo,
+		mailer:          mailer,
+		breached:        breached,
+		limiter:         limiter,
+		cfg:             cfg,
+	}
+	h.registry = registry(h.actions())
+	return h
 }
 
 // POST /api/execute?action=xxx
@@ -50,96 +76,64 @@ func (h *Handler) Execute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	action := r.URL.Query().Get("action")
-	if action == "" {
+	name := r.URL.Query().Get("action")
+	if name == "" {
 		Error(w, http.StatusBadRequest, "action parameter is required")
 		return
 	}
+	action, ok := h.registry[name]
+	if !ok {
+		ErrorCode(w, http.StatusBadRequest, CodeUnknownAction, "unknown action: "+name)
+		return
+	}
 
-	switch action {
-
-	// ==================== PUBLIC ====================
-
-	case "register":
-		h.register(w, r)
-
-	case "login":
-		h.login(w, r)
-
-	case "select_company":
-		h.selectCompany(w, r)
-
-	case "health":
-		JSON(w, http.StatusOK, map[string]string{"status": "ok"})
-
-	// ==================== PROTECTED ====================
-
-	case "logout":
-		h.withAuth(w, r, h.logout)
-
-	case "logout_all":
-		h.withAuth(w, r, h.logoutAll)
-
-	// Company
-	case "get_company":
-		h.withAuth(w, r, h.getCompany)
-
-	case "update_company":
-		h.withAuth(w, r, h.updateCompany)
-
-	case "delete_company":
-		h.withAuth(w, r, h.deleteCompany)
-
-	// User
-	case "get_user":
-		h.withAuth(w, r, h.getUser)
-
-	case "update_user":
-		h.withAuth(w, r, h.updateUser)
-
-	case "change_password":
-		h.withAuth(w, r, h.changePassword)
-
-	case "delete_user":
-		h.withAuth(w, r, h.deleteUser)
-
-	case "list_users":
-		h.withAuth(w, r, h.listUsers)
-
-	case "create_user":
-		h.withAuth(w, r, h.createUser)
-
-	// Access
-	case "get_user_companies":
-		h.withAuth(w, r, h.getUserCompanies)
-
-	case "update_user_access":
-		h.withAuth(w, r, h.updateUserAccess)
-
-	case "revoke_user_access":
-		h.withAuth(w, r, h.revokeUserAccess)
+	h.serve(w, r, action)
+}
 
-	// History
-	case "get_history":
-		h.withAuth(w, r, h.getHistory)
+// serve runs action and writes its access log line
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, action *Action) {
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	start := time.Now()
+	session := h.dispatch(rec, r, action)
+	logAction(r, action, session, rec.status, time.Since(start))
+}
 
-	case "reset_password":
-		h.resetPassword(w, r)
+// dispatch runs action for an allowed caller and returns its session, if any
+func (h *Handler) dispatch(w http.ResponseWriter, r *http.Request, action *Action) *models.UserSession {
+	if action.Public {
+		if action.RateLimited && !h.rateLimit(w, r, action.Name) {
+			return nil
+		}
+		h.runIdempotent(w, r, action, nil)
+		return nil
+	}
 
-	default:
-		Error(w, http.StatusBadRequest, "unknown action: "+action)
+	session := h.authenticate(w, r)
+	if session == nil {
+		return nil
+	}
+	if !h.enforcePolicy(w, r, session, action) {
+		return session
+	}
+	if !action.allows(h.permissions(session)) {
+		ErrorCode(w, http.StatusForbidden, CodePermissionDenied, "insufficient permissions")
+		return session
 	}
+	h.runIdempotent(w, r, action, session)
+	return session
 }
 
 // ==================== AUTH HELPER ====================
 
 type authedHandler func(w http.ResponseWriter, r *http.Request, session *models.UserSession)
 
-func (h *Handler) withAuth(w http.ResponseWriter, r *http.Request, fn authedHandler) {
+// authenticate validates the bearer token, writing a 401 and returning nil
+// when there is no live session
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request) *models.UserSession {
 	token := r.Header.Get("Authorization")
 	if token == "" {
-		Error(w, http.StatusUnauthorized, "missing authorization header")
-		return
+		ErrorCode(w, http.StatusUnauthorized, CodeAuthRequired, "missing authorization header")
+		return nil
 	}
 
 	// Support "Bearer <token>"
@@ -147,17 +141,21 @@ func (h *Handler) withAuth(w http.ResponseWriter, r *http.Request, fn authedHand
 		token = token[7:]
 	}
 
-	session, err := h.sessionRepo.Validate(r.Context(), token)
+	session, err := h.sessionRepo.Validate(r.Context(), hashToken(token), h.cfg.Server.IdleTimeoutMinutes)
 	if err != nil {
 		Error(w, http.StatusInternalServerError, "session validation failed")
-		return
+		return nil
 	}
 	if session == nil {
-		Error(w, http.StatusUnauthorized, "invalid or expired session")
-		return
+		ErrorCode(w, http.StatusUnauthorized, CodeSessionExpired, "invalid or expired session")
+		return nil
 	}
+	return session
+}
 
-	fn(w, r, session)
+// tenant scopes repository calls to the session's company
+func tenant(session *models.UserSession) repository.Scope {
+	return repository.InCompany(session.CompanyID)
 }
 
 func getMeta(r *http.Request, session *models.UserSession) *models.RequestMeta {
@@ -172,19 +170,9 @@ func getMeta(r *http.Request, session *models.UserSession) *models.RequestMeta {
 
 // ==================== REGISTER ====================
 
-func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
-	var req models.RegisterRequest
-	if err := Decode(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
-		return
-	}
-
-	if req.CompanyName == "" || req.Email == "" || req.Username == "" || req.Password == "" {
-		Error(w, http.StatusBadRequest, "company_name, email, username, and password are required")
-		return
-	}
-	if len(req.WrappedCompanyKey) == 0 || len(req.PublicKey) == 0 {
-		Error(w, http.StatusBadRequest, "wrapped_company_key and public_key are required")
+func (h *Handler) register(w http.ResponseWriter, r *http.Request, req *models.RegisterRequest) {
+	if reasons := h.checkPassword(req.Password, nil, req.Email, req.Username, req.CompanyName); len(reasons) > 0 {
+		rejectPassword(w, reasons)
 		return
 	}
 
@@ -194,7 +182,7 @@ func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if existing != nil {
-		Error(w, http.StatusConflict, "email already registered")
+		ErrorCode(w, http.StatusConflict, CodeEmailTaken, "email already registered")
 		return
 	}
 
@@ -202,7 +190,7 @@ func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
 	userID := uuid.New().String()
 	accessID := uuid.New().String()
 	salt := uuid.New().String()
-	passwordHash := hashPassword(req.Password, salt)
+	passwordHash := h.hashPassword(req.Password, salt)
 
 	err = h.regRepo.Register(r.Context(), &repository.RegisterParams{
 		CompanyID:       companyID,
@@ -229,59 +217,123 @@ func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
 		UserAgent: r.UserAgent(),
 	})
 	if err != nil {
-		Error(w, http.StatusInternalServerError, "registration failed: "+err.Error())
+		writeFailed(w, err, "registration failed")
 		return
 	}
 
-	JSON(w, http.StatusCreated, map[string]interface{}{
-		"company_id": companyID,
-		"user_id":    userID,
-		"access_id":  accessID,
-		"salt":       salt,
+	JSON(w, http.StatusCreated, models.RegisterResponse{
+		CompanyID: companyID,
+		UserID:    userID,
+		AccessID:  accessID,
+		Salt:      salt,
 	})
 }
 
 // ==================== LOGIN ====================
 
-func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
-	var req models.LoginRequest
-	if err := Decode(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
-		return
-	}
-
-	if req.Email == "" || req.Password == "" {
-		Error(w, http.StatusBadRequest, "email and password are required")
-		return
-	}
-
+func (h *Handler) login(w http.ResponseWriter, r *http.Request, req *models.LoginRequest) {
 	user, err := h.userRepo.GetByEmail(r.Context(), req.Email)
 	if err != nil {
 		Error(w, http.StatusInternalServerError, "login failed")
 		return
 	}
 	if user == nil {
-		Error(w, http.StatusUnauthorized, "invalid credentials")
+		h.recordLogin(r, "", req.Email, models.LoginFailure, models.LoginReasonUnknownUser)
+		ErrorCode(w, http.StatusUnauthorized, CodeInvalidCredentials, "invalid credentials")
 		return
 	}
 
 	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
-		Error(w, http.StatusForbidden, "account is locked, try again later")
+		h.recordLogin(r, user.ID, user.Email, models.LoginBlocked, models.LoginReasonLocked)
+		ErrorCode(w, http.StatusForbidden, CodeAccountLocked, "account is locked, try again later")
 		return
 	}
 
 	if !user.IsActive {
-		Error(w, http.StatusForbidden, "account is deactivated")
+		h.recordLogin(r, user.ID, user.Email, models.LoginBlocked, models.LoginReasonDeactivated)
+		ErrorCode(w, http.StatusForbidden, CodeAccountDeactivated, "account is deactivated")
+		return
+	}
+
+	// The strictest lockout policy among the user's companies applies
+	policies, err := h.companyRepo.GetUserSecurityPolicies(r.Context(), user.ID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "login failed")
 		return
 	}
+	maxAttempts, lockoutMinutes := h.loginLimits(policies)
 
-	if !verifyPassword(req.Password, user.Salt, user.PasswordHash) {
-		_ = h.userRepo.LoginFailure(r.Context(), user.ID, h.cfg.Server.MaxLoginAttempts, h.cfg.Server.LockoutMinutes)
-		Error(w, http.StatusUnauthorized, "invalid credentials")
+	ok, rehash := password.Verify(req.Password, user.Salt, user.PasswordHash, h.cfg.Server.PasswordParams())
+	if !ok {
+		_ = h.userRepo.LoginFailure(r.Context(), user.ID, maxAttempts, lockoutMinutes)
+		h.recordLogin(r, user.ID, user.Email, models.LoginFailure, models.LoginReasonInvalidPassword)
+		ErrorCode(w, http.StatusUnauthorized, CodeInvalidCredentials, "invalid credentials")
 		return
 	}
 
+	passkeys, err := h.webauthnRepo.ListByUser(r.Context(), user.ID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "login failed")
+		return
+	}
+
+	// Second factor when enrolled: passkey, TOTP code or one-time backup code
+	var backupCodesRemaining *int
+	method := models.LoginReasonPassword
+	if user.TOTPSecretEnc != nil || len(passkeys) > 0 {
+		if len(req.WebAuthn) == 0 && req.TOTPCode == "" && req.BackupCode == "" {
+			if user.TOTPSecretEnc != nil {
+				ErrorCode(w, http.StatusUnauthorized, CodeMFARequired, "totp code required")
+			} else {
+				ErrorCode(w, http.StatusUnauthorized, CodeMFARequired, "passkey required")
+			}
+			return
+		}
+
+		failure, reason := "invalid totp code", models.LoginReasonInvalidTOTP
+		method = models.LoginReasonTOTP
+		if len(req.WebAuthn) > 0 {
+			var cred *models.WebAuthnCredential
+			var signCount uint32
+			cred, signCount, ok, err = h.checkPasskey(r.Context(), req.WebAuthn, false)
+			ok = ok && cred.UserID == user.ID
+			if ok {
+				ok, err = h.webauthnRepo.UseCredential(r.Context(), cred.ID, signCount)
+			}
+			failure, reason = "invalid passkey", models.LoginReasonInvalidPasskey
+			method = models.LoginReasonPasskey
+		} else {
+			ok, backupCodesRemaining, err = h.checkSecondFactor(r, user, req.TOTPCode, req.BackupCode)
+			if req.BackupCode != "" {
+				method = models.LoginReasonBackupCode
+			}
+		}
+		if err != nil {
+			Error(w, http.StatusInternalServerError, "login failed")
+			return
+		}
+		if !ok {
+			_ = h.userRepo.LoginFailure(r.Context(), user.ID, maxAttempts, lockoutMinutes)
+			h.recordLogin(r, user.ID, user.Email, models.LoginFailure, reason)
+			ErrorCode(w, http.StatusUnauthorized, CodeInvalidMFA, failure)
+			return
+		}
+	}
+
+	// Upgrade hashes made with weaker parameters now that the password is known
+	if rehash {
+		_ = h.userRepo.RehashPassword(r.Context(), user.ID, user.PasswordHash, h.hashPassword(req.Password, user.Salt))
+	}
+
+	h.completeLogin(w, r, user, backupCodesRemaining, method)
+}
+
+// completeLogin finishes an authenticated login: it resets the failure counter,
+// records the attempt with the method used and returns the user's companies
+// with a pre-auth token for select_company
+func (h *Handler) completeLogin(w http.ResponseWriter, r *http.Request, user *models.User, backupCodesRemaining *int, method string) {
 	_ = h.userRepo.LoginSuccess(r.Context(), user.ID)
+	h.recordLogin(r, user.ID, user.Email, models.LoginSuccess, method)
 
 	companies, err := h.accessRepo.GetUserCompanies(r.Context(), user.ID)
 	if err != nil {
@@ -289,7 +341,25 @@ func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Pre-auth token: proves the password check to select_company
+	preAuthToken, preAuthHash, err := newToken()
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "login failed")
+		return
+	}
+	preAuthExpiresAt := time.Now().Add(time.Duration(h.cfg.Server.PreAuthMinutes) * time.Minute)
+
+	err = h.tokenRepo.Create(r.Context(), uuid.New().String(), user.ID, preAuthHash,
+		models.TokenPurposeSelectCompany, r.RemoteAddr, preAuthExpiresAt)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "login failed")
+		return
+	}
+
 	JSON(w, http.StatusOK, models.LoginResponse{
+		PreAuthToken:         preAuthToken,
+		PreAuthExpiresAt:     preAuthExpiresAt,
+		BackupCodesRemaining: backupCodesRemaining,
 		User: &models.User{
 			ID:          user.ID,
 			Email:       user.Email,
@@ -305,23 +375,140 @@ func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
 
 // ==================== SELECT COMPANY ====================
 
-func (h *Handler) selectCompany(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		UserID     string `json:"user_id"`
-		CompanyID  string `json:"company_id"`
-		DeviceInfo string `json:"device_info"`
+func (h *Handler) selectCompany(w http.ResponseWriter, r *http.Request, req *models.SelectCompanyRequest) {
+	// The token is single-use: a failed selection requires logging in again
+	userID, err := h.tokenRepo.Consume(r.Context(), hashToken(req.PreAuthToken), models.TokenPurposeSelectCompany)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to verify pre-auth token")
+		return
+	}
+	if userID == "" {
+		ErrorCode(w, http.StatusUnauthorized, CodeInvalidToken, "invalid or expired pre-auth token")
+		return
+	}
+
+	companies, err := h.accessRepo.GetUserCompanies(r.Context(), userID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to verify access")
+		return
+	}
+
+	var access *models.UserCompanyAccess
+	for _, c := range companies {
+		if c.CompanyID == req.CompanyID {
+			access = &c
+			break
+		}
+	}
+
+	if access == nil {
+		ErrorCode(w, http.StatusForbidden, CodeNoCompanyAccess, "no access to this company")
+		return
+	}
+
+	policy, err := h.companyRepo.GetSecurityPolicy(r.Context(), req.CompanyID, userID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to load security policy")
+		return
 	}
-	if err := Decode(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+	if policy != nil && !ipAllowed(policy, r) {
+		ErrorCode(w, http.StatusForbidden, CodeIPNotAllowed, "access from this network is not allowed")
 		return
 	}
 
-	if req.UserID == "" || req.CompanyID == "" {
-		Error(w, http.StatusBadRequest, "user_id and company_id are required")
+	tokens, err := h.openSession(r, userID, req.CompanyID, req.DeviceInfo, h.sessionHours(policy), nil)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to create session")
 		return
 	}
 
-	companies, err := h.accessRepo.GetUserCompanies(r.Context(), req.UserID)
+	JSON(w, http.StatusOK, companySession(tokens, access, policy))
+}
+
+// openSession creates a session with a fresh access token and refresh token, ending after sessionHours.
+// A non-nil parent links the new session to the parent's login and never outlives its absolute expiry.
+func (h *Handler) openSession(r *http.Request, userID, companyID, deviceInfo string, sessionHours int, parent *models.UserSession) (*models.RefreshSessionResponse, error) {
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshHash, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	absoluteExpiresAt := now.Add(time.Duration(sessionHours) * time.Hour)
+	var loginID *string
+	if parent != nil {
+		if parent.AbsoluteExpiresAt.Before(absoluteExpiresAt) {
+			absoluteExpiresAt = parent.AbsoluteExpiresAt
+		}
+		loginID = &parent.LoginID
+	}
+	expiresAt := now.Add(time.Duration(h.cfg.Server.AccessTokenMinutes) * time.Minute)
+	if expiresAt.After(absoluteExpiresAt) {
+		expiresAt = absoluteExpiresAt
+	}
+
+	sessionID := uuid.New().String()
+	err = h.sessionRepo.Create(r.Context(), &repository.SessionParams{
+		ID:                sessionID,
+		LoginID:           loginID,
+		TokenHash:         tokenHash,
+		UserID:            userID,
+		CompanyID:         companyID,
+		DeviceInfo:        deviceInfo,
+		IPAddress:         r.RemoteAddr,
+		ExpiresAt:         expiresAt,
+		AbsoluteExpiresAt: absoluteExpiresAt,
+		RefreshID:         uuid.New().String(),
+		RefreshTokenHash:  refreshHash,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.RefreshSessionResponse{
+		SessionID:         sessionID,
+		Token:             token,
+		RefreshToken:      refreshToken,
+		ExpiresAt:         expiresAt,
+		AbsoluteExpiresAt: absoluteExpiresAt,
+	}, nil
+}
+
+// companySession describes a session opened in access's company, with the
+// restrictions the company policy puts on it until the user complies
+func companySession(tokens *models.RefreshSessionResponse, access *models.UserCompanyAccess, policy *models.SecurityPolicy) models.SelectCompanyResponse {
+	return models.SelectCompanyResponse{
+		SessionID:             tokens.SessionID,
+		Token:                 tokens.Token,
+		RefreshToken:          tokens.RefreshToken,
+		ExpiresAt:             tokens.ExpiresAt,
+		AbsoluteExpiresAt:     tokens.AbsoluteExpiresAt,
+		WrappedCompanyKey:     access.WrappedCompanyKey,
+		KeyWrapAlgorithm:      access.KeyWrapAlgorithm,
+		KeyVersion:            access.KeyVersion,
+		KeyLostAt:             access.KeyLostAt,
+		Role:                  access.Role,
+		Permissions:           access.Permissions,
+		EffectivePermissions:  effectivePermissions(access.Role, access.Permissions),
+		PasswordExpired:       policy != nil && passwordExpired(policy),
+		MFAEnrollmentRequired: policy != nil && mfaRequired(policy, access.Role) && !policy.MFAEnrolled,
+	}
+}
+
+// ==================== SWITCH COMPANY ====================
+
+// switchCompany opens a session for another company on the same login, without re-entering credentials
+func (h *Handler) switchCompany(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.SwitchCompanyRequest) {
+	if req.CompanyID == session.CompanyID {
+		Error(w, http.StatusBadRequest, "already signed in to this company")
+		return
+	}
+
+	companies, err := h.accessRepo.GetUserCompanies(r.Context(), session.UserID)
 	if err != nil {
 		Error(w, http.StatusInternalServerError, "failed to verify access")
 		return
@@ -336,27 +523,88 @@ func (h *Handler) selectCompany(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if access == nil {
-		Error(w, http.StatusForbidden, "no access to this company")
+		ErrorCode(w, http.StatusForbidden, CodeNoCompanyAccess, "no access to this company")
 		return
 	}
 
-	sessionID := uuid.New().String()
-	expiresAt := time.Now().Add(time.Duration(h.cfg.Server.SessionHours) * time.Hour)
+	policy, err := h.companyRepo.GetSecurityPolicy(r.Context(), req.CompanyID, session.UserID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to load security policy")
+		return
+	}
+	if policy != nil && !ipAllowed(policy, r) {
+		ErrorCode(w, http.StatusForbidden, CodeIPNotAllowed, "access from this network is not allowed")
+		return
+	}
 
-	err = h.sessionRepo.Create(r.Context(), sessionID, req.UserID, req.CompanyID, req.DeviceInfo, r.RemoteAddr, expiresAt)
+	tokens, err := h.openSession(r, session.UserID, req.CompanyID, req.DeviceInfo, h.sessionHours(policy), session)
 	if err != nil {
 		Error(w, http.StatusInternalServerError, "failed to create session")
 		return
 	}
 
-	JSON(w, http.StatusOK, map[string]interface{}{
-		"session_id":          sessionID,
-		"expires_at":          expiresAt,
-		"wrapped_company_key": access.WrappedCompanyKey,
-		"key_wrap_algorithm":  access.KeyWrapAlgorithm,
-		"key_version":         access.KeyVersion,
-		"role":                access.Role,
-		"permissions":         access.Permissions,
+	meta := getMeta(r, session)
+	_ = h.historyRepo.Log(r.Context(), &models.ChangeHistory{
+		CompanyID:  req.CompanyID,
+		ChangedBy:  session.UserID,
+		SessionID:  &tokens.SessionID,
+		TableName:  "user_sessions",
+		RecordID:   tokens.SessionID,
+		ChangeType: "insert",
+		FieldName:  "switched_from_session",
+		NewValue:   &session.ID,
+		IPAddress:  &meta.IPAddress,
+		UserAgent:  &meta.UserAgent,
+	})
+
+	JSON(w, http.StatusOK, companySession(tokens, access, policy))
+}
+
+// ==================== REFRESH SESSION ====================
+
+func (h *Handler) refreshSession(w http.ResponseWriter, r *http.Request, req *models.RefreshSessionRequest) {
+	token, tokenHash, err := newToken()
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to refresh session")
+		return
+	}
+	refreshToken, refreshHash, err := newToken()
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to refresh session")
+		return
+	}
+
+	res, err := h.sessionRepo.Refresh(r.Context(), &repository.RefreshParams{
+		RefreshTokenHash:    hashToken(req.RefreshToken),
+		NewRefreshID:        uuid.New().String(),
+		NewRefreshTokenHash: refreshHash,
+		NewTokenHash:        tokenHash,
+		AccessMinutes:       h.cfg.Server.AccessTokenMinutes,
+		IdleMinutes:         h.cfg.Server.IdleTimeoutMinutes,
+		IPAddress:           r.RemoteAddr,
+		UserAgent:           r.UserAgent(),
+	})
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to refresh session")
+		return
+	}
+
+	switch res.Status {
+	case repository.RefreshOK:
+	case repository.RefreshReused:
+		ErrorCode(w, http.StatusUnauthorized, CodeTokenReused, "refresh token reuse detected, session revoked")
+		return
+	default:
+		ErrorCode(w, http.StatusUnauthorized, CodeInvalidToken, "invalid or expired refresh token")
+		return
+	}
+
+	JSON(w, http.StatusOK, models.RefreshSessionResponse{
+		SessionID:         res.SessionID,
+		Token:             token,
+		RefreshToken:      refreshToken,
+		ExpiresAt:         res.ExpiresAt,
+		AbsoluteExpiresAt: res.AbsoluteExpiresAt,
 	})
 }
 
@@ -367,7 +615,7 @@ func (h *Handler) logout(w http.ResponseWriter, r *http.Request, session *models
 		Error(w, http.StatusInternalServerError, "failed to logout")
 		return
 	}
-	JSON(w, http.StatusOK, map[string]string{"message": "logged out"})
+	JSON(w, http.StatusOK, models.MessageResponse{Message: "logged out"})
 }
 
 func (h *Handler) logoutAll(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
@@ -375,45 +623,84 @@ func (h *Handler) logoutAll(w http.ResponseWriter, r *http.Request, session *mod
 		Error(w, http.StatusInternalServerError, "failed to logout all sessions")
 		return
 	}
-	JSON(w, http.StatusOK, map[string]string{"message": "all sessions invalidated"})
+	JSON(w, http.StatusOK, models.MessageResponse{Message: "all sessions invalidated"})
 }
 
-// ==================== COMPANY ====================
+// ==================== SESSIONS ====================
 
-func (h *Handler) getCompany(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
-	company, err := h.companyRepo.GetByID(r.Context(), session.CompanyID)
+func (h *Handler) listSessions(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
+	sessions, err := h.sessionRepo.ListByUser(r.Context(), session.UserID)
 	if err != nil {
-		Error(w, http.StatusInternalServerError, "failed to get company")
+		Error(w, http.StatusInternalServerError, "failed to list sessions")
 		return
 	}
-	if company == nil {
-		Error(w, http.StatusNotFound, "company not found")
+	markCurrentSession(sessions, session.ID)
+	JSON(w, http.StatusOK, sessions)
+}
+
+func (h *Handler) revokeSession(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.RevokeSessionRequest) {
+	sessions, err := h.sessionRepo.ListByUser(r.Context(), session.UserID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to verify session")
 		return
 	}
-	JSON(w, http.StatusOK, company)
+
+	found := false
+	for _, s := range sessions {
+		if s.ID == req.SessionID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		Error(w, http.StatusNotFound, "session not found")
+		return
+	}
+
+	meta := getMeta(r, session)
+	if err := h.sessionRepo.Revoke(r.Context(), req.SessionID, session.UserID, meta); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to revoke session")
+		return
+	}
+	JSON(w, http.StatusOK, models.RevokeSessionResponse{
+		Message: "session revoked",
+		Current: req.SessionID == session.ID,
+	})
 }
 
-func (h *Handler) updateCompany(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
-	if session.Role != models.RoleSuperAdmin && session.Role != models.RoleAdmin {
-		Error(w, http.StatusForbidden, "insufficient permissions")
+// listUserSessions lets an admin see another user's sessions within the current company
+func (h *Handler) listUserSessions(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.UserIDRequest) {
+	sessions, err := h.sessionRepo.ListInCompany(r.Context(), tenant(session), req.UserID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to list sessions")
 		return
 	}
+	markCurrentSession(sessions, session.ID)
+	JSON(w, http.StatusOK, sessions)
+}
 
-	var req struct {
-		Name         *string `json:"name"`
-		Industry     *string `json:"industry"`
-		Address      *string `json:"address"`
-		City         *string `json:"city"`
-		State        *string `json:"state"`
-		Province     *string `json:"province"`
-		MaxEmployees *int    `json:"max_employees"`
-		Plan         *string `json:"plan"`
+func markCurrentSession(sessions []models.SessionInfo, currentID string) {
+	for i := range sessions {
+		sessions[i].IsCurrent = sessions[i].ID == currentID
 	}
-	if err := Decode(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+}
+
+// ==================== COMPANY ====================
+
+func (h *Handler) getCompany(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
+	company, err := h.companyRepo.GetByID(r.Context(), session.CompanyID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to get company")
 		return
 	}
+	if company == nil {
+		Error(w, http.StatusNotFound, "company not found")
+		return
+	}
+	JSON(w, http.StatusOK, company)
+}
 
+func (h *Handler) updateCompany(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.UpdateCompanyRequest) {
 	company := &models.Company{ID: session.CompanyID}
 	if req.Name != nil {
 		company.Name = *req.Name
@@ -442,24 +729,19 @@ func (h *Handler) updateCompany(w http.ResponseWriter, r *http.Request, session
 
 	meta := getMeta(r, session)
 	if err := h.companyRepo.Update(r.Context(), company, meta); err != nil {
-		Error(w, http.StatusInternalServerError, "failed to update company")
+		writeFailed(w, err, "failed to update company")
 		return
 	}
-	JSON(w, http.StatusOK, map[string]string{"message": "company updated"})
+	JSON(w, http.StatusOK, models.MessageResponse{Message: "company updated"})
 }
 
 func (h *Handler) deleteCompany(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
-	if session.Role != models.RoleSuperAdmin {
-		Error(w, http.StatusForbidden, "only superadmin can delete company")
-		return
-	}
-
 	meta := getMeta(r, session)
 	if err := h.companyRepo.Delete(r.Context(), session.CompanyID, meta); err != nil {
 		Error(w, http.StatusInternalServerError, "failed to delete company")
 		return
 	}
-	JSON(w, http.StatusOK, map[string]string{"message": "company deactivated"})
+	JSON(w, http.StatusOK, models.MessageResponse{Message: "company deactivated"})
 }
 
 // ==================== USER ====================
@@ -477,16 +759,7 @@ func (h *Handler) getUser(w http.ResponseWriter, r *http.Request, session *model
 	JSON(w, http.StatusOK, user)
 }
 
-func (h *Handler) updateUser(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
-	var req struct {
-		Email    *string `json:"email"`
-		Username *string `json:"username"`
-	}
-	if err := Decode(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
-		return
-	}
-
+func (h *Handler) updateUser(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.UpdateUserRequest) {
 	user := &models.User{ID: session.UserID}
 	if req.Email != nil {
 		user.Email = *req.Email
@@ -497,91 +770,118 @@ func (h *Handler) updateUser(w http.ResponseWriter, r *http.Request, session *mo
 
 	meta := getMeta(r, session)
 	if err := h.userRepo.Update(r.Context(), user, meta); err != nil {
-		Error(w, http.StatusInternalServerError, "failed to update user")
+		writeFailed(w, err, "failed to update user")
 		return
 	}
-	JSON(w, http.StatusOK, map[string]string{"message": "user updated"})
+	JSON(w, http.StatusOK, models.MessageResponse{Message: "user updated"})
 }
 
-func (h *Handler) changePassword(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
-	var req struct {
-		CurrentPassword string `json:"current_password"`
-		NewPassword     string `json:"new_password"`
-	}
-	if err := Decode(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+func (h *Handler) changePassword(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.ChangePasswordRequest) {
+	user, err := h.userRepo.GetByEmail(r.Context(), session.Email)
+	if err != nil || user == nil {
+		Error(w, http.StatusInternalServerError, "failed to verify user")
 		return
 	}
 
-	if req.CurrentPassword == "" || req.NewPassword == "" {
-		Error(w, http.StatusBadRequest, "current_password and new_password are required")
+	if !h.verifyPassword(req.CurrentPassword, user.Salt, user.PasswordHash) {
+		ErrorCode(w, http.StatusUnauthorized, CodePasswordIncorrect, "current password is incorrect")
 		return
 	}
 
-	user, err := h.userRepo.GetByEmail(r.Context(), session.Email)
-	if err != nil || user == nil {
-		Error(w, http.StatusInternalServerError, "failed to verify user")
+	// The new password must satisfy every company the user belongs to
+	policies, err := h.companyRepo.GetUserSecurityPolicies(r.Context(), session.UserID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to load security policy")
+		return
+	}
+	if reasons := h.checkPassword(req.NewPassword, policies, session.Email, session.Username); len(reasons) > 0 {
+		rejectPassword(w, reasons)
 		return
 	}
 
-	if !verifyPassword(req.CurrentPassword, user.Salt, user.PasswordHash) {
-		Error(w, http.StatusUnauthorized, "current password is incorrect")
+	// Every company key must be re-wrapped, or that company becomes undecryptable
+	companies, err := h.accessRepo.GetUserCompanies(r.Context(), session.UserID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to get companies")
+		return
+	}
+	if msg := checkWrappedKeys(req.WrappedKeys, companies); msg != "" {
+		Error(w, http.StatusBadRequest, msg)
 		return
 	}
 
-	newSalt := uuid.New().String()
-	newHash := hashPassword(req.NewPassword, newSalt)
+	newHash := h.hashPassword(req.NewPassword, req.Salt)
 
 	meta := getMeta(r, session)
-	if err := h.userRepo.ChangePassword(r.Context(), session.UserID, newHash, newSalt, meta); err != nil {
-		Error(w, http.StatusInternalServerError, "failed to change password")
+	if err := h.userRepo.ChangePassword(r.Context(), session.UserID, newHash, req.Salt, req.WrappedKeys, meta); err != nil {
+		if repository.Signalled(err) {
+			Error(w, http.StatusConflict, "failed to change password, company access may have changed; reload and retry")
+			return
+		}
+		writeFailed(w, err, "failed to change password")
 		return
 	}
 
-	JSON(w, http.StatusOK, map[string]interface{}{
-		"message":  "password changed",
-		"new_salt": newSalt,
+	JSON(w, http.StatusOK, models.ChangePasswordResponse{
+		Message: "password changed",
+		NewSalt: req.Salt,
 	})
 }
 
-func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
-	if session.Role != models.RoleSuperAdmin && session.Role != models.RoleAdmin {
-		Error(w, http.StatusForbidden, "insufficient permissions")
-		return
+// checkWrappedKeys returns a message describing the first problem with the
+// re-wrapped keys, or "" when there is exactly one per accessible company.
+// Companies whose key was lost in a password reset have nothing to re-wrap.
+func checkWrappedKeys(keys []models.WrappedKey, companies []models.UserCompanyAccess) string {
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.CompanyID == "" || len(k.WrappedCompanyKey) == 0 {
+			return "each wrapped key needs company_id and wrapped_company_key"
+		}
+		if seen[k.CompanyID] {
+			return "duplicate wrapped key for company " + k.CompanyID
+		}
+		seen[k.CompanyID] = true
 	}
 
-	var req struct {
-		UserID string `json:"user_id"`
+	for _, c := range companies {
+		if c.KeyLostAt != nil {
+			continue
+		}
+		if !seen[c.CompanyID] {
+			return "missing wrapped key for company " + c.CompanyID
+		}
+		delete(seen, c.CompanyID)
 	}
-	if err := Decode(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
-		return
+	for id := range seen {
+		return "no access to company " + id
 	}
+	return ""
+}
 
-	if req.UserID == "" {
-		Error(w, http.StatusBadRequest, "user_id is required")
-		return
-	}
+func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.UserIDRequest) {
 	if req.UserID == session.UserID {
 		Error(w, http.StatusForbidden, "cannot delete yourself")
 		return
 	}
+	if !h.canManage(w, r, session, "", req.UserID, "user not found") {
+		return
+	}
 
 	meta := getMeta(r, session)
-	if err := h.userRepo.Delete(r.Context(), req.UserID, meta); err != nil {
-		Error(w, http.StatusInternalServerError, "failed to deactivate user")
+	err := h.userRepo.RemoveFromCompany(r.Context(), tenant(session), req.UserID, meta)
+	if err == repository.ErrNotFound {
+		Error(w, http.StatusNotFound, "user not found")
 		return
 	}
-	JSON(w, http.StatusOK, map[string]string{"message": "user deactivated"})
-}
-
-func (h *Handler) listUsers(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
-	if session.Role != models.RoleSuperAdmin && session.Role != models.RoleAdmin && session.Role != models.RoleHR {
-		Error(w, http.StatusForbidden, "insufficient permissions")
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to remove user")
 		return
 	}
+	JSON(w, http.StatusOK, models.MessageResponse{Message: "user removed from company"})
+}
 
-	users, err := h.accessRepo.GetCompanyUsers(r.Context(), session.CompanyID)
+func (h *Handler) listUsers(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
+	users, err := h.accessRepo.GetCompanyUsers(r.Context(), tenant(session))
 	if err != nil {
 		Error(w, http.StatusInternalServerError, "failed to list users")
 		return
@@ -589,42 +889,14 @@ func (h *Handler) listUsers(w http.ResponseWriter, r *http.Request, session *mod
 	JSON(w, http.StatusOK, users)
 }
 
-func (h *Handler) createUser(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
-	if session.Role != models.RoleSuperAdmin && session.Role != models.RoleAdmin {
-		Error(w, http.StatusForbidden, "insufficient permissions")
-		return
-	}
-
-	var req struct {
-		Email             string `json:"email"`
-		Username          string `json:"username"`
-		Password          string `json:"password"`
-		Role              string `json:"role"`
-		WrappedCompanyKey []byte `json:"wrapped_company_key"`
-		KeyWrapAlgorithm  string `json:"key_wrap_algorithm"`
-		PublicKey         []byte `json:"public_key"`
-	}
-	if err := Decode(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
-		return
-	}
-
-	if req.Email == "" || req.Username == "" || req.Password == "" {
-		Error(w, http.StatusBadRequest, "email, username, and password are required")
-		return
-	}
-	if len(req.WrappedCompanyKey) == 0 || len(req.PublicKey) == 0 {
-		Error(w, http.StatusBadRequest, "wrapped_company_key and public_key are required")
-		return
-	}
-
+func (h *Handler) createUser(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.CreateUserRequest) {
 	existing, err := h.userRepo.GetByEmail(r.Context(), req.Email)
 	if err != nil {
 		Error(w, http.StatusInternalServerError, "failed to check existing user")
 		return
 	}
 	if existing != nil {
-		Error(w, http.StatusConflict, "email already registered")
+		ErrorCode(w, http.StatusConflict, CodeEmailTaken, "email already registered")
 		return
 	}
 
@@ -636,10 +908,28 @@ func (h *Handler) createUser(w http.ResponseWriter, r *http.Request, session *mo
 		Error(w, http.StatusForbidden, "cannot create superadmin")
 		return
 	}
+	if field := checkAccessChange(h.permissions(session), &role, nil); field != nil {
+		ValidationFailed(w, *field)
+		return
+	}
+
+	policy, err := h.companyRepo.GetSecurityPolicy(r.Context(), session.CompanyID, session.UserID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to load security policy")
+		return
+	}
+	var policies []models.SecurityPolicy
+	if policy != nil {
+		policies = append(policies, *policy)
+	}
+	if reasons := h.checkPassword(req.Password, policies, req.Email, req.Username); len(reasons) > 0 {
+		rejectPassword(w, reasons)
+		return
+	}
 
 	userID := uuid.New().String()
 	salt := uuid.New().String()
-	passwordHash := hashPassword(req.Password, salt)
+	passwordHash := h.hashPassword(req.Password, salt)
 
 	meta := getMeta(r, session)
 	err = h.userRepo.Create(r.Context(), &models.User{
@@ -650,7 +940,7 @@ func (h *Handler) createUser(w http.ResponseWriter, r *http.Request, session *mo
 		Salt:         salt,
 	}, meta)
 	if err != nil {
-		Error(w, http.StatusInternalServerError, "failed to create user")
+		writeFailed(w, err, "failed to create user")
 		return
 	}
 
@@ -660,24 +950,23 @@ func (h *Handler) createUser(w http.ResponseWriter, r *http.Request, session *mo
 		algorithm = "AES-256-KW"
 	}
 
-	err = h.accessRepo.Create(r.Context(), &models.UserCompanyAccess{
+	err = h.accessRepo.Create(r.Context(), tenant(session), &models.UserCompanyAccess{
 		ID:                accessID,
 		UserID:            userID,
-		CompanyID:         session.CompanyID,
 		WrappedCompanyKey: req.WrappedCompanyKey,
 		KeyWrapAlgorithm:  algorithm,
 		PublicKey:         req.PublicKey,
 		Role:              role,
 	}, meta)
 	if err != nil {
-		Error(w, http.StatusInternalServerError, "failed to grant company access")
+		writeFailed(w, err, "failed to grant company access")
 		return
 	}
 
-	JSON(w, http.StatusCreated, map[string]interface{}{
-		"user_id":   userID,
-		"access_id": accessID,
-		"salt":      salt,
+	JSON(w, http.StatusCreated, models.CreateUserResponse{
+		UserID:   userID,
+		AccessID: accessID,
+		Salt:     salt,
 	})
 }
 
@@ -692,28 +981,12 @@ func (h *Handler) getUserCompanies(w http.ResponseWriter, r *http.Request, sessi
 	JSON(w, http.StatusOK, companies)
 }
 
-func (h *Handler) updateUserAccess(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
-	if session.Role != models.RoleSuperAdmin && session.Role != models.RoleAdmin {
-		Error(w, http.StatusForbidden, "insufficient permissions")
+func (h *Handler) updateUserAccess(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.UpdateUserAccessRequest) {
+	if !h.canManage(w, r, session, req.AccessID, "", "access not found") {
 		return
 	}
-
-	var req struct {
-		AccessID          string  `json:"access_id"`
-		Role              *string `json:"role"`
-		Permissions       *string `json:"permissions"`
-		WrappedCompanyKey []byte  `json:"wrapped_company_key"`
-		KeyWrapAlgorithm  *string `json:"key_wrap_algorithm"`
-		KeyVersion        *int    `json:"key_version"`
-		PublicKey         []byte  `json:"public_key"`
-	}
-	if err := Decode(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
-		return
-	}
-
-	if req.AccessID == "" {
-		Error(w, http.StatusBadRequest, "access_id is required")
+	if field := checkAccessChange(h.permissions(session), req.Role, req.Permissions); field != nil {
+		ValidationFailed(w, *field)
 		return
 	}
 
@@ -738,59 +1011,38 @@ func (h *Handler) updateUserAccess(w http.ResponseWriter, r *http.Request, sessi
 	}
 
 	meta := getMeta(r, session)
-	if err := h.accessRepo.Update(r.Context(), access, meta); err != nil {
-		Error(w, http.StatusInternalServerError, "failed to update access")
+	err := h.accessRepo.Update(r.Context(), tenant(session), access, meta)
+	if err == repository.ErrNotFound {
+		Error(w, http.StatusNotFound, "access not found")
 		return
 	}
-	JSON(w, http.StatusOK, map[string]string{"message": "access updated"})
-}
-
-func (h *Handler) revokeUserAccess(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
-	if session.Role != models.RoleSuperAdmin && session.Role != models.RoleAdmin {
-		Error(w, http.StatusForbidden, "insufficient permissions")
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to update access")
 		return
 	}
+	JSON(w, http.StatusOK, models.MessageResponse{Message: "access updated"})
+}
 
-	var req struct {
-		AccessID string `json:"access_id"`
-	}
-	if err := Decode(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+func (h *Handler) revokeUserAccess(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.RevokeUserAccessRequest) {
+	if !h.canManage(w, r, session, req.AccessID, "", "access not found") {
 		return
 	}
-
-	if req.AccessID == "" {
-		Error(w, http.StatusBadRequest, "access_id is required")
+	meta := getMeta(r, session)
+	err := h.accessRepo.Delete(r.Context(), tenant(session), req.AccessID, meta)
+	if err == repository.ErrNotFound {
+		Error(w, http.StatusNotFound, "access not found")
 		return
 	}
-
-	meta := getMeta(r, session)
-	if err := h.accessRepo.Delete(r.Context(), req.AccessID, meta); err != nil {
+	if err != nil {
 		Error(w, http.StatusInternalServerError, "failed to revoke access")
 		return
 	}
-	JSON(w, http.StatusOK, map[string]string{"message": "access revoked"})
+	JSON(w, http.StatusOK, models.MessageResponse{Message: "access revoked"})
 }
 
 // ==================== HISTORY ====================
 
-func (h *Handler) getHistory(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
-	if session.Role != models.RoleSuperAdmin && session.Role != models.RoleAdmin && session.Role != models.RoleHR {
-		Error(w, http.StatusForbidden, "insufficient permissions")
-		return
-	}
-
-	var req struct {
-		TableName *string `json:"table_name"`
-		RecordID  *string `json:"record_id"`
-		Limit     *int    `json:"limit"`
-		Offset    *int    `json:"offset"`
-	}
-	if err := Decode(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
-		return
-	}
-
+func (h *Handler) getHistory(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.HistoryRequest) {
 	limit := 50
 	if req.Limit != nil && *req.Limit > 0 && *req.Limit <= 200 {
 		limit = *req.Limit
@@ -801,68 +1053,49 @@ func (h *Handler) getHistory(w http.ResponseWriter, r *http.Request, session *mo
 		offset = *req.Offset
 	}
 
-	history, err := h.historyRepo.Get(r.Context(), session.CompanyID, req.TableName, req.RecordID, limit, offset)
+	history, err := h.historyRepo.Get(r.Context(), tenant(session), req.TableName, req.RecordID, limit, offset)
 	if err != nil {
 		Error(w, http.StatusInternalServerError, "failed to get change history")
 		return
 	}
 
-	JSON(w, http.StatusOK, map[string]interface{}{
-		"records": history,
-		"limit":   limit,
-		"offset":  offset,
+	JSON(w, http.StatusOK, models.HistoryResponse{
+		Records: history,
+		Limit:   limit,
+		Offset:  offset,
 	})
 }
 
 // ==================== HELPERS ====================
 
-func hashPassword(password, salt string) string {
-	hash := argon2.IDKey([]byte(password), []byte(salt), 1, 64*1024, 4, 32)
-	return fmt.Sprintf("%x", hash)
+// hashPassword returns the PHC-encoded hash of pw with the configured parameters
+func (h *Handler) hashPassword(pw, salt string) string {
+	return password.Hash(pw, salt, h.cfg.Server.PasswordParams())
 }
 
-func verifyPassword(password, salt, storedHash string) bool {
-	return hashPassword(password, salt) == storedHash
+func (h *Handler) verifyPassword(pw, salt, storedHash string) bool {
+	ok, _ := password.Verify(pw, salt, storedHash, h.cfg.Server.PasswordParams())
+	return ok
 }
 
-func strPtr(s string) *string {
-	if s == "" {
-		return nil
+// newToken returns a random URL-safe token and the SHA-256 hash to store for it
+func newToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
 	}
-	return &s
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
 }
 
-func (h *Handler) resetPassword(w http.ResponseWriter, r *http.Request) {
-	var req models.ResetPasswordRequest
-	if err := Decode(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
-		return
-	}
-
-	if req.Email == "" || req.Password == "" || req.Salt == "" {
-		Error(w, http.StatusBadRequest, "email, password, and salt are required")
-		return
-	}
-	if req.WrappedCompanyKey == "" || req.PublicKey == "" {
-		Error(w, http.StatusBadRequest, "wrapped_company_key and public_key are required")
-		return
-	}
-
-	user, err := h.userRepo.GetByEmail(r.Context(), req.Email)
-	if err != nil || user == nil {
-		Error(w, http.StatusNotFound, "user not found")
-		return
-	}
-
-	passwordHash := hashPassword(req.Password, req.Salt)
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
 
-	err = h.userRepo.ResetPasswordWithKey(r.Context(), user.ID, passwordHash, req.Salt,
-		req.WrappedCompanyKey, req.KeyWrapAlgorithm, req.PublicKey,
-		r.RemoteAddr, r.UserAgent())
-	if err != nil {
-		Error(w, http.StatusInternalServerError, "password reset failed: "+err.Error())
-		return
+func strPtr(s string) *string {
+	if s == "" {
+		return nil
 	}
-
-	JSON(w, http.StatusOK, map[string]string{"status": "password reset successful"})
+	return &s
 }
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"lettersheets/internal/secret"
+	"lettersheets/models"
+)
+
+// Actions marked idempotent accept an Idempotency-Key header. The first
+// request with a key runs and its response is stored for idempotencyTTL;
+// a retry with the same key and request gets that response back, marked
+// Idempotent-Replayed, instead of running the action again. Keys are
+// per user, or per email for actions without a session (register).
+// Requests are only stored under an HMAC keyed with the server secret key:
+// their bodies carry passwords. Without a configured key the header is
+// ignored and the action simply runs.
+
+// idempotencyTTL is how long a response stays available for replay
+const idempotencyTTL = 24 * time.Hour
+
+// maxIdempotencyKey is the size of the idempotency_key column
+const maxIdempotencyKey = 255
+
+// runIdempotent runs action, through the stored response of its
+// Idempotency-Key when the action accepts one and the request carries it
+func (h *Handler) runIdempotent(w http.ResponseWriter, r *http.Request, action *Action, session *models.UserSession) {
+	key := r.Header.Get("Idempotency-Key")
+	if !action.Idempotent || key == "" {
+		action.run(w, r, session)
+		return
+	}
+	if len(key) > maxIdempotencyKey {
+		ValidationFailed(w, FieldError{Field: "Idempotency-Key", Message: "must be at most " + strconv.Itoa(maxIdempotencyKey) + " characters"})
+		return
+	}
+
+	var scope string
+	if session != nil {
+		scope = session.UserID
+	} else if email := requestEmail(r); email != "" {
+		scope = "email:" + emailBucket(email) // fits the scope column however long the address
+	} else {
+		action.run(w, r, session) // nothing to scope the key to; validation will refuse the request
+		return
+	}
+	secretKey, err := secret.ParseKey(h.cfg.Server.SecretKey)
+	if err != nil {
+		log.Printf("idempotency key ignored: %v", err)
+		action.run(w, r, session)
+		return
+	}
+	hash := requestHash(secretKey, r, action, session)
+
+	created, prior, err := h.idempotencyRepo.Begin(r.Context(), scope, key, action.Name, hash, time.Now().Add(idempotencyTTL))
+	if err != nil {
+		log.Printf("idempotency key lookup failed: %v", err)
+		Error(w, http.StatusInternalServerError, "failed to check idempotency key")
+		return
+	}
+	if !created {
+		replay(w, prior, hash)
+		return
+	}
+
+	buf := newBufferedWriter()
+	action.run(buf, r, session)
+
+	// Store the outcome even when the client has gone, so its retry replays it
+	ctx := context.WithoutCancel(r.Context())
+	if buf.status >= http.StatusInternalServerError {
+		err = h.idempotencyRepo.Release(ctx, scope, key)
+	} else {
+		err = h.idempotencyRepo.Complete(ctx, scope, key, buf.status, buf.body.Bytes())
+	}
+	if err != nil {
+		log.Printf("idempotency key update failed: %v", err)
+	}
+
+	for k, v := range buf.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(buf.status)
+	w.Write(buf.body.Bytes())
+}
+
+// replay answers a retry with the response stored for its key
+func replay(w http.ResponseWriter, prior *models.IdempotentResponse, hash string) {
+	if prior.RequestHash != hash {
+		ErrorCode(w, http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, "Idempotency-Key was already used for a different request")
+		return
+	}
+	if prior.StatusCode == nil {
+		w.Header().Set("Retry-After", "1")
+		ErrorCode(w, http.StatusConflict, CodeRequestInProgress, "a request with this Idempotency-Key is still in progress")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(*prior.StatusCode)
+	w.Write(prior.ResponseBody)
+}
+
+// requestHash identifies a request by action, company and body, so the
+// same body sent to /api/execute or the REST route matches, and a key
+// reused in another company does not replay the first company's response.
+// It is keyed so a stored hash cannot be used to guess the password in it.
+func requestHash(key []byte, r *http.Request, action *Action, session *models.UserSession) string {
+	var body []byte
+	if r.Body != nil {
+		body, _ = io.ReadAll(io.LimitReader(r.Body, maxPeekBody))
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	// Canonical form: object keys sorted, insignificant whitespace dropped
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if dec.Decode(&v) == nil {
+		if canonical, err := json.Marshal(v); err == nil {
+			body = canonical
+		}
+	}
+
+	company := ""
+	if session != nil {
+		company = session.CompanyID
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(action.Name + "\n" + company + "\n" + string(body)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lettersheets/internal/secret"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	db, fake := openFakeDB()
+	h := newTestHandler(db)
+	h.cfg.Server.SecretKey = strings.Repeat("ab", 32)
+
+	runs, fail := 0, false
+	type createRequest struct {
+		Email string `json:"email"`
+		Name  string `json:"name"`
+	}
+	action := public("create_thing", func(w http.ResponseWriter, r *http.Request, req *createRequest) {
+		runs++
+		if fail {
+			Error(w, http.StatusInternalServerError, "failed")
+			return
+		}
+		JSON(w, http.StatusOK, map[string]int{"run": runs})
+	}).unlimited().idempotent()
+
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/execute?action=create_thing", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		h.serve(rec, req, action)
+		return rec
+	}
+	expect := func(step string, rec *httptest.ResponseRecorder, status int, code Code, wantRuns int) {
+		t.Helper()
+		var resp Response
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != status || resp.Code != code || runs != wantRuns {
+			t.Fatalf("%s: got %d %s after %d runs, want %d %s after %d runs: %s",
+				step, rec.Code, resp.Code, runs, status, code, wantRuns, rec.Body.String())
+		}
+	}
+
+	first := send("k1", `{"email":"a@example.com","name":"x"}`)
+	expect("first", first, http.StatusOK, "", 1)
+
+	// Key order and whitespace do not make it another request
+	retry := send("k1", `{ "name": "x", "email": "a@example.com" }`)
+	expect("retry", retry, http.StatusOK, "", 1)
+	if retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry was not replayed: %s %v", retry.Body.String(), retry.Header())
+	}
+
+	expect("other body", send("k1", `{"email":"a@example.com","name":"y"}`), http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, 1)
+	expect("other email", send("k1", `{"email":"b@example.com","name":"x"}`), http.StatusOK, "", 2)
+	expect("no key", send("", `{"email":"a@example.com","name":"x"}`), http.StatusOK, "", 3)
+	expect("key too long", send(strings.Repeat("k", 256), `{"email":"a@example.com","name":"x"}`), http.StatusBadRequest, CodeValidationFailed, 3)
+
+	// A server error releases the key, so the retry runs again
+	fail = true
+	expect("failed", send("k2", `{"email":"a@example.com","name":"x"}`), http.StatusInternalServerError, CodeInternal, 4)
+	fail = false
+	expect("after failure", send("k2", `{"email":"a@example.com","name":"x"}`), http.StatusOK, "", 5)
+
+	// A retry arriving while the first request runs is told to wait
+	key, _ := secret.ParseKey(h.cfg.Server.SecretKey)
+	fake.idempotent["email:"+emailBucket("a@example.com")+"/k3"] = &fakeIdempotent{requestHash: requestHash(key,
+		httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":"a@example.com","name":"x"}`)), action, nil)}
+	rec := send("k3", `{"email":"a@example.com","name":"x"}`)
+	expect("in progress", rec, http.StatusConflict, CodeRequestInProgress, 5)
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatal("in progress response has no Retry-After")
+	}
+
+	// The stored hash is keyed: the body holds a password and must not be
+	// guessable from idempotency_keys with plain SHA-256
+	stored := fake.idempotent["email:"+emailBucket("a@example.com")+"/k1"].requestHash
+	for _, body := range []string{
+		`{"email":"a@example.com","name":"x"}`,
+		"create_thing\n\n" + `{"email":"a@example.com","name":"x"}`,
+	} {
+		if sum := sha256.Sum256([]byte(body)); stored == hex.EncodeToString(sum[:]) {
+			t.Fatalf("stored hash is the plain SHA-256 of %q", body)
+		}
+	}
+
+	// Without a server key the header is ignored rather than stored unkeyed
+	h.cfg.Server.SecretKey = ""
+	expect("no secret key", send("k4", `{"email":"a@example.com","name":"x"}`), http.StatusOK, "", 6)
+	expect("no secret key retry", send("k4", `{"email":"a@example.com","name":"x"}`), http.StatusOK, "", 7)
+	if _, ok := fake.idempotent["email:"+emailBucket("a@example.com")+"/k4"]; ok {
+		t.Fatal("stored a key without a server secret key")
+	}
+}
@@ -0,0 +1,267 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"lettersheets/internal/secret"
+	"lettersheets/internal/totp"
+	"lettersheets/models"
+)
+
+// ==================== TOTP ====================
+
+func (h *Handler) enrollTOTP(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
+	key, err := secret.ParseKey(h.cfg.Server.SecretKey)
+	if err != nil {
+		Error(w, http.StatusServiceUnavailable, "two-factor authentication is not configured")
+		return
+	}
+
+	state, err := h.userRepo.GetTOTPState(r.Context(), session.UserID)
+	if err != nil || state == nil {
+		Error(w, http.StatusInternalServerError, "failed to get two-factor state")
+		return
+	}
+	if state.TOTPSecretEnc != nil {
+		Error(w, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+
+	seed, err := totp.GenerateSecret()
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to generate secret")
+		return
+	}
+	sealed, err := secret.Seal(key, seed, []byte(session.UserID))
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to generate secret")
+		return
+	}
+
+	if err := h.userRepo.SetTOTPPending(r.Context(), session.UserID, sealed); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to start enrollment")
+		return
+	}
+
+	JSON(w, http.StatusOK, models.TOTPEnrollResponse{
+		Secret:          totp.EncodeSecret(seed),
+		ProvisioningURI: totp.ProvisioningURI(seed, h.cfg.Server.TOTPIssuer, session.Email),
+	})
+}
+
+func (h *Handler) confirmTOTP(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.TOTPCodeRequest) {
+	key, err := secret.ParseKey(h.cfg.Server.SecretKey)
+	if err != nil {
+		Error(w, http.StatusServiceUnavailable, "two-factor authentication is not configured")
+		return
+	}
+
+	state, err := h.userRepo.GetTOTPState(r.Context(), session.UserID)
+	if err != nil || state == nil {
+		Error(w, http.StatusInternalServerError, "failed to get two-factor state")
+		return
+	}
+	if state.TOTPPendingEnc == nil {
+		Error(w, http.StatusBadRequest, "no pending two-factor enrollment")
+		return
+	}
+
+	seed, err := secret.Open(key, state.TOTPPendingEnc, []byte(session.UserID))
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to read secret")
+		return
+	}
+
+	counter, ok := totp.Validate(seed, req.Code, time.Now(), h.cfg.Server.TOTPSkewSteps)
+	if !ok {
+		ErrorCode(w, http.StatusUnauthorized, CodeInvalidMFA, "invalid totp code")
+		return
+	}
+
+	meta := getMeta(r, session)
+	if err := h.userRepo.EnableTOTP(r.Context(), session.UserID, counter, meta); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to enable two-factor authentication")
+		return
+	}
+
+	codes, err := h.issueBackupCodes(r.Context(), key, session.UserID, meta)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "two-factor enabled but failed to generate backup codes")
+		return
+	}
+
+	JSON(w, http.StatusOK, models.ConfirmTOTPResponse{
+		Message:     "two-factor authentication enabled",
+		BackupCodes: codes,
+	})
+}
+
+func (h *Handler) disableTOTP(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.DisableTOTPRequest) {
+	if req.Code == "" && req.BackupCode == "" {
+		ValidationFailed(w, FieldError{Field: "code", Message: "code or backup_code is required"})
+		return
+	}
+
+	user, err := h.userRepo.GetByEmail(r.Context(), session.Email)
+	if err != nil || user == nil {
+		Error(w, http.StatusInternalServerError, "failed to verify user")
+		return
+	}
+	if user.TOTPSecretEnc == nil {
+		Error(w, http.StatusBadRequest, "two-factor authentication is not enabled")
+		return
+	}
+
+	if !h.verifyPassword(req.Password, user.Salt, user.PasswordHash) {
+		ErrorCode(w, http.StatusUnauthorized, CodePasswordIncorrect, "password is incorrect")
+		return
+	}
+
+	ok, _, err := h.checkSecondFactor(r, user, req.Code, req.BackupCode)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to verify code")
+		return
+	}
+	if !ok {
+		ErrorCode(w, http.StatusUnauthorized, CodeInvalidMFA, "invalid code")
+		return
+	}
+
+	meta := getMeta(r, session)
+	if err := h.userRepo.DisableTOTP(r.Context(), session.UserID, meta); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to disable two-factor authentication")
+		return
+	}
+	JSON(w, http.StatusOK, models.MessageResponse{Message: "two-factor authentication disabled"})
+}
+
+// checkSecondFactor accepts either a TOTP code or a one-time backup code.
+// remaining is set only when a backup code was consumed.
+func (h *Handler) checkSecondFactor(r *http.Request, user *models.User, totpCode, backupCode string) (ok bool, remaining *int, err error) {
+	if totpCode != "" {
+		if user.TOTPSecretEnc == nil {
+			return false, nil, nil
+		}
+		ok, err = h.checkTOTP(r.Context(), user.ID, user.TOTPSecretEnc, totpCode)
+		return ok, nil, err
+	}
+
+	key, err := secret.ParseKey(h.cfg.Server.SecretKey)
+	if err != nil {
+		return false, nil, err
+	}
+
+	ok, left, err := h.userRepo.UseBackupCode(r.Context(), user.ID,
+		hashBackupCode(key, user.ID, backupCode), r.RemoteAddr, r.UserAgent())
+	if err != nil || !ok {
+		return false, nil, err
+	}
+	return true, &left, nil
+}
+
+// checkTOTP validates a code against the user's enrolled secret and consumes
+// its time step so the same code cannot be replayed
+func (h *Handler) checkTOTP(ctx context.Context, userID string, secretEnc []byte, code string) (bool, error) {
+	key, err := secret.ParseKey(h.cfg.Server.SecretKey)
+	if err != nil {
+		return false, err
+	}
+
+	seed, err := secret.Open(key, secretEnc, []byte(userID))
+	if err != nil {
+		return false, err
+	}
+
+	counter, ok := totp.Validate(seed, code, time.Now(), h.cfg.Server.TOTPSkewSteps)
+	if !ok {
+		return false, nil
+	}
+	return h.userRepo.UseTOTPCounter(ctx, userID, counter)
+}
+
+// ==================== BACKUP CODES ====================
+
+const (
+	backupCodeCount    = 10
+	backupCodeAlphabet = "abcdefghjkmnpqrstuvwxyz123456789" // 32 symbols, no i, l, o or 0
+)
+
+func (h *Handler) regenerateBackupCodes(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.RegenerateBackupCodesRequest) {
+	key, err := secret.ParseKey(h.cfg.Server.SecretKey)
+	if err != nil {
+		Error(w, http.StatusServiceUnavailable, "two-factor authentication is not configured")
+		return
+	}
+
+	user, err := h.userRepo.GetByEmail(r.Context(), session.Email)
+	if err != nil || user == nil {
+		Error(w, http.StatusInternalServerError, "failed to verify user")
+		return
+	}
+	if user.TOTPSecretEnc == nil {
+		Error(w, http.StatusBadRequest, "two-factor authentication is not enabled")
+		return
+	}
+
+	if !h.verifyPassword(req.Password, user.Salt, user.PasswordHash) {
+		ErrorCode(w, http.StatusUnauthorized, CodePasswordIncorrect, "password is incorrect")
+		return
+	}
+
+	meta := getMeta(r, session)
+	codes, err := h.issueBackupCodes(r.Context(), key, session.UserID, meta)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to generate backup codes")
+		return
+	}
+	JSON(w, http.StatusOK, models.BackupCodesResponse{BackupCodes: codes})
+}
+
+// issueBackupCodes replaces the user's backup codes and returns the new plaintext codes.
+// They are shown once; only their hashes are stored.
+func (h *Handler) issueBackupCodes(ctx context.Context, key []byte, userID string, meta *models.RequestMeta) ([]string, error) {
+	codes := make([]string, backupCodeCount)
+	hashes := make([]string, backupCodeCount)
+	for i := range codes {
+		code, err := newBackupCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashBackupCode(key, userID, code)
+	}
+
+	if err := h.userRepo.ReplaceBackupCodes(ctx, userID, hashes, meta); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// newBackupCode returns a code formatted as xxxxx-xxxxx
+func newBackupCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = backupCodeAlphabet[int(b[i])%len(backupCodeAlphabet)]
+	}
+	return string(b[:5]) + "-" + string(b[5:]), nil
+}
+
+// hashBackupCode keys the hash with the server key so a database leak alone
+// is not enough to brute-force the short codes
+func hashBackupCode(key []byte, userID, code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(userID + ":" + normalized))
+	return hex.EncodeToString(mac.Sum(nil))
+}
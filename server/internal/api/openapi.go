@@ -0,0 +1,370 @@
+package api
+
+//go:generate go run ../../cmd/openapi ../../api/openapi.json
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAPI describes the REST routes of every action as an OpenAPI 3.1
+// document, built from the registry and the request and response types.
+// api/openapi.json is this output; a test fails when the two differ.
+func OpenAPI() ([]byte, error) {
+	h := &Handler{}
+	h.registry = registry(h.actions())
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(h.openAPI()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// serveOpenAPI answers GET /api/v1/openapi.json
+func (h *Handler) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	spec, err := OpenAPI()
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to build openapi document")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+type object = map[string]interface{}
+
+func (h *Handler) openAPI() object {
+	s := &schemas{components: object{}}
+
+	paths := object{}
+	for _, action := range h.Actions() {
+		for i, route := range action.Routes {
+			item, _ := paths[route.Path].(object)
+			if item == nil {
+				item = object{}
+				paths[route.Path] = item
+			}
+			item[strings.ToLower(route.Method)] = s.operation(action, route, i)
+		}
+	}
+
+	tags := make([]object, len(actionGroups))
+	for i, g := range actionGroups {
+		tags[i] = object{"name": g}
+	}
+
+	s.components["Response"] = s.object(reflect.TypeOf(Response{}), nil)
+
+	return object{
+		"openapi": "3.1.0",
+		"info": object{
+			"title":   "LetterSheets API",
+			"version": "1",
+			"description": "Every operation is also available as POST /api/execute?action=<x-action> " +
+				"with the same fields as a JSON body.",
+		},
+		"tags":     tags,
+		"paths":    paths,
+		"security": []object{{"bearerAuth": []string{}}},
+		"components": object{
+			"schemas": s.components,
+			"securitySchemes": object{
+				"bearerAuth": object{"type": "http", "scheme": "bearer"},
+			},
+			"responses": object{
+				"Error": object{
+					"description": "The action failed; error says why",
+					"content":     jsonContent(object{"$ref": "#/components/schemas/Response"}),
+				},
+			},
+		},
+	}
+}
+
+// operation describes one route of action; the first route of an action is
+// its operationId, later ones are told apart by their path wildcards
+func (s *schemas) operation(action *Action, route Route, index int) object {
+	inPath := map[string]bool{}
+	var wildcards []string
+	for _, m := range wildcard.FindAllStringSubmatch(route.Path, -1) {
+		inPath[m[1]] = true
+		wildcards = append(wildcards, m[1])
+	}
+
+	id := action.Name
+	if index > 0 {
+		id += "_by_" + strings.Join(wildcards, "_")
+	}
+
+	op := object{
+		"operationId": id,
+		"tags":        []string{action.Group},
+		"x-action":    action.Name,
+	}
+	if action.Permission != "" {
+		op["x-permission"] = action.Permission
+	}
+	if action.Public {
+		op["security"] = []object{}
+	}
+
+	params := []object{}
+	for _, name := range wildcards {
+		schema := object{"type": "string"}
+		if action.Request != nil {
+			if f, ok := jsonField(action.Request, name); ok {
+				schema = constrain(s.schema(indirect(f.Type)), rulesOf(f))
+			}
+		}
+		params = append(params, object{"name": name, "in": "path", "required": true, "schema": schema})
+	}
+
+	if action.Request != nil {
+		if route.Method == http.MethodGet {
+			for _, f := range fields(action.Request) {
+				if !inPath[f.name] {
+					rules := rulesOf(f.StructField)
+					param := object{"name": f.name, "in": "query", "schema": constrain(s.schema(indirect(f.Type)), rules)}
+					if isRequired(rules) {
+						param["required"] = true
+					}
+					params = append(params, param)
+				}
+			}
+		} else if body := s.body(action.Request, inPath); body != nil {
+			op["requestBody"] = object{"required": true, "content": jsonContent(body)}
+		}
+	}
+	if action.Idempotent {
+		params = append(params, object{
+			"name":        "Idempotency-Key",
+			"in":          "header",
+			"description": "Retrying with the same key and request within 24 hours replays the first response",
+			"schema":      object{"type": "string", "maxLength": maxIdempotencyKey},
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	data := object{}
+	if action.Response != nil {
+		data = s.schema(action.Response)
+	}
+	responses := object{
+		strconv.Itoa(route.Status): object{
+			"description": "Success",
+			"content": jsonContent(object{
+				"allOf": []object{
+					{"$ref": "#/components/schemas/Response"},
+					{"type": "object", "properties": object{"data": data}},
+				},
+			}),
+		},
+		"default": object{"$ref": "#/components/responses/Error"},
+	}
+	if route.Method == http.MethodGet {
+		responses["304"] = object{"description": "Not modified since the ETag in If-None-Match"}
+	}
+	op["responses"] = responses
+	return op
+}
+
+// body is the request schema without the fields taken from the path, or nil
+// when nothing is left
+func (s *schemas) body(t reflect.Type, inPath map[string]bool) object {
+	if len(inPath) == 0 {
+		return s.schema(t)
+	}
+	obj := s.object(t, inPath)
+	if len(obj["properties"].(object)) == 0 {
+		return nil
+	}
+	return obj
+}
+
+// ==================== SCHEMAS ====================
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	codeType       = reflect.TypeOf(Code(""))
+)
+
+// schemas converts Go types to JSON Schema the way encoding/json encodes
+// them. Named structs become components and are referenced.
+type schemas struct {
+	components object
+}
+
+func (s *schemas) schema(t reflect.Type) object {
+	switch {
+	case t == timeType:
+		return object{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return object{}
+	case t == codeType:
+		return object{"type": "string", "enum": Codes}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(s.schema(t.Elem()))
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return object{"type": "string", "contentEncoding": "base64"}
+		}
+		return object{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return object{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t, nil)
+		}
+		if _, ok := s.components[t.Name()]; !ok {
+			s.components[t.Name()] = object{} // placeholder for recursive types
+			s.components[t.Name()] = s.object(t, nil)
+		}
+		return object{"$ref": "#/components/schemas/" + t.Name()}
+	case reflect.String:
+		return object{"type": "string"}
+	case reflect.Bool:
+		return object{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return object{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return object{"type": "number"}
+	}
+	return object{}
+}
+
+// object lists the encoded fields of struct t, leaving out skip
+func (s *schemas) object(t reflect.Type, skip map[string]bool) object {
+	props := object{}
+	var required []string
+	for _, f := range fields(t) {
+		if skip[f.name] {
+			continue
+		}
+		rules := rulesOf(f.StructField)
+		props[f.name] = constrain(s.schema(f.Type), rules)
+		if isRequired(rules) {
+			required = append(required, f.name)
+		}
+	}
+	obj := object{"type": "object", "properties": props}
+	if len(required) > 0 {
+		obj["required"] = required
+	}
+	return obj
+}
+
+// constrain adds the validate rules of a field to its schema; rules on a
+// []string hold for each item
+func constrain(schema object, rules []rule) object {
+	target := schema
+	if items, ok := schema["items"].(object); ok {
+		target = items
+	}
+	if _, ok := target["type"]; !ok {
+		return schema
+	}
+	for _, r := range rules {
+		switch r.name {
+		case "email":
+			target["format"] = "email"
+		case "uuid":
+			target["format"] = "uuid"
+		case "max":
+			target["maxLength"] = r.max
+		case "enum":
+			values := []interface{}{}
+			for _, v := range enums[r.arg] {
+				values = append(values, v)
+			}
+			if typ, ok := target["type"].([]string); ok && len(typ) == 2 {
+				values = append(values, nil) // nullable
+			}
+			target["enum"] = values
+		}
+	}
+	return schema
+}
+
+func isRequired(rules []rule) bool {
+	for _, r := range rules {
+		if r.name == "required" {
+			return true
+		}
+	}
+	return false
+}
+
+// nullable lets schema also match null, as a nil pointer encodes
+func nullable(schema object) object {
+	switch typ := schema["type"].(type) {
+	case string:
+		schema["type"] = []string{typ, "null"}
+		return schema
+	case nil:
+		if len(schema) == 0 {
+			return schema
+		}
+	}
+	return object{"anyOf": []object{schema, {"type": "null"}}}
+}
+
+type field struct {
+	reflect.StructField
+	name string
+}
+
+// fields lists the fields encoding/json writes for struct t, flattening
+// embedded structs
+func fields(t reflect.Type) []field {
+	var list []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && indirect(f.Type).Kind() == reflect.Struct {
+			for _, sub := range fields(indirect(f.Type)) {
+				sub.Index = append([]int{i}, sub.Index...)
+				list = append(list, sub)
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		list = append(list, field{StructField: f, name: name})
+	}
+	return list
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		return t.Elem()
+	}
+	return t
+}
+
+func jsonContent(schema object) object {
+	return object{"application/json": object{"schema": schema}}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestOpenAPIUpToDate fails when api/openapi.json no longer matches the
+// registry and the request and response types
+func TestOpenAPIUpToDate(t *testing.T) {
+	committed, err := os.ReadFile("../../api/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := OpenAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(committed, spec) {
+		t.Fatal("api/openapi.json is out of date; run go generate ./internal/api")
+	}
+}
+
+// TestOpenAPIOperations checks that every route is described once and every
+// reference resolves
+func TestOpenAPIOperations(t *testing.T) {
+	spec, err := OpenAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+		}
+		Components struct{ Schemas map[string]json.RawMessage }
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Handler{}
+	h.registry = registry(h.actions())
+	ids := map[string]bool{}
+	for _, action := range h.Actions() {
+		for _, route := range action.Routes {
+			op, ok := doc.Paths[route.Path][strings.ToLower(route.Method)]
+			if !ok {
+				t.Errorf("%s %s (%s) is not described", route.Method, route.Path, action.Name)
+				continue
+			}
+			if ids[op.OperationID] {
+				t.Errorf("operationId %s is not unique", op.OperationID)
+			}
+			ids[op.OperationID] = true
+		}
+		if action.Response == nil {
+			t.Errorf("%s does not declare its response type", action.Name)
+		}
+	}
+
+	refs := regexp.MustCompile(`"#/components/schemas/(\w+)"`)
+	for _, ref := range refs.FindAllSubmatch(spec, -1) {
+		if _, ok := doc.Components.Schemas[string(ref[1])]; !ok {
+			t.Errorf("unresolved reference to %s", ref[1])
+		}
+	}
+}
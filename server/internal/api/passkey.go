@@ -0,0 +1,329 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"lettersheets/internal/webauthn"
+	"lettersheets/models"
+
+	"github.com/google/uuid"
+)
+
+// ==================== PASSKEY REGISTRATION ====================
+
+func (h *Handler) webauthnRegisterBegin(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
+	existing, err := h.webauthnRepo.ListByUser(r.Context(), session.UserID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to get passkeys")
+		return
+	}
+
+	challenge, err := h.newWebAuthnChallenge(r.Context(), session.UserID, models.WebAuthnPurposeRegister)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to create challenge")
+		return
+	}
+
+	rp := h.relyingParty()
+	JSON(w, http.StatusOK, models.WebAuthnOptionsResponse{
+		PublicKey: map[string]interface{}{
+			"challenge": challenge,
+			"rp":        map[string]string{"id": rp.ID, "name": rp.Name},
+			"user": map[string]string{
+				"id":          base64.RawURLEncoding.EncodeToString([]byte(session.UserID)),
+				"name":        session.Email,
+				"displayName": session.Username,
+			},
+			"pubKeyCredParams": []map[string]interface{}{
+				{"type": "public-key", "alg": webauthn.AlgES256},
+				{"type": "public-key", "alg": webauthn.AlgEdDSA},
+				{"type": "public-key", "alg": webauthn.AlgRS256},
+			},
+			"timeout":     h.cfg.Server.WebAuthnTimeoutSeconds * 1000,
+			"attestation": "none",
+			// Login lists no credentials, so only discoverable passkeys can be used
+			"authenticatorSelection": map[string]interface{}{
+				"residentKey":        "required",
+				"requireResidentKey": true,
+				"userVerification":   "preferred",
+			},
+			"excludeCredentials": credentialDescriptors(existing),
+		},
+	})
+}
+
+func (h *Handler) webauthnRegisterFinish(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.WebAuthnRegisterRequest) {
+	req.Name = strings.TrimSpace(req.Name)
+
+	var resp webauthn.AttestationResponse
+	if err := json.Unmarshal(req.Credential, &resp); err != nil {
+		Error(w, http.StatusBadRequest, "invalid credential")
+		return
+	}
+
+	challenge, err := webauthn.ClientChallenge(resp.Response.ClientDataJSON)
+	if err != nil {
+		Error(w, http.StatusBadRequest, "invalid credential")
+		return
+	}
+
+	found, userID, err := h.webauthnRepo.ConsumeChallenge(r.Context(), hashChallenge(challenge), models.WebAuthnPurposeRegister)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to verify challenge")
+		return
+	}
+	if !found || userID != session.UserID {
+		ErrorCode(w, http.StatusUnauthorized, CodeInvalidToken, "invalid or expired challenge")
+		return
+	}
+
+	cred, err := h.relyingParty().VerifyRegistration(challenge, &resp, false)
+	if err != nil {
+		Error(w, http.StatusBadRequest, "passkey registration failed")
+		return
+	}
+
+	existing, err := h.webauthnRepo.GetCredential(r.Context(), cred.ID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to register passkey")
+		return
+	}
+	if existing != nil {
+		Error(w, http.StatusConflict, "passkey is already registered")
+		return
+	}
+
+	credential := &models.WebAuthnCredential{
+		ID:           uuid.New().String(),
+		UserID:       session.UserID,
+		CredentialID: cred.ID,
+		PublicKey:    cred.PublicKey,
+		SignCount:    cred.SignCount,
+		AAGUID:       cred.AAGUID,
+		Name:         req.Name,
+	}
+	if len(resp.Response.Transports) > 0 {
+		credential.Transports = strPtr(strings.Join(resp.Response.Transports, ","))
+	}
+
+	meta := getMeta(r, session)
+	if err := h.webauthnRepo.CreateCredential(r.Context(), credential, meta); err != nil {
+		writeFailed(w, err, "failed to register passkey")
+		return
+	}
+
+	credential.CreatedAt = time.Now()
+	JSON(w, http.StatusCreated, credential)
+}
+
+func (h *Handler) listWebAuthnCredentials(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
+	creds, err := h.webauthnRepo.ListByUser(r.Context(), session.UserID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to get passkeys")
+		return
+	}
+	JSON(w, http.StatusOK, creds)
+}
+
+func (h *Handler) deleteWebAuthnCredential(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.DeleteWebAuthnCredentialRequest) {
+	user, err := h.userRepo.GetByEmail(r.Context(), session.Email)
+	if err != nil || user == nil {
+		Error(w, http.StatusInternalServerError, "failed to verify user")
+		return
+	}
+	if !h.verifyPassword(req.Password, user.Salt, user.PasswordHash) {
+		ErrorCode(w, http.StatusUnauthorized, CodePasswordIncorrect, "password is incorrect")
+		return
+	}
+
+	meta := getMeta(r, session)
+	deleted, err := h.webauthnRepo.DeleteCredential(r.Context(), req.ID, session.UserID, meta)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to delete passkey")
+		return
+	}
+	if !deleted {
+		Error(w, http.StatusNotFound, "passkey not found")
+		return
+	}
+	JSON(w, http.StatusOK, models.MessageResponse{Message: "passkey deleted"})
+}
+
+// ==================== PASSKEY LOGIN ====================
+
+// webauthnLoginBegin issues an assertion challenge that any discoverable
+// passkey may answer. It names no user and lists no credentials, even for a
+// given email, so the response does not reveal which accounts exist or have
+// passkeys; the credential the authenticator picks identifies the user.
+func (h *Handler) webauthnLoginBegin(w http.ResponseWriter, r *http.Request, _ *models.WebAuthnLoginBeginRequest) {
+	challenge, err := h.newWebAuthnChallenge(r.Context(), "", models.WebAuthnPurposeLogin)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to create challenge")
+		return
+	}
+
+	JSON(w, http.StatusOK, models.WebAuthnOptionsResponse{
+		PublicKey: map[string]interface{}{
+			"challenge":        challenge,
+			"rpId":             h.cfg.Server.WebAuthnRPID,
+			"timeout":          h.cfg.Server.WebAuthnTimeoutSeconds * 1000,
+			"userVerification": "preferred",
+			"allowCredentials": []interface{}{},
+		},
+	})
+}
+
+// webauthnLoginFinish is passwordless login: a user-verified passkey stands in
+// for both the password and the second factor
+func (h *Handler) webauthnLoginFinish(w http.ResponseWriter, r *http.Request, req *models.WebAuthnLoginRequest) {
+	cred, signCount, ok, err := h.checkPasskey(r.Context(), req.Credential, true)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "login failed")
+		return
+	}
+	if !ok {
+		h.passkeyLoginFailed(w, r, cred)
+		return
+	}
+
+	user, err := h.userRepo.GetByEmail(r.Context(), cred.Email)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "login failed")
+		return
+	}
+	if user == nil {
+		ErrorCode(w, http.StatusUnauthorized, CodeInvalidMFA, "invalid passkey")
+		return
+	}
+
+	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		h.recordLogin(r, user.ID, user.Email, models.LoginBlocked, models.LoginReasonLocked)
+		ErrorCode(w, http.StatusForbidden, CodeAccountLocked, "account is locked, try again later")
+		return
+	}
+
+	if !user.IsActive {
+		h.recordLogin(r, user.ID, user.Email, models.LoginBlocked, models.LoginReasonDeactivated)
+		ErrorCode(w, http.StatusForbidden, CodeAccountDeactivated, "account is deactivated")
+		return
+	}
+
+	// Only a login that goes through moves the signature counter on
+	accepted, err := h.webauthnRepo.UseCredential(r.Context(), cred.ID, signCount)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "login failed")
+		return
+	}
+	if !accepted {
+		h.passkeyLoginFailed(w, r, cred)
+		return
+	}
+
+	h.completeLogin(w, r, user, nil, models.LoginReasonPasskey)
+}
+
+// passkeyLoginFailed refuses a passwordless login, logged against the
+// credential's owner when it is one of ours. It does not count toward
+// lockout: credential IDs are not secret, so anyone could otherwise lock the
+// owner out.
+func (h *Handler) passkeyLoginFailed(w http.ResponseWriter, r *http.Request, cred *models.WebAuthnCredential) {
+	userID, email := "", ""
+	if cred != nil {
+		userID, email = cred.UserID, cred.Email
+	}
+	h.recordLogin(r, userID, email, models.LoginFailure, models.LoginReasonInvalidPasskey)
+	ErrorCode(w, http.StatusUnauthorized, CodeInvalidMFA, "invalid passkey")
+}
+
+// checkPasskey verifies an assertion against the challenge it answers and the
+// stored credential. ok is false when the assertion was rejected; cred is then
+// the stored credential it named, if any. The new signature counter is not
+// stored: the caller passes signCount to UseCredential once it has accepted
+// the credential's owner, so a refused login leaves the counter alone.
+func (h *Handler) checkPasskey(ctx context.Context, raw json.RawMessage, requireUV bool) (cred *models.WebAuthnCredential, signCount uint32, ok bool, err error) {
+	var resp webauthn.AssertionResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return nil, 0, false, nil
+	}
+
+	cred, err = h.webauthnRepo.GetCredential(ctx, resp.RawID)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	challenge, err := webauthn.ClientChallenge(resp.Response.ClientDataJSON)
+	if err != nil {
+		return cred, 0, false, nil
+	}
+
+	found, challengeUserID, err := h.webauthnRepo.ConsumeChallenge(ctx, hashChallenge(challenge), models.WebAuthnPurposeLogin)
+	if err != nil || !found || cred == nil {
+		return cred, 0, false, err
+	}
+
+	if challengeUserID != "" && challengeUserID != cred.UserID {
+		return cred, 0, false, nil
+	}
+	if len(resp.Response.UserHandle) > 0 && string(resp.Response.UserHandle) != cred.UserID {
+		return cred, 0, false, nil
+	}
+
+	signCount, err = h.relyingParty().VerifyAssertion(challenge, &webauthn.Credential{
+		ID:        cred.CredentialID,
+		PublicKey: cred.PublicKey,
+		SignCount: cred.SignCount,
+	}, &resp, requireUV)
+	if err != nil {
+		return cred, 0, false, nil
+	}
+	return cred, signCount, true, nil
+}
+
+// ==================== HELPERS ====================
+
+func (h *Handler) relyingParty() *webauthn.RelyingParty {
+	return &webauthn.RelyingParty{
+		ID:      h.cfg.Server.WebAuthnRPID,
+		Name:    h.cfg.Server.WebAuthnRPName,
+		Origins: h.cfg.Server.WebAuthnOrigins,
+	}
+}
+
+// newWebAuthnChallenge stores a fresh challenge and returns it base64url-encoded
+func (h *Handler) newWebAuthnChallenge(ctx context.Context, userID, purpose string) (string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().Add(time.Duration(h.cfg.Server.WebAuthnTimeoutSeconds) * time.Second)
+	err = h.webauthnRepo.CreateChallenge(ctx, uuid.New().String(), userID, hashChallenge(challenge), purpose, expiresAt)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(challenge), nil
+}
+
+func hashChallenge(challenge []byte) string {
+	return hashToken(base64.RawURLEncoding.EncodeToString(challenge))
+}
+
+func credentialDescriptors(creds []models.WebAuthnCredential) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(creds))
+	for _, c := range creds {
+		d := map[string]interface{}{
+			"type": "public-key",
+			"id":   base64.RawURLEncoding.EncodeToString(c.CredentialID),
+		}
+		if c.Transports != nil {
+			d["transports"] = strings.Split(*c.Transports, ",")
+		}
+		result = append(result, d)
+	}
+	return result
+}
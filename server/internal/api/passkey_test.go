@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lettersheets/models"
+)
+
+// passkeyLogin runs webauthn_login_finish with an assertion of credID over
+// challenge, signed with key; a nil key sends a forged signature
+func passkeyLogin(h *Handler, credID string, challenge, authData []byte, key ed25519.PrivateKey) int {
+	b64 := base64.RawURLEncoding.EncodeToString
+	clientData, _ := json.Marshal(map[string]string{
+		"type": "webauthn.get", "challenge": b64(challenge), "origin": "https://app.example.com",
+	})
+	signature := []byte("forged")
+	if key != nil {
+		hash := sha256.Sum256(clientData)
+		signature = ed25519.Sign(key, append(append([]byte(nil), authData...), hash[:]...))
+	}
+	credential, _ := json.Marshal(map[string]interface{}{
+		"id": b64([]byte(credID)), "rawId": b64([]byte(credID)), "type": "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(signature),
+		},
+	})
+	body, _ := json.Marshal(map[string]json.RawMessage{"credential": credential})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/execute?action=webauthn_login_finish", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.Execute(rec, req)
+	return rec.Code
+}
+
+func TestPasskeyLoginFailureRecorded(t *testing.T) {
+	db, f := openFakeDB()
+	defer db.Close()
+	h := newTestHandler(db)
+	h.cfg.RateLimit.IPPerMinute, h.cfg.RateLimit.IPBurst = 60, 10
+	h.cfg.Server.WebAuthnRPID = "app.example.com"
+	h.cfg.Server.WebAuthnOrigins = []string{"https://app.example.com"}
+	f.credentials["known-credential"] = "user-1"
+
+	finish := func(credID string) int {
+		challenge := []byte("challenge for " + credID)
+		f.challenges[hashChallenge(challenge)] = ""
+		return passkeyLogin(h, credID, challenge, make([]byte, 37), nil)
+	}
+
+	if status := finish("known-credential"); status != http.StatusUnauthorized {
+		t.Fatalf("known credential: status = %d", status)
+	}
+	if status := finish("unknown-credential"); status != http.StatusUnauthorized {
+		t.Fatalf("unknown credential: status = %d", status)
+	}
+
+	want := []fakeLoginAttempt{
+		{userID: "user-1", email: "user-1@example.com", outcome: models.LoginFailure, reason: models.LoginReasonInvalidPasskey},
+		{userID: nil, email: "", outcome: models.LoginFailure, reason: models.LoginReasonInvalidPasskey},
+	}
+	if len(f.loginAttempts) != len(want) {
+		t.Fatalf("recorded %+v, want %+v", f.loginAttempts, want)
+	}
+	for i := range want {
+		if f.loginAttempts[i] != want[i] {
+			t.Errorf("attempt %d = %+v, want %+v", i, f.loginAttempts[i], want[i])
+		}
+	}
+}
+
+// TestPasskeyLoginBegin checks that the options are the same whether or not
+// the email has passkeys
+func TestPasskeyLoginBegin(t *testing.T) {
+	db, f := openFakeDB()
+	defer db.Close()
+	h := newTestHandler(db)
+	h.cfg.RateLimit.IPPerMinute, h.cfg.RateLimit.IPBurst = 60, 10
+	h.cfg.RateLimit.EmailPerMinute, h.cfg.RateLimit.EmailBurst = 60, 10
+	h.cfg.RateLimit.AccountPerMinute, h.cfg.RateLimit.AccountBurst = 60, 10
+	f.users["user-1@example.com"] = &fakeUser{id: "user-1", active: true}
+	f.credentials["known-credential"] = "user-1"
+
+	for _, email := range []string{"user-1@example.com", "nobody@example.com", ""} {
+		status, resp := execute(h, "", "webauthn_login_begin", map[string]string{"email": email})
+		if status != http.StatusOK {
+			t.Fatalf("%q: status = %d", email, status)
+		}
+		options, _ := json.Marshal(resp.Data)
+		if !strings.Contains(string(options), `"allowCredentials":[]`) {
+			t.Errorf("%q: options list credentials: %s", email, options)
+		}
+	}
+
+	if len(f.challenges) != 3 {
+		t.Fatalf("%d challenges stored", len(f.challenges))
+	}
+	for _, userID := range f.challenges {
+		if userID != "" {
+			t.Errorf("challenge bound to %s", userID)
+		}
+	}
+}
+
+// TestPasskeyLoginBlocked checks that a valid passkey of a locked or
+// deactivated user is refused without storing its signature counter
+func TestPasskeyLoginBlocked(t *testing.T) {
+	db, f := openFakeDB()
+	defer db.Close()
+	h := newTestHandler(db)
+	h.cfg.RateLimit.IPPerMinute, h.cfg.RateLimit.IPBurst = 60, 10
+	h.cfg.Server.WebAuthnRPID = "app.example.com"
+	h.cfg.Server.WebAuthnOrigins = []string{"https://app.example.com"}
+
+	pub, key, _ := ed25519.GenerateKey(nil)
+	coseKey := append([]byte{0xa4, 0x01, 0x01, 0x03, 0x27, 0x20, 0x06, 0x21, 0x58, 0x20}, pub...)
+	rpIDHash := sha256.Sum256([]byte("app.example.com"))
+	authData := append(rpIDHash[:], 0x05, 0, 0, 0, 0) // user present and verified
+
+	lockedUntil := time.Now().Add(time.Hour)
+	cases := []struct {
+		user   *fakeUser
+		status int
+		reason string
+	}{
+		{&fakeUser{id: "locked", active: true, lockedUntil: lockedUntil}, http.StatusForbidden, models.LoginReasonLocked},
+		{&fakeUser{id: "deactivated", active: false}, http.StatusForbidden, models.LoginReasonDeactivated},
+	}
+	for _, c := range cases {
+		credID := "credential of " + c.user.id
+		f.users[c.user.id+"@example.com"] = c.user
+		f.credentials[credID] = c.user.id
+		f.credentialKeys[credID] = coseKey
+		challenge := []byte("challenge for " + c.user.id)
+		f.challenges[hashChallenge(challenge)] = ""
+
+		if status := passkeyLogin(h, credID, challenge, authData, key); status != c.status {
+			t.Errorf("%s: status = %d, want %d", c.user.id, status, c.status)
+		}
+		last := f.loginAttempts[len(f.loginAttempts)-1]
+		if last.userID != c.user.id || last.outcome != models.LoginBlocked || last.reason != c.reason {
+			t.Errorf("%s: recorded %+v", c.user.id, last)
+		}
+	}
+	if len(f.usedCredentials) != 0 {
+		t.Fatalf("signature counter stored for %v", f.usedCredentials)
+	}
+}
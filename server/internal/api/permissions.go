@@ -0,0 +1,96 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"lettersheets/internal/permission"
+	"lettersheets/models"
+)
+
+// ==================== PERMISSIONS ====================
+
+// permissions returns what the session may do in its company. Overrides that
+// cannot be read grant nothing, so a corrupt row never widens access.
+func (h *Handler) permissions(session *models.UserSession) permission.Set {
+	perms, err := permission.Effective(session.Role, session.Permissions)
+	if err != nil {
+		log.Printf("unreadable permissions for user %s in company %s: %v", session.UserID, session.CompanyID, err)
+		return permission.Set{}
+	}
+	return perms
+}
+
+// effectivePermissions lists what a member may do, for the client to gate its UI
+func effectivePermissions(role string, raw *string) []string {
+	perms, err := permission.Effective(role, raw)
+	if err != nil {
+		return []string{}
+	}
+	return perms.List()
+}
+
+// checkAccessChange validates a new role and overrides for a member, and
+// refuses to hand out anything the caller does not hold. It returns the
+// rejected field, or nil when the change is allowed.
+func checkAccessChange(caller permission.Set, role, raw *string) *FieldError {
+	if role != nil {
+		if !isRole(*role) {
+			return &FieldError{Field: "role", Message: "invalid role: " + *role}
+		}
+		for p := range permission.Defaults(*role) {
+			if !caller.Has(p) {
+				return &FieldError{Field: "role", Message: "cannot assign a role with permissions you do not hold"}
+			}
+		}
+	}
+
+	if raw != nil {
+		o, err := permission.Parse(raw)
+		if err == nil {
+			err = o.Validate()
+		}
+		if err != nil {
+			return &FieldError{Field: "permissions", Message: err.Error()}
+		}
+		for _, p := range o.Grant {
+			if !caller.Has(p) {
+				return &FieldError{Field: "permissions", Message: "cannot grant a permission you do not hold: " + p}
+			}
+		}
+	}
+	return nil
+}
+
+// canManage finds the active member of the session's company with accessID
+// or userID, and refuses to touch one who holds a permission the caller
+// does not, so an admin cannot demote, restrict or remove a superadmin. It
+// writes the error response and returns false when the change is refused.
+func (h *Handler) canManage(w http.ResponseWriter, r *http.Request, session *models.UserSession, accessID, userID, notFound string) bool {
+	members, err := h.accessRepo.GetCompanyUsers(r.Context(), tenant(session))
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to load member")
+		return false
+	}
+
+	caller := h.permissions(session)
+	for _, m := range members {
+		if (accessID == "" || m.ID != accessID) && (userID == "" || m.UserID != userID) {
+			continue
+		}
+		// Unreadable overrides still leave the member their role's defaults
+		perms, err := permission.Effective(m.Role, m.Permissions)
+		if err != nil {
+			perms = permission.Defaults(m.Role)
+		}
+		for p := range perms {
+			if !caller.Has(p) {
+				ErrorCode(w, http.StatusForbidden, CodePermissionDenied, "cannot change a member who holds permissions you do not hold")
+				return false
+			}
+		}
+		return true
+	}
+	Error(w, http.StatusNotFound, notFound)
+	return false
+}
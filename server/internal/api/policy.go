@@ -0,0 +1,236 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+	"unicode"
+
+	"lettersheets/internal/password"
+	"lettersheets/models"
+)
+
+// ==================== SECURITY POLICY ====================
+
+func (h *Handler) getSecurityPolicy(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
+	policy, err := h.companyRepo.GetSecurityPolicy(r.Context(), session.CompanyID, session.UserID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to get security policy")
+		return
+	}
+	if policy == nil {
+		Error(w, http.StatusNotFound, "security policy not found")
+		return
+	}
+	JSON(w, http.StatusOK, policy)
+}
+
+// updateSecurityPolicy replaces the whole policy: omitted limits reset to the server default
+func (h *Handler) updateSecurityPolicy(w http.ResponseWriter, r *http.Request, session *models.UserSession, req *models.SecurityPolicy) {
+	req.CompanyID = session.CompanyID
+
+	if req.PasswordMinLength == 0 {
+		req.PasswordMinLength = 8
+	}
+	if req.PasswordMinLength < 8 || req.PasswordMinLength > 128 {
+		Error(w, http.StatusBadRequest, "password_min_length must be between 8 and 128")
+		return
+	}
+	for _, v := range []*int{req.PasswordMaxAgeDays, req.SessionHours, req.MaxLoginAttempts, req.LockoutMinutes} {
+		if v != nil && *v <= 0 {
+			Error(w, http.StatusBadRequest, "policy limits must be positive")
+			return
+		}
+	}
+	for _, cidr := range req.AllowedIPCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			Error(w, http.StatusBadRequest, "invalid CIDR in allowed_ip_cidrs: "+cidr)
+			return
+		}
+	}
+
+	// Refuse a network restriction that would lock out the admin making the change
+	if !ipAllowed(req, r) {
+		Error(w, http.StatusBadRequest, "allowed_ip_cidrs must include your current address")
+		return
+	}
+
+	meta := getMeta(r, session)
+	if err := h.companyRepo.UpdateSecurityPolicy(r.Context(), req, meta); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to update security policy")
+		return
+	}
+	JSON(w, http.StatusOK, models.MessageResponse{Message: "security policy updated"})
+}
+
+// ==================== ENFORCEMENT ====================
+
+// enforcePolicy applies the session's company policy to an authenticated request.
+// It writes the error response and returns false when the request must stop.
+func (h *Handler) enforcePolicy(w http.ResponseWriter, r *http.Request, session *models.UserSession, action *Action) bool {
+	policy, err := h.companyRepo.GetSecurityPolicy(r.Context(), session.CompanyID, session.UserID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to load security policy")
+		return false
+	}
+	if policy == nil {
+		return true
+	}
+
+	if !ipAllowed(policy, r) {
+		ErrorCode(w, http.StatusForbidden, CodeIPNotAllowed, "access from this network is not allowed")
+		return false
+	}
+
+	if passwordExpired(policy) && !action.AllowExpiredPassword {
+		ErrorCode(w, http.StatusForbidden, CodePasswordExpired, "password has expired, change_password is required")
+		return false
+	}
+	if mfaRequired(policy, session.Role) && !policy.MFAEnrolled && !action.AllowMFAPending {
+		ErrorCode(w, http.StatusForbidden, CodeMFAEnrollmentRequired, "two-factor authentication is required, enroll a passkey or authenticator app")
+		return false
+	}
+	return true
+}
+
+// loginLimits returns the strictest lockout settings across the user's companies
+func (h *Handler) loginLimits(policies []models.SecurityPolicy) (maxAttempts, lockoutMinutes int) {
+	maxAttempts = h.cfg.Server.MaxLoginAttempts
+	lockoutMinutes = h.cfg.Server.LockoutMinutes
+	for _, p := range policies {
+		if p.MaxLoginAttempts != nil && *p.MaxLoginAttempts < maxAttempts {
+			maxAttempts = *p.MaxLoginAttempts
+		}
+		if p.LockoutMinutes != nil && *p.LockoutMinutes > lockoutMinutes {
+			lockoutMinutes = *p.LockoutMinutes
+		}
+	}
+	return maxAttempts, lockoutMinutes
+}
+
+// sessionHours returns the absolute session lifetime for a company
+func (h *Handler) sessionHours(policy *models.SecurityPolicy) int {
+	if policy != nil && policy.SessionHours != nil {
+		return *policy.SessionHours
+	}
+	return h.cfg.Server.SessionHours
+}
+
+// checkPassword returns every reason pw is unacceptable: company policies,
+// the strength estimate and the breached-password list. userInputs are
+// values an attacker would try first (email, username, company name).
+func (h *Handler) checkPassword(pw string, policies []models.SecurityPolicy, userInputs ...string) []password.Reason {
+	reasons := checkPasswordPolicy(pw, policies)
+
+	strength := password.Estimate(pw, userInputs...)
+	if strength.Score < h.cfg.Server.PasswordScore() {
+		reasons = append(reasons, password.Reason{Code: password.ReasonWeak, Message: "password is too easy to guess"})
+		reasons = append(reasons, strength.Feedback...)
+	}
+
+	count, err := h.breached.Count(pw)
+	if err != nil {
+		log.Printf("breached password check failed: %v", err)
+		reasons = append(reasons, password.Reason{Code: password.ReasonBreachUnknown, Message: "password could not be checked, try again"})
+	} else if count > 0 {
+		reasons = append(reasons, password.Reason{Code: password.ReasonBreached, Message: "password has appeared in a data breach"})
+	}
+	return reasons
+}
+
+// rejectPassword responds with the first reason as the error and all of them as data
+func rejectPassword(w http.ResponseWriter, reasons []password.Reason) {
+	ErrorData(w, http.StatusBadRequest, CodePasswordRejected, reasons[0].Message, map[string]interface{}{"reasons": reasons})
+}
+
+// checkPasswordPolicy returns the company policies the password violates
+func checkPasswordPolicy(pw string, policies []models.SecurityPolicy) []password.Reason {
+	minLength := 8
+	complexity := false
+	for _, p := range policies {
+		if p.PasswordMinLength > minLength {
+			minLength = p.PasswordMinLength
+		}
+		complexity = complexity || p.PasswordRequireComplexity
+	}
+
+	var reasons []password.Reason
+	if len([]rune(pw)) < minLength {
+		reasons = append(reasons, password.Reason{Code: password.ReasonTooShort, Message: fmt.Sprintf("password must be at least %d characters", minLength)})
+	}
+
+	if complexity {
+		var upper, lower, digit, symbol bool
+		for _, c := range pw {
+			switch {
+			case unicode.IsUpper(c):
+				upper = true
+			case unicode.IsLower(c):
+				lower = true
+			case unicode.IsDigit(c):
+				digit = true
+			default:
+				symbol = true
+			}
+		}
+		if !upper || !lower || !digit || !symbol {
+			reasons = append(reasons, password.Reason{Code: password.ReasonComplexity, Message: "password must contain upper and lower case letters, a digit and a symbol"})
+		}
+	}
+	return reasons
+}
+
+func passwordExpired(policy *models.SecurityPolicy) bool {
+	if policy.PasswordMaxAgeDays == nil || policy.PasswordChangedAt == nil {
+		return false
+	}
+	maxAge := time.Duration(*policy.PasswordMaxAgeDays) * 24 * time.Hour
+	return time.Since(*policy.PasswordChangedAt) > maxAge
+}
+
+func mfaRequired(policy *models.SecurityPolicy, role string) bool {
+	for _, r := range policy.MFARequiredRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func ipAllowed(policy *models.SecurityPolicy, r *http.Request) bool {
+	if len(policy.AllowedIPCIDRs) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range policy.AllowedIPCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP strips the port from RemoteAddr
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func isRole(role string) bool {
+	for _, r := range models.Roles {
+		if role == r {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"lettersheets/internal/ratelimit"
+)
+
+// Public actions are throttled per client IP and, when the body names one,
+// per target email from that IP and per target email from all IPs. The
+// email buckets are shared across actions so that spreading guesses over
+// login and password reset does not help. The per-IP email bucket is small;
+// the one from all IPs is larger, so that guessing from many IPs is slowed
+// down without one IP being able to spend it alone. Its 429 only delays the
+// user while it refills and never locks the account.
+
+// maxPeekBody bounds how much of a request body is read to find the email
+const maxPeekBody = 1 << 20
+
+// rateLimit takes a token for the request. It writes a 429 with Retry-After
+// and returns false when a bucket is empty. A failing store lets the request
+// through rather than taking the login page down with it.
+func (h *Handler) rateLimit(w http.ResponseWriter, r *http.Request, action string) bool {
+	keys := []string{"ip:" + action + ":" + clientIP(r)}
+	limits := []ratelimit.Limit{h.cfg.RateLimit.IPLimit()}
+	if email := requestEmail(r); email != "" {
+		keys = append(keys, "email:"+emailBucket(email)+":"+clientIP(r), "email:"+emailBucket(email))
+		limits = append(limits, h.cfg.RateLimit.EmailLimit(), h.cfg.RateLimit.AccountLimit())
+	}
+
+	for i, key := range keys {
+		allowed, retryAfter, err := h.limiter.Take(r.Context(), key, limits[i])
+		if err != nil {
+			log.Printf("rate limit store failed: %v", err)
+			continue
+		}
+		if !allowed {
+			tooManyRequests(w, retryAfter)
+			return false
+		}
+	}
+	return true
+}
+
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	Error(w, http.StatusTooManyRequests, "too many requests, try again later")
+}
+
+// emailBucket identifies an email in a bucket key by a hash, so the key fits
+// rate_limit_buckets.bucket_key however long the address is
+func emailBucket(email string) string {
+	sum := sha256.Sum256([]byte(email))
+	return hex.EncodeToString(sum[:16])
+}
+
+// requestEmail returns the normalized "email" field of a JSON body, leaving
+// the body intact for the handler
+func requestEmail(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPeekBody))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	_ = json.Unmarshal(body, &req)
+	return strings.ToLower(strings.TrimSpace(req.Email))
+}
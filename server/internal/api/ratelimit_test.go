@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lettersheets/internal/ratelimit"
+)
+
+// keyRecorder remembers the bucket keys taken from a store
+type keyRecorder struct {
+	ratelimit.Store
+	keys []string
+}
+
+func (k *keyRecorder) Take(ctx context.Context, key string, limit ratelimit.Limit) (bool, time.Duration, error) {
+	k.keys = append(k.keys, key)
+	return k.Store.Take(ctx, key, limit)
+}
+
+func TestRateLimitEmail(t *testing.T) {
+	db, _ := openFakeDB()
+	defer db.Close()
+	h := newTestHandler(db)
+	h.cfg.RateLimit.IPPerMinute, h.cfg.RateLimit.IPBurst = 60, 100
+	h.cfg.RateLimit.EmailPerMinute, h.cfg.RateLimit.EmailBurst = 1, 2
+	h.cfg.RateLimit.AccountPerMinute, h.cfg.RateLimit.AccountBurst = 1, 4
+	store := &keyRecorder{Store: ratelimit.NewMemoryStore()}
+	h.limiter = store
+
+	take := func(ip, email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/execute?action=login", strings.NewReader(`{"email":"`+email+`"}`))
+		req.RemoteAddr = ip + ":40000"
+		rec := httptest.NewRecorder()
+		h.rateLimit(rec, req, "login")
+		return rec
+	}
+
+	take("203.0.113.7", "jane@example.com")
+	take("203.0.113.7", "Jane@Example.com")
+	if rec := take("203.0.113.7", "jane@example.com"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("third attempt: %d Retry-After %q, want 429 after 60s", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// Spending the bucket from one IP does not lock the user out elsewhere
+	if rec := take("198.51.100.1", "jane@example.com"); rec.Code != http.StatusOK {
+		t.Fatalf("attempt from another IP: %d", rec.Code)
+	}
+
+	// but guessing from many IPs runs into the bucket of the email
+	take("198.51.100.2", "jane@example.com")
+	if rec := take("198.51.100.3", "jane@example.com"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("attempt from a fresh IP past the email limit: %d Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := take("198.51.100.3", "ann@example.com"); rec.Code != http.StatusOK {
+		t.Fatalf("other email from the same IP: %d", rec.Code)
+	}
+
+	store.keys = nil
+	take("2001:db8::1", strings.Repeat("a", 300)+"@example.com")
+	for _, key := range store.keys {
+		if len(key) > 255 || strings.Contains(key, "aaaa") {
+			t.Errorf("bucket key %q does not fit rate_limit_buckets", key)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"lettersheets/internal/permission"
+	"lettersheets/models"
+)
+
+// Action declares one endpoint of POST /api/execute?action=<Name>. Execute
+// authenticates, authorizes and decodes the request from these fields, so
+// handlers only see callers that hold the action's permission.
+type Action struct {
+	Name  string `json:"name"`
+	Group string `json:"group"`
+
+	// Permission the caller must hold; empty for self-service actions
+	Permission string `json:"permission,omitempty"`
+
+	// Public actions run without a session and are rate limited per IP and email
+	Public      bool `json:"public"`
+	RateLimited bool `json:"rate_limited"`
+
+	// Still allowed while the company policy restricts the session
+	AllowExpiredPassword bool `json:"allow_expired_password,omitempty"`
+	AllowMFAPending      bool `json:"allow_mfa_pending,omitempty"`
+
+	// Accepts an Idempotency-Key header; a retry with the key replays the first response
+	Idempotent bool `json:"idempotent,omitempty"`
+
+	// Request is the JSON body type, nil when the action takes no body
+	Request reflect.Type `json:"-"`
+
+	// Response is the type of Response.Data on success
+	Response reflect.Type `json:"-"`
+
+	// REST endpoints serving the action besides /api/execute
+	Routes []Route `json:"routes,omitempty"`
+
+	run func(w http.ResponseWriter, r *http.Request, session *models.UserSession)
+}
+
+var actionGroups = []string{"Auth", "Sessions", "Company", "Security policy", "User", "Two-factor", "Passkeys", "Login history", "Access", "History", "Meta"}
+
+// actions lists every action in the order of actionGroups
+func (h *Handler) actions() []*Action {
+	return []*Action{
+		public("register", h.register).in("Auth").idempotent().
+			returns(models.RegisterResponse{}).at("POST", "/api/v1/register", http.StatusCreated),
+		public("login", h.login).in("Auth").
+			returns(models.LoginResponse{}).at("POST", "/api/v1/auth/login"),
+		public("select_company", h.selectCompany).in("Auth").
+			returns(models.SelectCompanyResponse{}).at("POST", "/api/v1/auth/select-company"),
+		public("refresh_session", h.refreshSession).in("Auth").
+			returns(models.RefreshSessionResponse{}).at("POST", "/api/v1/auth/refresh"),
+		public("webauthn_login_begin", h.webauthnLoginBegin).in("Auth").
+			returns(models.WebAuthnOptionsResponse{}).at("POST", "/api/v1/auth/passkey/begin"),
+		public("webauthn_login_finish", h.webauthnLoginFinish).in("Auth").
+			returns(models.LoginResponse{}).at("POST", "/api/v1/auth/passkey/finish"),
+		public("request_password_reset", h.requestPasswordReset).in("Auth").
+			returns(models.MessageResponse{}).at("POST", "/api/v1/auth/password-reset"),
+		public("begin_password_reset", h.beginPasswordReset).in("Auth").
+			returns(models.BeginPasswordResetResponse{}).at("POST", "/api/v1/auth/password-reset/begin"),
+		public("complete_password_reset", h.completePasswordReset).in("Auth").
+			returns(models.CompletePasswordResetResponse{}).at("POST", "/api/v1/auth/password-reset/complete"),
+		protectedNoBody("logout", h.logout).in("Auth").allowExpiredPassword().allowMFAPending().
+			returns(models.MessageResponse{}).at("POST", "/api/v1/auth/logout"),
+		protectedNoBody("logout_all", h.logoutAll).in("Auth").allowExpiredPassword().allowMFAPending().
+			returns(models.MessageResponse{}).at("POST", "/api/v1/auth/logout-all"),
+
+		protectedNoBody("list_sessions", h.listSessions).in("Sessions").
+			returns([]models.SessionInfo{}).at("GET", "/api/v1/sessions"),
+		protected("revoke_session", h.revokeSession).in("Sessions").
+			returns(models.RevokeSessionResponse{}).at("DELETE", "/api/v1/sessions/{session_id}"),
+		protected("list_user_sessions", h.listUserSessions).in("Sessions").requires(permission.SessionsRead).
+			returns([]models.SessionInfo{}).at("GET", "/api/v1/users/{user_id}/sessions"),
+		protected("switch_company", h.switchCompany).in("Sessions").
+			returns(models.SelectCompanyResponse{}).at("POST", "/api/v1/sessions/switch"),
+
+		protectedNoBody("get_company", h.getCompany).in("Company").requires(permission.CompanyRead).
+			returns(models.Company{}).at("GET", "/api/v1/companies/me"),
+		protected("update_company", h.updateCompany).in("Company").requires(permission.CompanyWrite).
+			returns(models.MessageResponse{}).at("PATCH", "/api/v1/companies/me"),
+		protectedNoBody("delete_company", h.deleteCompany).in("Company").requires(permission.CompanyDelete).
+			returns(models.MessageResponse{}).at("DELETE", "/api/v1/companies/me"),
+
+		protectedNoBody("get_security_policy", h.getSecurityPolicy).in("Security policy").requires(permission.PolicyRead).
+			returns(models.SecurityPolicy{}).at("GET", "/api/v1/companies/me/security-policy"),
+		protected("update_security_policy", h.updateSecurityPolicy).in("Security policy").requires(permission.PolicyWrite).
+			returns(models.MessageResponse{}).at("PUT", "/api/v1/companies/me/security-policy"),
+
+		protectedNoBody("get_user", h.getUser).in("User").allowExpiredPassword().allowMFAPending().
+			returns(models.User{}).at("GET", "/api/v1/users/me"),
+		protected("update_user", h.updateUser).in("User").
+			returns(models.MessageResponse{}).at("PATCH", "/api/v1/users/me"),
+		protected("change_password", h.changePassword).in("User").allowExpiredPassword().
+			returns(models.ChangePasswordResponse{}).at("PUT", "/api/v1/users/me/password"),
+		protected("delete_user", h.deleteUser).in("User").requires(permission.UsersDelete).
+			returns(models.MessageResponse{}).at("DELETE", "/api/v1/users/{user_id}"),
+		protectedNoBody("list_users", h.listUsers).in("User").requires(permission.UsersRead).
+			returns([]models.UserCompanyAccess{}).at("GET", "/api/v1/users"),
+		protected("create_user", h.createUser).in("User").requires(permission.UsersCreate).idempotent().
+			returns(models.CreateUserResponse{}).at("POST", "/api/v1/users", http.StatusCreated),
+
+		protectedNoBody("enroll_totp", h.enrollTOTP).in("Two-factor").allowMFAPending().
+			returns(models.TOTPEnrollResponse{}).at("POST", "/api/v1/users/me/totp"),
+		protected("confirm_totp", h.confirmTOTP).in("Two-factor").allowMFAPending().
+			returns(models.ConfirmTOTPResponse{}).at("POST", "/api/v1/users/me/totp/confirm"),
+		protected("disable_totp", h.disableTOTP).in("Two-factor").
+			returns(models.MessageResponse{}).at("DELETE", "/api/v1/users/me/totp"),
+		protected("regenerate_backup_codes", h.regenerateBackupCodes).in("Two-factor").
+			returns(models.BackupCodesResponse{}).at("POST", "/api/v1/users/me/backup-codes"),
+
+		protectedNoBody("webauthn_register_begin", h.webauthnRegisterBegin).in("Passkeys").allowMFAPending().
+			returns(models.WebAuthnOptionsResponse{}).at("POST", "/api/v1/users/me/passkeys/begin"),
+		protected("webauthn_register_finish", h.webauthnRegisterFinish).in("Passkeys").allowMFAPending().
+			returns(models.WebAuthnCredential{}).at("POST", "/api/v1/users/me/passkeys", http.StatusCreated),
+		protectedNoBody("list_webauthn_credentials", h.listWebAuthnCredentials).in("Passkeys").
+			returns([]models.WebAuthnCredential{}).at("GET", "/api/v1/users/me/passkeys"),
+		protected("delete_webauthn_credential", h.deleteWebAuthnCredential).in("Passkeys").
+			returns(models.MessageResponse{}).at("DELETE", "/api/v1/users/me/passkeys/{id}"),
+
+		// Users may read their own history; the handler checks login_history:read for others
+		protected("get_login_history", h.getLoginHistory).in("Login history").
+			returns(models.LoginHistoryResponse{}).at("GET", "/api/v1/users/me/login-history").at("GET", "/api/v1/users/{user_id}/login-history"),
+		protected("unlock_user", h.unlockUser).in("Login history").requires(permission.UsersUnlock).
+			returns(models.MessageResponse{}).at("POST", "/api/v1/users/{user_id}/unlock"),
+
+		protectedNoBody("get_user_companies", h.getUserCompanies).in("Access").
+			returns([]models.UserCompanyAccess{}).at("GET", "/api/v1/users/me/companies"),
+		protected("update_user_access", h.updateUserAccess).in("Access").requires(permission.AccessManage).
+			returns(models.MessageResponse{}).at("PATCH", "/api/v1/access/{access_id}"),
+		protected("revoke_user_access", h.revokeUserAccess).in("Access").requires(permission.AccessManage).
+			returns(models.MessageResponse{}).at("DELETE", "/api/v1/access/{access_id}"),
+
+		protected("get_history", h.getHistory).in("History").requires(permission.HistoryRead).
+			returns(models.HistoryResponse{}).at("GET", "/api/v1/history"),
+
+		public("health", h.health).in("Meta").unlimited().
+			returns(models.HealthResponse{}).at("GET", "/api/v1/health"),
+		protectedNoBody("list_actions", h.listActions).in("Meta").
+			returns([]Action{}).at("GET", "/api/v1/actions"),
+	}
+}
+
+// registry indexes actions by name, panicking on a duplicate
+func registry(actions []*Action) map[string]*Action {
+	m := make(map[string]*Action, len(actions))
+	for _, a := range actions {
+		if _, dup := m[a.Name]; dup {
+			panic("api: duplicate action " + a.Name)
+		}
+		m[a.Name] = a
+	}
+	return m
+}
+
+// Actions returns every registered action sorted by group, then name
+func (h *Handler) Actions() []*Action {
+	list := make([]*Action, 0, len(h.registry))
+	for _, a := range h.registry {
+		list = append(list, a)
+	}
+	order := make(map[string]int, len(actionGroups))
+	for i, g := range actionGroups {
+		order[g] = i
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Group != list[j].Group {
+			return order[list[i].Group] < order[list[j].Group]
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// ==================== CONSTRUCTORS ====================
+
+// public declares an action that runs without a session; its body is decoded into T
+func public[T any](name string, fn func(http.ResponseWriter, *http.Request, *T)) *Action {
+	return &Action{
+		Name:        name,
+		Public:      true,
+		RateLimited: true,
+		Request:     requestType[T](),
+		run: func(w http.ResponseWriter, r *http.Request, _ *models.UserSession) {
+			if req, ok := decodeRequest[T](w, r); ok {
+				fn(w, r, req)
+			}
+		},
+	}
+}
+
+// protected declares an action that requires a session; its body is decoded into T
+func protected[T any](name string, fn func(http.ResponseWriter, *http.Request, *models.UserSession, *T)) *Action {
+	return &Action{
+		Name:    name,
+		Request: requestType[T](),
+		run: func(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
+			if req, ok := decodeRequest[T](w, r); ok {
+				fn(w, r, session, req)
+			}
+		},
+	}
+}
+
+// protectedNoBody declares an action that requires a session and ignores the body
+func protectedNoBody(name string, fn authedHandler) *Action {
+	return &Action{
+		Name: name,
+		run:  fn,
+	}
+}
+
+func (a *Action) in(group string) *Action {
+	a.Group = group
+	return a
+}
+
+// returns declares the type of Response.Data on success, e.g. models.User{}
+func (a *Action) returns(v interface{}) *Action {
+	a.Response = reflect.TypeOf(v)
+	return a
+}
+
+func (a *Action) requires(p string) *Action {
+	a.Permission = p
+	return a
+}
+
+func (a *Action) unlimited() *Action {
+	a.RateLimited = false
+	return a
+}
+
+func (a *Action) allowExpiredPassword() *Action {
+	a.AllowExpiredPassword = true
+	return a
+}
+
+func (a *Action) allowMFAPending() *Action {
+	a.AllowMFAPending = true
+	return a
+}
+
+func (a *Action) idempotent() *Action {
+	a.Idempotent = true
+	return a
+}
+
+// allows reports whether a caller holding perms may run the action
+func (a *Action) allows(perms permission.Set) bool {
+	return a.Permission == "" || perms.Has(a.Permission)
+}
+
+// requestType returns T, or nil for a struct without fields
+func requestType[T any]() reflect.Type {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Kind() == reflect.Struct && t.NumField() == 0 {
+		return nil
+	}
+	return t
+}
+
+// decodeRequest decodes the body into a new T and checks its validate
+// tags, writing a 400 on failure. Bodies of field-less types are not read.
+func decodeRequest[T any](w http.ResponseWriter, r *http.Request) (*T, bool) {
+	req := new(T)
+	if requestType[T]() == nil {
+		return req, true
+	}
+	if err := Decode(r, req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, "invalid request body")
+		return nil, false
+	}
+	if errs := validate(req); len(errs) > 0 {
+		ValidationFailed(w, errs...)
+		return nil, false
+	}
+	return req, true
+}
+
+// ==================== AUDIT ====================
+
+// statusRecorder remembers the status code a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// logAction writes one access log line per action call. Data changes are
+// audited separately in change_history by the stored procedures.
+func logAction(r *http.Request, action *Action, session *models.UserSession, status int, elapsed time.Duration) {
+	user, company := "-", "-"
+	if session != nil {
+		user, company = session.UserID, session.CompanyID
+	}
+	log.Printf("action=%s status=%d user=%s company=%s ip=%s duration=%s",
+		action.Name, status, user, company, clientIP(r), elapsed.Round(time.Millisecond))
+}
+
+// ==================== META ====================
+
+func (h *Handler) health(w http.ResponseWriter, r *http.Request, _ *struct{}) {
+	JSON(w, http.StatusOK, models.HealthResponse{Status: "ok"})
+}
+
+// listActions describes the actions the caller may run
+func (h *Handler) listActions(w http.ResponseWriter, r *http.Request, session *models.UserSession) {
+	perms := h.permissions(session)
+	list := []*Action{}
+	for _, a := range h.Actions() {
+		if a.Public || a.allows(perms) {
+			list = append(list, a)
+		}
+	}
+	JSON(w, http.StatusOK, list)
+}
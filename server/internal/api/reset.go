@@ -0,0 +1,275 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"lettersheets/internal/repository"
+	"lettersheets/models"
+
+	"github.com/google/uuid"
+)
+
+// proofMinutes is how long a recovery-key challenge can be answered
+const proofMinutes = 5
+
+// ==================== PASSWORD RESET ====================
+
+// requestPasswordReset emails a single-use reset link. The response is the
+// same whether or not the email exists, so it cannot be used to probe accounts.
+func (h *Handler) requestPasswordReset(w http.ResponseWriter, r *http.Request, req *models.RequestPasswordResetRequest) {
+	req.Email = strings.TrimSpace(req.Email)
+
+	accepted := models.MessageResponse{Message: "if the account exists, a reset link has been sent"}
+
+	user, err := h.userRepo.GetByEmail(r.Context(), req.Email)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to request password reset")
+		return
+	}
+	if user == nil || !user.IsActive {
+		JSON(w, http.StatusOK, accepted)
+		return
+	}
+
+	token, tokenHash, err := newToken()
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to create reset token")
+		return
+	}
+
+	expiresAt := time.Now().Add(time.Duration(h.cfg.Server.PasswordResetMinutes) * time.Minute)
+	created, err := h.tokenRepo.CreatePasswordReset(r.Context(), uuid.New().String(), user.ID, tokenHash,
+		expiresAt, h.cfg.Server.PasswordResetsPerHour, clientIP(r), r.UserAgent())
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to create reset token")
+		return
+	}
+	if !created {
+		// Rate limited; answer the same way so the limit does not reveal the account
+		JSON(w, http.StatusOK, accepted)
+		return
+	}
+
+	link := strings.TrimRight(h.cfg.Server.AppURL, "/") + "/forgot-password?token=" + token
+	body := "A password reset was requested for your LetterSheets account.\n\n" +
+		fmt.Sprintf("Open this link within %d minutes", h.cfg.Server.PasswordResetMinutes) +
+		" and use your recovery file to set a new password:\n\n" + link + "\n\n" +
+		"If you did not request this, you can ignore this email.\n"
+
+	go func(to string) {
+		if err := h.mailer.Send(to, "Reset your LetterSheets password", body); err != nil {
+			log.Printf("password reset email to %s failed: %v", to, err)
+		}
+	}(user.Email)
+
+	JSON(w, http.StatusOK, accepted)
+}
+
+// beginPasswordReset checks the emailed token and returns, for each company,
+// a random nonce encrypted to the user's public key. Only the holder of the
+// recovery file (which contains the private key) can answer it.
+func (h *Handler) beginPasswordReset(w http.ResponseWriter, r *http.Request, req *models.BeginPasswordResetRequest) {
+	userID, err := h.tokenRepo.Peek(r.Context(), hashToken(req.Token), models.TokenPurposePasswordReset)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to verify reset token")
+		return
+	}
+	if userID == "" {
+		ErrorCode(w, http.StatusUnauthorized, CodeInvalidToken, "invalid or expired reset token")
+		return
+	}
+
+	companies, err := h.accessRepo.GetUserCompanies(r.Context(), userID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to get companies")
+		return
+	}
+
+	expiresAt := time.Now().Add(proofMinutes * time.Minute)
+	challenges := make([]models.PasswordResetChallenge, 0, len(companies))
+	for _, c := range companies {
+		if c.KeyLostAt != nil {
+			continue
+		}
+		pub, err := x509.ParsePKIXPublicKey(c.PublicKey)
+		if err != nil {
+			continue
+		}
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+
+		nonce := make([]byte, 32)
+		if _, err := rand.Read(nonce); err != nil {
+			Error(w, http.StatusInternalServerError, "failed to create challenge")
+			return
+		}
+		challenge, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaPub, nonce, nil)
+		if err != nil {
+			continue
+		}
+
+		err = h.tokenRepo.Create(r.Context(), uuid.New().String(), userID,
+			proofHash(c.CompanyID, nonce), models.TokenPurposeResetProof, clientIP(r), expiresAt)
+		if err != nil {
+			Error(w, http.StatusInternalServerError, "failed to create challenge")
+			return
+		}
+
+		challenges = append(challenges, models.PasswordResetChallenge{
+			CompanyID:   c.CompanyID,
+			CompanyName: c.CompanyName,
+			KeyVersion:  c.KeyVersion,
+			Challenge:   challenge,
+		})
+	}
+
+	if len(challenges) == 0 {
+		Error(w, http.StatusConflict, "no recoverable company key for this account")
+		return
+	}
+
+	JSON(w, http.StatusOK, models.BeginPasswordResetResponse{
+		Challenges: challenges,
+		ExpiresAt:  expiresAt,
+	})
+}
+
+// completePasswordReset consumes the emailed token and a decrypted challenge,
+// then stores the new password with the recovered company keys re-wrapped
+// under it. Companies whose key was not recovered are marked as lost.
+func (h *Handler) completePasswordReset(w http.ResponseWriter, r *http.Request, req *models.CompletePasswordResetRequest) {
+	if _, ok := req.WrappedKeys[req.CompanyID]; !ok {
+		Error(w, http.StatusBadRequest, "wrapped_keys must include the proven company")
+		return
+	}
+	if _, err := x509.ParsePKIXPublicKey(req.PublicKey); err != nil {
+		Error(w, http.StatusBadRequest, "invalid public_key")
+		return
+	}
+
+	// Check the password first so a weak one does not spend the token
+	userID, err := h.tokenRepo.Peek(r.Context(), hashToken(req.Token), models.TokenPurposePasswordReset)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to verify reset token")
+		return
+	}
+	if userID == "" {
+		ErrorCode(w, http.StatusUnauthorized, CodeInvalidToken, "invalid or expired reset token")
+		return
+	}
+
+	policies, err := h.companyRepo.GetUserSecurityPolicies(r.Context(), userID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to load security policy")
+		return
+	}
+	user, err := h.userRepo.GetByID(r.Context(), userID)
+	if err != nil || user == nil {
+		Error(w, http.StatusInternalServerError, "failed to load user")
+		return
+	}
+	if reasons := h.checkPassword(req.Password, policies, user.Email, user.Username); len(reasons) > 0 {
+		rejectPassword(w, reasons)
+		return
+	}
+
+	// Single use: from here the token is spent even if the rest of the request fails
+	consumedBy, err := h.tokenRepo.Consume(r.Context(), hashToken(req.Token), models.TokenPurposePasswordReset)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to verify reset token")
+		return
+	}
+	if consumedBy == "" || consumedBy != userID {
+		ErrorCode(w, http.StatusUnauthorized, CodeInvalidToken, "invalid or expired reset token")
+		return
+	}
+
+	proofUserID, err := h.tokenRepo.Consume(r.Context(), proofHash(req.CompanyID, req.Proof), models.TokenPurposeResetProof)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to verify recovery key")
+		return
+	}
+	if proofUserID == "" || proofUserID != userID {
+		Error(w, http.StatusUnauthorized, "invalid recovery key proof")
+		return
+	}
+
+	companies, err := h.accessRepo.GetUserCompanies(r.Context(), userID)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to get companies")
+		return
+	}
+	keys, lost, msg := resetKeys(req.WrappedKeys, companies)
+	if msg != "" {
+		Error(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	passwordHash := h.hashPassword(req.Password, req.Salt)
+
+	err = h.userRepo.ResetPasswordWithKey(r.Context(), userID, passwordHash, req.Salt,
+		keys, req.PublicKey, clientIP(r), r.UserAgent())
+	if err != nil {
+		if repository.Signalled(err) {
+			Error(w, http.StatusConflict, "password reset failed, company access may have changed; start again")
+			return
+		}
+		writeFailed(w, err, "password reset failed")
+		return
+	}
+
+	JSON(w, http.StatusOK, models.CompletePasswordResetResponse{
+		Message:          "password reset successful",
+		LostKeyCompanies: lost,
+	})
+}
+
+// resetKeys checks the recovered keys against the user's active access rows.
+// It returns them as a list, the IDs of companies left without a key, and a
+// message describing the first problem ("" when valid).
+func resetKeys(wrapped map[string]models.WrappedKey, companies []models.UserCompanyAccess) ([]models.WrappedKey, []string, string) {
+	active := make(map[string]models.UserCompanyAccess, len(companies))
+	for _, c := range companies {
+		active[c.CompanyID] = c
+	}
+
+	keys := make([]models.WrappedKey, 0, len(wrapped))
+	for companyID, k := range wrapped {
+		c, ok := active[companyID]
+		if !ok {
+			return nil, nil, "no access to company " + companyID
+		}
+		if len(k.WrappedCompanyKey) == 0 {
+			return nil, nil, "missing wrapped_company_key for company " + companyID
+		}
+		if k.KeyVersion != c.KeyVersion {
+			return nil, nil, fmt.Sprintf("stale key_version for company %s: current is %d", companyID, c.KeyVersion)
+		}
+		k.CompanyID = companyID
+		keys = append(keys, k)
+	}
+
+	lost := []string{}
+	for _, c := range companies {
+		if _, ok := wrapped[c.CompanyID]; !ok {
+			lost = append(lost, c.CompanyID)
+		}
+	}
+	return keys, lost, ""
+}
+
+// proofHash binds a challenge nonce to the company it was issued for
+func proofHash(companyID string, nonce []byte) string {
+	return hashToken(companyID + ":" + base64.RawURLEncoding.EncodeToString(nonce))
+}
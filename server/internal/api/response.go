@@ -9,6 +9,12 @@ type Response struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
+
+	// Code identifies the error for clients; Error is for people and may change
+	Code Code `json:"code,omitempty"`
+
+	// Details lists the rejected fields of a VALIDATION_FAILED error
+	Details []FieldError `json:"details,omitempty"`
 }
 
 func JSON(w http.ResponseWriter, status int, data interface{}) {
@@ -20,13 +26,34 @@ func JSON(w http.ResponseWriter, status int, data interface{}) {
 	})
 }
 
+// Error responds with the generic code of status
 func Error(w http.ResponseWriter, status int, msg string) {
+	ErrorCode(w, status, statusCode(status), msg)
+}
+
+func ErrorCode(w http.ResponseWriter, status int, code Code, msg string) {
+	write(w, status, Response{Error: msg, Code: code})
+}
+
+// ErrorData is ErrorCode with details the client can act on, e.g. the
+// reasons a password was rejected
+func ErrorData(w http.ResponseWriter, status int, code Code, msg string, data interface{}) {
+	write(w, status, Response{Data: data, Error: msg, Code: code})
+}
+
+// ValidationFailed rejects a request naming every invalid field
+func ValidationFailed(w http.ResponseWriter, fields ...FieldError) {
+	msg := "validation failed"
+	if len(fields) > 0 {
+		msg = fields[0].Error()
+	}
+	write(w, http.StatusBadRequest, Response{Error: msg, Code: CodeValidationFailed, Details: fields})
+}
+
+func write(w http.ResponseWriter, status int, resp Response) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(Response{
-		Success: false,
-		Error:   msg,
-	})
+	json.NewEncoder(w).Encode(resp)
 }
 
 func Decode(r *http.Request, v interface{}) error {
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Route maps a REST endpoint under /api/v1 onto an action. Path wildcards
+// and, for GET, query parameters fill the request fields of the same JSON
+// name; other methods also read the JSON body.
+type Route struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Status int    `json:"status"` // success status
+}
+
+var wildcard = regexp.MustCompile(`\{(\w+)\}`)
+
+// at adds a REST route; status replaces 200 on success, e.g. 201 for creation
+func (a *Action) at(method, path string, status ...int) *Action {
+	route := Route{Method: method, Path: path, Status: http.StatusOK}
+	if len(status) > 0 {
+		route.Status = status[0]
+	}
+	a.Routes = append(a.Routes, route)
+	return a
+}
+
+// Routes registers the REST surface of every action on mux, and the
+// OpenAPI document describing it
+func (h *Handler) Routes(mux *http.ServeMux) {
+	for _, action := range h.Actions() {
+		for _, route := range action.Routes {
+			mux.HandleFunc(route.Method+" "+route.Path, h.rest(action, route))
+		}
+	}
+	mux.HandleFunc("GET /api/v1/openapi.json", h.serveOpenAPI)
+}
+
+// rest serves a route through the same dispatch as Execute
+func (h *Handler) rest(action *Action, route Route) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := restBody(r, action, route)
+		if err != nil {
+			var field *FieldError
+			if errors.As(err, &field) {
+				ValidationFailed(w, *field)
+				return
+			}
+			ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, "invalid request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+
+		if r.Method != http.MethodGet {
+			h.serve(&successWriter{ResponseWriter: w, status: route.Status}, r, action)
+			return
+		}
+
+		buf := newBufferedWriter()
+		h.serve(buf, r, action)
+		buf.flushWithETag(w, r)
+	}
+}
+
+// restBody assembles the JSON request of action from the body, the path
+// wildcards and, for GET, the query string
+func restBody(r *http.Request, action *Action, route Route) ([]byte, error) {
+	if action.Request == nil {
+		return nil, nil
+	}
+
+	fields := map[string]interface{}{}
+	if r.Method != http.MethodGet && r.Body != nil {
+		raw, err := io.ReadAll(io.LimitReader(r.Body, maxPeekBody))
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(raw)) > 0 {
+			if err := json.Unmarshal(raw, &fields); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if r.Method == http.MethodGet {
+		for name, values := range r.URL.Query() {
+			v, err := queryValue(action.Request, name, values[0])
+			if err != nil {
+				return nil, err
+			}
+			fields[name] = v
+		}
+	}
+
+	// Path wildcards win over the body, so a body cannot redirect the call
+	for _, m := range wildcard.FindAllStringSubmatch(route.Path, -1) {
+		fields[m[1]] = r.PathValue(m[1])
+	}
+	return json.Marshal(fields)
+}
+
+// queryValue converts a query parameter to the kind of the request field
+// with that JSON name; unknown parameters stay strings
+func queryValue(t reflect.Type, name, value string) (interface{}, error) {
+	f, ok := jsonField(t, name)
+	if !ok {
+		return value, nil
+	}
+	kind := f.Type.Kind()
+	if kind == reflect.Pointer {
+		kind = f.Type.Elem().Kind()
+	}
+	switch kind {
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, &FieldError{Field: name, Message: "must be an integer"}
+		}
+		return n, nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, &FieldError{Field: name, Message: "must be true or false"}
+		}
+		return b, nil
+	}
+	return value, nil
+}
+
+// jsonField finds the struct field encoded as name
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	if t.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == name || (tag == "" && f.Name == name) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// successWriter replaces a handler's 200 with the route's success status
+type successWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *successWriter) WriteHeader(status int) {
+	if status == http.StatusOK {
+		status = s.status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// bufferedWriter holds a GET response so it can be tagged before sending
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedWriter() *bufferedWriter {
+	return &bufferedWriter{header: http.Header{}, status: http.StatusOK}
+}
+
+func (b *bufferedWriter) Header() http.Header         { return b.header }
+func (b *bufferedWriter) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferedWriter) WriteHeader(status int)      { b.status = status }
+
+// flushWithETag sends the response with a validator. Responses are per
+// session, so caches must revalidate and may not share them; a matching
+// If-None-Match gets 304 without a body.
+func (b *bufferedWriter) flushWithETag(w http.ResponseWriter, r *http.Request) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	if b.status != http.StatusOK {
+		w.WriteHeader(b.status)
+		w.Write(b.body.Bytes())
+		return
+	}
+
+	sum := sha256.Sum256(b.body.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Vary", "Authorization")
+
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(b.body.Bytes())
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
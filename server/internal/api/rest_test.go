@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lettersheets/models"
+
+	"github.com/google/uuid"
+)
+
+// restFixture is an admin of company A with a member, and a member of
+// company B, served through the REST routes
+type restFixture struct {
+	mux           *http.ServeMux
+	fake          *fakeDB
+	adminToken    string
+	memberAAccess string
+	memberBAccess string
+}
+
+func newRESTFixture(t *testing.T) *restFixture {
+	t.Helper()
+	db, f := openFakeDB()
+	t.Cleanup(func() { db.Close() })
+
+	companyA, companyB := uuid.New().String(), uuid.New().String()
+	admin := uuid.New().String()
+	token, tokenHash, _ := newToken()
+	fx := &restFixture{
+		mux:           http.NewServeMux(),
+		fake:          f,
+		adminToken:    token,
+		memberAAccess: uuid.New().String(),
+		memberBAccess: uuid.New().String(),
+	}
+	f.member(uuid.New().String(), admin, companyA, models.RoleAdmin)
+	f.member(fx.memberAAccess, uuid.New().String(), companyA, models.RoleEmployee)
+	f.member(fx.memberBAccess, uuid.New().String(), companyB, models.RoleEmployee)
+	f.session(uuid.New().String(), tokenHash, admin, companyA)
+
+	h := newTestHandler(db)
+	h.cfg.RateLimit.IPPerMinute, h.cfg.RateLimit.IPBurst = 60, 10
+	h.cfg.RateLimit.EmailPerMinute, h.cfg.RateLimit.EmailBurst = 60, 10
+	h.cfg.RateLimit.AccountPerMinute, h.cfg.RateLimit.AccountBurst = 60, 10
+	h.cfg.Server.Argon2Time, h.cfg.Server.Argon2MemoryKiB, h.cfg.Server.Argon2Threads = 1, 64, 1
+	h.Routes(fx.mux)
+	return fx
+}
+
+func (fx *restFixture) do(method, target, token, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	fx.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRoutesRegister(t *testing.T) {
+	fx := newRESTFixture(t) // ServeMux panics on conflicting patterns
+
+	// Every route is served by its own pattern, not shadowed by another
+	db, _ := openFakeDB()
+	defer db.Close()
+	for _, action := range newTestHandler(db).Actions() {
+		for _, route := range action.Routes {
+			path := wildcard.ReplaceAllString(route.Path, "x")
+			_, pattern := fx.mux.Handler(httptest.NewRequest(route.Method, path, nil))
+			if pattern != route.Method+" "+route.Path {
+				t.Errorf("%s %s (%s) is served by %q", route.Method, path, action.Name, pattern)
+			}
+		}
+	}
+}
+
+func TestRESTPathOverridesBody(t *testing.T) {
+	fx := newRESTFixture(t)
+
+	rec := fx.do(http.MethodDelete, "/api/v1/access/"+fx.memberAAccess, fx.adminToken,
+		`{"access_id": "`+fx.memberBAccess+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if fx.fake.accessActive(fx.memberAAccess) {
+		t.Fatal("the access in the path was not revoked")
+	}
+	if !fx.fake.accessActive(fx.memberBAccess) {
+		t.Fatal("the access in the body was revoked")
+	}
+}
+
+func TestRESTQueryTypes(t *testing.T) {
+	fx := newRESTFixture(t)
+
+	if rec := fx.do(http.MethodGet, "/api/v1/history?limit=5&offset=0&table_name=users", fx.adminToken, ""); rec.Code != http.StatusOK {
+		t.Fatalf("typed query: status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := fx.do(http.MethodGet, "/api/v1/history?limit=five", fx.adminToken, "")
+	var resp Response
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusBadRequest || resp.Code != CodeValidationFailed || !strings.Contains(rec.Body.String(), `"limit"`) {
+		t.Fatalf("non-integer limit: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRESTSuccessStatus(t *testing.T) {
+	fx := newRESTFixture(t)
+
+	body := `{"company_name": "Acme", "email": "ann@example.com", "username": "ann",
+		"password": "correct horse battery staple", "wrapped_company_key": "a2V5", "public_key": "cHVi"}`
+	if rec := fx.do(http.MethodPost, "/api/v1/register", "", body); rec.Code != http.StatusCreated {
+		t.Fatalf("register: status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Failures keep their own status
+	if rec := fx.do(http.MethodPost, "/api/v1/register", "", `{"email": "ann@example.com"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid register: status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRESTETag(t *testing.T) {
+	fx := newRESTFixture(t)
+
+	first := fx.do(http.MethodGet, "/api/v1/users", fx.adminToken, "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("status = %d, ETag = %q", first.Code, etag)
+	}
+	if cc := first.Header().Get("Cache-Control"); cc != "private, no-cache" {
+		t.Fatalf("Cache-Control = %q", cc)
+	}
+
+	for _, match := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		rec := fx.do(http.MethodGet, "/api/v1/users", fx.adminToken, "", "If-None-Match", match)
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: status = %d, body %q", match, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := fx.do(http.MethodGet, "/api/v1/users", fx.adminToken, "", "If-None-Match", `"other"`)
+	if rec.Code != http.StatusOK || rec.Body.String() != first.Body.String() {
+		t.Fatalf("stale If-None-Match: status = %d", rec.Code)
+	}
+
+	// The list changes, so the tag does
+	fx.do(http.MethodDelete, "/api/v1/access/"+fx.memberAAccess, fx.adminToken, "")
+	rec = fx.do(http.MethodGet, "/api/v1/users", fx.adminToken, "", "If-None-Match", etag)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Fatalf("after a change: status = %d, ETag = %q", rec.Code, rec.Header().Get("ETag"))
+	}
+}
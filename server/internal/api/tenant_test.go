@@ -0,0 +1,331 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"lettersheets/internal/config"
+	"lettersheets/internal/mail"
+	"lettersheets/internal/ratelimit"
+	"lettersheets/internal/repository"
+	"lettersheets/models"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+)
+
+// tenantFixture is two companies: an admin of A, a member of A and a member
+// of B who has no access to A
+type tenantFixture struct {
+	adminToken string
+
+	memberA       string
+	memberAAccess string
+
+	companyB      string
+	memberB       string
+	memberBAccess string
+	sessionB      string
+
+	// accessActive and sessionActive read the stored state directly
+	accessActive  func(accessID string) bool
+	sessionActive func(sessionID string) bool
+}
+
+func newTestHandler(db *sql.DB) *Handler {
+	cfg := &config.AppConfig{}
+	cfg.Server.IdleTimeoutMinutes = 30
+	return NewHandler(
+		repository.NewRegistrationRepo(db),
+		repository.NewCompanyRepo(db),
+		repository.NewUserRepo(db),
+		repository.NewAccessRepo(db),
+		repository.NewSessionRepo(db),
+		repository.NewChangeHistoryRepo(db),
+		repository.NewAuthTokenRepo(db),
+		repository.NewWebAuthnRepo(db),
+		repository.NewIdempotencyRepo(db),
+		mail.New(mail.Config{}),
+		nil,
+		ratelimit.NewMemoryStore(),
+		cfg,
+	)
+}
+
+// execute posts body to an action as the holder of token
+func execute(h *Handler, token, action string, body interface{}) (int, Response) {
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/api/execute?action="+action, bytes.NewReader(raw))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.Execute(rec, req)
+
+	var resp Response
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec.Code, resp
+}
+
+// testCrossTenant checks that the admin of company A cannot read or change
+// anything of company B by passing B's IDs
+func testCrossTenant(t *testing.T, h *Handler, fx *tenantFixture) {
+	refused := []struct {
+		action string
+		body   interface{}
+	}{
+		{"update_user_access", map[string]interface{}{"access_id": fx.memberBAccess, "role": models.RoleEmployee}},
+		{"revoke_user_access", map[string]interface{}{"access_id": fx.memberBAccess}},
+		{"delete_user", map[string]interface{}{"user_id": fx.memberB}},
+		{"unlock_user", map[string]interface{}{"user_id": fx.memberB}},
+		{"get_login_history", map[string]interface{}{"user_id": fx.memberB}},
+	}
+	for _, c := range refused {
+		t.Run(c.action, func(t *testing.T) {
+			status, resp := execute(h, fx.adminToken, c.action, c.body)
+			if status != http.StatusNotFound {
+				t.Fatalf("status = %d (%s), want %d", status, resp.Error, http.StatusNotFound)
+			}
+		})
+	}
+
+	t.Run("list_user_sessions", func(t *testing.T) {
+		status, resp := execute(h, fx.adminToken, "list_user_sessions", map[string]string{"user_id": fx.memberB})
+		if status != http.StatusOK {
+			t.Fatalf("status = %d (%s)", status, resp.Error)
+		}
+		if list, _ := resp.Data.([]interface{}); len(list) != 0 {
+			t.Fatalf("listed %d sessions of company B", len(list))
+		}
+	})
+
+	t.Run("list_users", func(t *testing.T) {
+		status, resp := execute(h, fx.adminToken, "list_users", nil)
+		if status != http.StatusOK {
+			t.Fatalf("status = %d (%s)", status, resp.Error)
+		}
+		list, _ := resp.Data.([]interface{})
+		for _, u := range list {
+			if m, _ := u.(map[string]interface{}); m["user_id"] == fx.memberB {
+				t.Fatal("listed a member of company B")
+			}
+		}
+	})
+
+	if !fx.accessActive(fx.memberBAccess) {
+		t.Fatal("company B access was revoked")
+	}
+	if !fx.sessionActive(fx.sessionB) {
+		t.Fatal("company B session was ended")
+	}
+
+	// The same calls succeed inside company A, so the refusals above are not vacuous
+	t.Run("own company", func(t *testing.T) {
+		status, resp := execute(h, fx.adminToken, "update_user_access", map[string]interface{}{"access_id": fx.memberAAccess, "role": models.RoleHR})
+		if status != http.StatusOK {
+			t.Fatalf("update_user_access: status = %d (%s)", status, resp.Error)
+		}
+		status, resp = execute(h, fx.adminToken, "delete_user", map[string]string{"user_id": fx.memberA})
+		if status != http.StatusOK {
+			t.Fatalf("delete_user: status = %d (%s)", status, resp.Error)
+		}
+		if fx.accessActive(fx.memberAAccess) {
+			t.Fatal("delete_user left the company A access active")
+		}
+	})
+}
+
+func TestCrossTenantAccess(t *testing.T) {
+	db, f := openFakeDB()
+	defer db.Close()
+
+	companyA, companyB := uuid.New().String(), uuid.New().String()
+	adminA, memberA, memberB := uuid.New().String(), uuid.New().String(), uuid.New().String()
+	token, tokenHash, _ := newToken()
+
+	f.member(uuid.New().String(), adminA, companyA, models.RoleAdmin)
+	fx := &tenantFixture{
+		adminToken:    token,
+		memberA:       memberA,
+		memberAAccess: uuid.New().String(),
+		companyB:      companyB,
+		memberB:       memberB,
+		memberBAccess: uuid.New().String(),
+		sessionB:      uuid.New().String(),
+		accessActive:  f.accessActive,
+		sessionActive: f.sessionActive,
+	}
+	f.member(fx.memberAAccess, memberA, companyA, models.RoleEmployee)
+	f.member(fx.memberBAccess, memberB, companyB, models.RoleEmployee)
+	f.session(uuid.New().String(), tokenHash, adminA, companyA)
+	f.session(fx.sessionB, "other", memberB, companyB)
+
+	testCrossTenant(t, newTestHandler(db), fx)
+}
+
+// An admin lacks company:delete, so may not demote, restrict or remove a
+// superadmin, while the superadmin may change the admin
+func TestManageHigherMember(t *testing.T) {
+	db, f := openFakeDB()
+	defer db.Close()
+
+	company := uuid.New().String()
+	admin, owner := uuid.New().String(), uuid.New().String()
+	adminAccess, ownerAccess := uuid.New().String(), uuid.New().String()
+	adminToken, adminHash, _ := newToken()
+	ownerToken, ownerHash, _ := newToken()
+	f.member(adminAccess, admin, company, models.RoleAdmin)
+	f.member(ownerAccess, owner, company, models.RoleSuperAdmin)
+	f.session(uuid.New().String(), adminHash, admin, company)
+	f.session(uuid.New().String(), ownerHash, owner, company)
+	h := newTestHandler(db)
+
+	for _, c := range []struct {
+		action string
+		body   interface{}
+	}{
+		{"update_user_access", map[string]interface{}{"access_id": ownerAccess, "role": models.RoleEmployee}},
+		{"update_user_access", map[string]interface{}{"access_id": ownerAccess, "permissions": `{"deny": ["users:read"]}`}},
+		{"revoke_user_access", map[string]string{"access_id": ownerAccess}},
+		{"delete_user", map[string]string{"user_id": owner}},
+	} {
+		status, resp := execute(h, adminToken, c.action, c.body)
+		if status != http.StatusForbidden || resp.Code != CodePermissionDenied {
+			t.Errorf("%s %v: status = %d %s (%s), want 403", c.action, c.body, status, resp.Code, resp.Error)
+		}
+	}
+	if !f.accessActive(ownerAccess) {
+		t.Fatal("the superadmin's access was revoked")
+	}
+
+	status, resp := execute(h, ownerToken, "update_user_access", map[string]interface{}{"access_id": adminAccess, "role": models.RoleHR})
+	if status != http.StatusOK {
+		t.Fatalf("superadmin changing an admin: status = %d (%s)", status, resp.Error)
+	}
+}
+
+func TestScopeRequired(t *testing.T) {
+	ctx := context.Background()
+	meta := &models.RequestMeta{}
+	none := repository.Scope{}
+
+	// A nil *sql.DB would panic if the empty scope reached the database
+	access := repository.NewAccessRepo(nil)
+	users := repository.NewUserRepo(nil)
+	if err := access.Update(ctx, none, &models.UserCompanyAccess{ID: "x"}, meta); err == nil {
+		t.Error("AccessRepo.Update accepted an empty scope")
+	}
+	if err := access.Delete(ctx, none, "x", meta); err == nil {
+		t.Error("AccessRepo.Delete accepted an empty scope")
+	}
+	if _, err := access.GetCompanyUsers(ctx, none); err == nil {
+		t.Error("AccessRepo.GetCompanyUsers accepted an empty scope")
+	}
+	if err := users.RemoveFromCompany(ctx, none, "x", meta); err == nil {
+		t.Error("UserRepo.RemoveFromCompany accepted an empty scope")
+	}
+	if _, err := users.Unlock(ctx, none, "x", meta); err == nil {
+		t.Error("UserRepo.Unlock accepted an empty scope")
+	}
+}
+
+// TestCrossTenantAccessMySQL runs the same checks against the stored
+// procedures. LETTERSHEETS_TEST_DSN must point at a migrated database, e.g.
+// "root:pw@tcp(localhost:3306)/lettersheets?parseTime=true".
+func TestCrossTenantAccessMySQL(t *testing.T) {
+	dsn := os.Getenv("LETTERSHEETS_TEST_DSN")
+	if dsn == "" {
+		t.Skip("LETTERSHEETS_TEST_DSN not set")
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	reg := repository.NewRegistrationRepo(db)
+	users := repository.NewUserRepo(db)
+	access := repository.NewAccessRepo(db)
+	sessions := repository.NewSessionRepo(db)
+
+	register := func() (companyID, userID string) {
+		companyID, userID = uuid.New().String(), uuid.New().String()
+		err := reg.Register(ctx, &repository.RegisterParams{
+			CompanyID:         companyID,
+			CompanyName:       "Tenant " + companyID[:8],
+			UserID:            userID,
+			Email:             userID + "@example.com",
+			Username:          userID[:8],
+			PasswordHash:      "x",
+			Salt:              "x",
+			AccessID:          uuid.New().String(),
+			WrappedCompanyKey: []byte("key"),
+			PublicKey:         []byte("pub"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return companyID, userID
+	}
+	addMember := func(companyID, ownerID string) (userID, accessID string) {
+		userID, accessID = uuid.New().String(), uuid.New().String()
+		meta := &models.RequestMeta{UserID: ownerID, CompanyID: companyID}
+		err := users.Create(ctx, &models.User{ID: userID, Email: userID + "@example.com", Username: userID[:8], PasswordHash: "x", Salt: "x"}, meta)
+		if err == nil {
+			err = access.Create(ctx, repository.InCompany(companyID), &models.UserCompanyAccess{
+				ID: accessID, UserID: userID, WrappedCompanyKey: []byte("key"), PublicKey: []byte("pub"), Role: models.RoleEmployee,
+			}, meta)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return userID, accessID
+	}
+	openSession := func(userID, companyID string) (sessionID, token string) {
+		token, tokenHash, _ := newToken()
+		sessionID = uuid.New().String()
+		err := sessions.Create(ctx, &repository.SessionParams{
+			ID: sessionID, TokenHash: tokenHash, UserID: userID, CompanyID: companyID,
+			ExpiresAt: time.Now().Add(time.Hour), AbsoluteExpiresAt: time.Now().Add(8 * time.Hour),
+			RefreshID: uuid.New().String(), RefreshTokenHash: uuid.New().String(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sessionID, token
+	}
+
+	companyA, adminA := register()
+	companyB, ownerB := register()
+	memberA, memberAAccess := addMember(companyA, adminA)
+	memberB, memberBAccess := addMember(companyB, ownerB)
+	_, token := openSession(adminA, companyA)
+	sessionB, _ := openSession(memberB, companyB)
+
+	fx := &tenantFixture{
+		adminToken:    token,
+		memberA:       memberA,
+		memberAAccess: memberAAccess,
+		companyB:      companyB,
+		memberB:       memberB,
+		memberBAccess: memberBAccess,
+		sessionB:      sessionB,
+		accessActive: func(id string) bool {
+			var active bool
+			_ = db.QueryRow("SELECT is_active FROM user_company_access WHERE id = ?", id).Scan(&active)
+			return active
+		},
+		sessionActive: func(id string) bool {
+			var active bool
+			_ = db.QueryRow("SELECT is_active FROM user_sessions WHERE id = ?", id).Scan(&active)
+			return active
+		},
+	}
+	testCrossTenant(t, newTestHandler(db), fx)
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"lettersheets/models"
+
+	"github.com/google/uuid"
+)
+
+// Request fields declare their constraints in a validate tag, checked by
+// decodeRequest before the handler runs:
+//
+//	required  set: a non-blank string, a non-nil pointer, a non-empty slice or map
+//	email     an address of the form name@domain
+//	uuid      a UUID in its canonical 36 character form
+//	max=N     at most N characters, the size of the column it is stored in
+//	enum=X    one of the values of the enum X, see enums
+//
+// Rules other than required accept an unset field, apply to the value of a
+// pointer and to each element of a []string. Nested structs, slices of
+// structs and maps of structs are checked too.
+
+// enums are the value sets enum=X can name
+var enums = map[string][]string{
+	"role": models.Roles,
+}
+
+type rule struct {
+	name string
+	arg  string
+	max  int
+}
+
+// validate checks the tags of the request req points to and returns every
+// rejected field under its JSON path, one error per field
+func validate(req interface{}) []FieldError {
+	v := reflect.ValueOf(req)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	var errs []FieldError
+	validateValue(v, "", &errs)
+	return errs
+}
+
+func validateValue(v reflect.Value, path string, errs *[]FieldError) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			validateValue(v.Elem(), path, errs)
+		}
+	case reflect.Slice:
+		if indirect(v.Type().Elem()).Kind() == reflect.Struct {
+			for i := 0; i < v.Len(); i++ {
+				validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case reflect.Map:
+		if indirect(v.Type().Elem()).Kind() == reflect.Struct && v.Type().Key().Kind() == reflect.String {
+			iter := v.MapRange()
+			for iter.Next() {
+				validateValue(iter.Value(), path+"["+iter.Key().String()+"]", errs)
+			}
+		}
+	case reflect.Struct:
+		for _, f := range fields(v.Type()) {
+			name := f.name
+			if path != "" {
+				name = path + "." + f.name
+			}
+			fv, err := v.FieldByIndexErr(f.Index)
+			if err != nil {
+				continue // nil embedded pointer
+			}
+			if at, msg := checkField(fv, rulesOf(f.StructField)); msg != "" {
+				*errs = append(*errs, FieldError{Field: name + at, Message: msg})
+				continue
+			}
+			validateValue(fv, name, errs)
+		}
+	}
+}
+
+// checkField returns why v breaks its first failing rule, or "". at is
+// the index of the offending element of a []string, e.g. "[1]".
+func checkField(v reflect.Value, rules []rule) (at, msg string) {
+	for _, r := range rules {
+		if r.name == "required" {
+			if !present(v) {
+				return "", "is required"
+			}
+			continue
+		}
+		if at, msg := checkValue(v, r); msg != "" {
+			return at, msg
+		}
+	}
+	return "", ""
+}
+
+// checkValue applies a rule other than required to a string, *string or
+// []string, skipping unset values
+func checkValue(v reflect.Value, r rule) (at, msg string) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return checkValue(v.Elem(), r)
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			break
+		}
+		for i := 0; i < v.Len(); i++ {
+			if msg := checkString(v.Index(i).String(), r); msg != "" {
+				return fmt.Sprintf("[%d]", i), msg
+			}
+		}
+	case reflect.String:
+		return "", checkString(v.String(), r)
+	}
+	return "", ""
+}
+
+func checkString(s string, r rule) string {
+	if s == "" {
+		return ""
+	}
+	switch r.name {
+	case "email":
+		if !isEmail(s) {
+			return "must be a valid email address"
+		}
+	case "uuid":
+		if _, err := uuid.Parse(s); err != nil || len(s) != 36 {
+			return "must be a UUID"
+		}
+	case "max":
+		if utf8.RuneCountInString(s) > r.max {
+			return fmt.Sprintf("must be at most %d characters", r.max)
+		}
+	case "enum":
+		for _, allowed := range enums[r.arg] {
+			if s == allowed {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(enums[r.arg], ", ")
+	}
+	return ""
+}
+
+func present(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(v.String()) != ""
+	case reflect.Pointer, reflect.Interface:
+		return !v.IsNil()
+	case reflect.Slice, reflect.Map:
+		return v.Len() > 0
+	}
+	return !v.IsZero()
+}
+
+// isEmail accepts a bare address; mail.ParseAddress alone would also take
+// "Name <name@domain>"
+func isEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s {
+		return false
+	}
+	_, domain, _ := strings.Cut(s, "@")
+	return domain != "" && !strings.HasPrefix(domain, "[")
+}
+
+var ruleCache sync.Map // validate tag -> []rule
+
+// rulesOf parses the validate tag of f. A malformed tag is a programming
+// error and panics the first time the request type is checked.
+func rulesOf(f reflect.StructField) []rule {
+	tag := f.Tag.Get("validate")
+	if tag == "" {
+		return nil
+	}
+	if rules, ok := ruleCache.Load(tag); ok {
+		return rules.([]rule)
+	}
+
+	var rules []rule
+	for _, part := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		r := rule{name: name, arg: arg}
+		switch name {
+		case "required", "email", "uuid":
+		case "max":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n <= 0 {
+				panic(fmt.Sprintf("validate: bad max in tag %q of field %s", tag, f.Name))
+			}
+			r.max = n
+		case "enum":
+			if _, ok := enums[arg]; !ok {
+				panic(fmt.Sprintf("validate: unknown enum in tag %q of field %s", tag, f.Name))
+			}
+		default:
+			panic(fmt.Sprintf("validate: unknown rule %q of field %s", name, f.Name))
+		}
+		rules = append(rules, r)
+	}
+	ruleCache.Store(tag, rules)
+	return rules
+}
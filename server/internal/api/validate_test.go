@@ -0,0 +1,114 @@
+package api
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"lettersheets/models"
+)
+
+func TestValidate(t *testing.T) {
+	role := "owner"
+	cases := []struct {
+		name string
+		req  interface{}
+		want map[string]string
+	}{
+		{
+			name: "valid",
+			req: &models.CreateUserRequest{
+				Email: "ann@example.com", Username: "ann", Password: "pw",
+				WrappedCompanyKey: []byte{1}, PublicKey: []byte{2},
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "every field at once",
+			req: &models.CreateUserRequest{
+				Email: "Ann <ann@example.com>", Username: strings.Repeat("é", 101), Password: " ",
+				Role: "owner", PublicKey: []byte{2},
+			},
+			want: map[string]string{
+				"email":               "must be a valid email address",
+				"username":            "must be at most 100 characters",
+				"password":            "is required",
+				"role":                "must be one of superadmin, admin, hr, payroll, manager, employee",
+				"wrapped_company_key": "is required",
+			},
+		},
+		{
+			name: "pointer",
+			req:  &models.UpdateUserAccessRequest{AccessID: "7b0b2c4e-5a1d-4c8e-9f6a-2d3e4f5a6b7c", Role: &role},
+			want: map[string]string{"role": "must be one of superadmin, admin, hr, payroll, manager, employee"},
+		},
+		{
+			name: "uuid",
+			req:  &models.UserIDRequest{UserID: "{7b0b2c4e-5a1d-4c8e-9f6a-2d3e4f5a6b7c}"},
+			want: map[string]string{"user_id": "must be a UUID"},
+		},
+		{
+			name: "nested",
+			req: &models.ChangePasswordRequest{
+				CurrentPassword: "a", NewPassword: "b", Salt: "c",
+				WrappedKeys: []models.WrappedKey{{CompanyID: "7b0b2c4e-5a1d-4c8e-9f6a-2d3e4f5a6b7c", WrappedCompanyKey: []byte{1}}, {CompanyID: "x"}},
+			},
+			want: map[string]string{
+				"wrapped_keys[1].company_id":          "must be a UUID",
+				"wrapped_keys[1].wrapped_company_key": "is required",
+			},
+		},
+		{
+			name: "list items",
+			req:  &models.SecurityPolicy{MFARequiredRoles: []string{models.RoleAdmin, "root"}},
+			want: map[string]string{"mfa_required_roles[1]": "must be one of superadmin, admin, hr, payroll, manager, employee"},
+		},
+	}
+	for _, c := range cases {
+		got := map[string]string{}
+		for _, e := range validate(c.req) {
+			got[e.Field] = e.Message
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// TestValidateTags parses the validate tag of every request field, so a
+// typo fails here rather than on the first request
+func TestValidateTags(t *testing.T) {
+	seen := map[reflect.Type]bool{}
+	var walk func(reflect.Type)
+	walk = func(typ reflect.Type) {
+		typ = indirect(typ)
+		switch typ.Kind() {
+		case reflect.Slice, reflect.Map:
+			walk(typ.Elem())
+		case reflect.Struct:
+			if seen[typ] {
+				return
+			}
+			seen[typ] = true
+			for _, f := range fields(typ) {
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							t.Errorf("%s.%s: %v", typ.Name(), f.Name, r)
+						}
+					}()
+					rulesOf(f.StructField)
+				}()
+				walk(f.Type)
+			}
+		}
+	}
+
+	h := &Handler{}
+	h.registry = registry(h.actions())
+	for _, action := range h.Actions() {
+		if action.Request != nil {
+			walk(action.Request)
+		}
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"time"
 
 	"lettersheets/internal/database"
+	"lettersheets/internal/mail"
+	"lettersheets/internal/password"
+	"lettersheets/internal/ratelimit"
 )
 
 var path string = "config.json"
@@ -42,21 +45,151 @@ func Get() (*AppConfig, error) {
 	if cfg.Server.LockoutMinutes == 0 {
 		cfg.Server.LockoutMinutes = 30
 	}
+	if cfg.Server.IdleTimeoutMinutes == 0 {
+		cfg.Server.IdleTimeoutMinutes = 30
+	}
+	if cfg.Server.AccessTokenMinutes == 0 {
+		cfg.Server.AccessTokenMinutes = 15
+	}
+	if cfg.Server.PreAuthMinutes == 0 {
+		cfg.Server.PreAuthMinutes = 5
+	}
+	if cfg.Server.TOTPIssuer == "" {
+		cfg.Server.TOTPIssuer = "LetterSheets"
+	}
+	if cfg.Server.TOTPSkewSteps == 0 {
+		cfg.Server.TOTPSkewSteps = 1
+	}
+	if cfg.Server.WebAuthnRPID == "" {
+		cfg.Server.WebAuthnRPID = "localhost"
+	}
+	if cfg.Server.WebAuthnRPName == "" {
+		cfg.Server.WebAuthnRPName = "LetterSheets"
+	}
+	if len(cfg.Server.WebAuthnOrigins) == 0 {
+		cfg.Server.WebAuthnOrigins = []string{"http://localhost:5173"}
+	}
+	if cfg.Server.WebAuthnTimeoutSeconds == 0 {
+		cfg.Server.WebAuthnTimeoutSeconds = 300
+	}
+	if cfg.Server.AppURL == "" {
+		cfg.Server.AppURL = "http://localhost:5173"
+	}
+	if cfg.Server.PasswordResetMinutes == 0 {
+		cfg.Server.PasswordResetMinutes = 30
+	}
+	if cfg.Server.PasswordResetsPerHour == 0 {
+		cfg.Server.PasswordResetsPerHour = 3
+	}
+	if cfg.Server.Argon2Time == 0 {
+		cfg.Server.Argon2Time = 1
+	}
+	if cfg.Server.Argon2MemoryKiB == 0 {
+		cfg.Server.Argon2MemoryKiB = 64 * 1024
+	}
+	if cfg.Server.Argon2Threads == 0 {
+		cfg.Server.Argon2Threads = 4
+	}
+	// 0 is a valid setting (accept any score), so only a missing value defaults
+	if cfg.Server.MinPasswordScore == nil {
+		score := 2
+		cfg.Server.MinPasswordScore = &score
+	}
+	if cfg.RateLimit.Store == "" {
+		cfg.RateLimit.Store = "memory"
+	}
+	if cfg.RateLimit.IPPerMinute == 0 {
+		cfg.RateLimit.IPPerMinute = 30
+	}
+	if cfg.RateLimit.IPBurst == 0 {
+		cfg.RateLimit.IPBurst = 20
+	}
+	if cfg.RateLimit.EmailPerMinute == 0 {
+		cfg.RateLimit.EmailPerMinute = 5
+	}
+	if cfg.RateLimit.EmailBurst == 0 {
+		cfg.RateLimit.EmailBurst = 10
+	}
+	if cfg.RateLimit.AccountPerMinute == 0 {
+		cfg.RateLimit.AccountPerMinute = 20
+	}
+	if cfg.RateLimit.AccountBurst == 0 {
+		cfg.RateLimit.AccountBurst = 50
+	}
+	if cfg.Mail.Port == 0 {
+		cfg.Mail.Port = 587
+	}
 
 	return &cfg, nil
 }
 
 type AppConfig struct {
-	Server   ServerConfig   `json:"server"`
-	Database DatabaseConfig `json:"database"`
+	Server    ServerConfig    `json:"server"`
+	Database  DatabaseConfig  `json:"database"`
+	Mail      MailConfig      `json:"mail"`
+	RateLimit RateLimitConfig `json:"rate_limit"`
 }
 
 type ServerConfig struct {
-	Host             string `json:"host"`
-	Port             int    `json:"port"`
-	SessionHours     int    `json:"session_hours"`
-	MaxLoginAttempts int    `json:"max_login_attempts"`
-	LockoutMinutes   int    `json:"lockout_minutes"`
+	Host string `json:"host"`
+	Port int    `json:"port"`
+
+	// Session lifetime: SessionHours is the absolute cap from select_company,
+	// IdleTimeoutMinutes ends sessions without activity, and access tokens
+	// expire after AccessTokenMinutes unless rotated with refresh_session
+	SessionHours       int `json:"session_hours"`
+	IdleTimeoutMinutes int `json:"idle_timeout_minutes"`
+	AccessTokenMinutes int `json:"access_token_minutes"`
+
+	MaxLoginAttempts int `json:"max_login_attempts"`
+	LockoutMinutes   int `json:"lockout_minutes"`
+	PreAuthMinutes   int `json:"pre_auth_minutes"`
+
+	// SecretKey is a hex-encoded 32-byte key for server-held secrets such as TOTP seeds
+	SecretKey     string `json:"secret_key"`
+	TOTPIssuer    string `json:"totp_issuer"`
+	TOTPSkewSteps int    `json:"totp_skew_steps"`
+
+	// WebAuthn relying party: RPID is the domain passkeys are bound to and
+	// WebAuthnOrigins lists the exact origins the frontend is served from
+	WebAuthnRPID           string   `json:"webauthn_rp_id"`
+	WebAuthnRPName         string   `json:"webauthn_rp_name"`
+	WebAuthnOrigins        []string `json:"webauthn_origins"`
+	WebAuthnTimeoutSeconds int      `json:"webauthn_timeout_seconds"`
+
+	// AppURL is the frontend base URL used in emailed links
+	AppURL                string `json:"app_url"`
+	PasswordResetMinutes  int    `json:"password_reset_minutes"`
+	PasswordResetsPerHour int    `json:"password_resets_per_hour"`
+
+	// Argon2id cost for password hashes. Raising any of these rehashes a
+	// user's password at their next successful login.
+	Argon2Time      uint32 `json:"argon2_time"`
+	Argon2MemoryKiB uint32 `json:"argon2_memory_kib"`
+	Argon2Threads   uint8  `json:"argon2_threads"`
+
+	// MinPasswordScore is the lowest accepted strength estimate (0-4),
+	// 2 when unset. BreachedPasswordsDir holds the offline breached-password
+	// range files; empty disables the check.
+	MinPasswordScore     *int   `json:"min_password_score"`
+	BreachedPasswordsDir string `json:"breached_passwords_dir"`
+}
+
+// PasswordScore returns the lowest accepted strength estimate
+func (c *ServerConfig) PasswordScore() int {
+	if c.MinPasswordScore == nil {
+		return 0
+	}
+	return *c.MinPasswordScore
+}
+
+func (c *ServerConfig) PasswordParams() password.Params {
+	return password.Params{
+		Time:      c.Argon2Time,
+		MemoryKiB: c.Argon2MemoryKiB,
+		Threads:   c.Argon2Threads,
+		KeyLen:    32,
+	}
 }
 
 func (s *ServerConfig) Addr() string {
@@ -86,3 +219,48 @@ func (c *DatabaseConfig) ToDBConfig() database.Config {
 		MaxLife:  time.Duration(c.MaxLifeMinutes) * time.Minute,
 	}
 }
+
+// MailConfig configures outgoing email; without a host, messages are only logged
+type MailConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+}
+
+func (c *MailConfig) ToMailConfig() mail.Config {
+	return mail.Config{
+		Host:     c.Host,
+		Port:     c.Port,
+		Username: c.Username,
+		Password: c.Password,
+		From:     c.From,
+	}
+}
+
+// RateLimitConfig throttles public actions per client IP, per target email
+// from each IP and, with the larger Account limit, per target email from all
+// IPs. Store is "memory" for a single instance or "mysql" to share the
+// buckets between instances.
+type RateLimitConfig struct {
+	Store            string `json:"store"`
+	IPPerMinute      int    `json:"ip_per_minute"`
+	IPBurst          int    `json:"ip_burst"`
+	EmailPerMinute   int    `json:"email_per_minute"`
+	EmailBurst       int    `json:"email_burst"`
+	AccountPerMinute int    `json:"account_per_minute"`
+	AccountBurst     int    `json:"account_burst"`
+}
+
+func (c *RateLimitConfig) IPLimit() ratelimit.Limit {
+	return ratelimit.Limit{PerMinute: c.IPPerMinute, Burst: c.IPBurst}
+}
+
+func (c *RateLimitConfig) EmailLimit() ratelimit.Limit {
+	return ratelimit.Limit{PerMinute: c.EmailPerMinute, Burst: c.EmailBurst}
+}
+
+func (c *RateLimitConfig) AccountLimit() ratelimit.Limit {
+	return ratelimit.Limit{PerMinute: c.AccountPerMinute, Burst: c.AccountBurst}
+}
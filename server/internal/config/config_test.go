@@ -0,0 +1,35 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMinPasswordScore(t *testing.T) {
+	defer SetPath(path)
+
+	cases := []struct {
+		json string
+		want int
+	}{
+		{`{"server": {}}`, 2},
+		{`{"server": {"min_password_score": 0}}`, 0},
+		{`{"server": {"min_password_score": 3}}`, 3},
+	}
+	for _, c := range cases {
+		file := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(file, []byte(c.json), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		SetPath(file)
+
+		cfg, err := Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cfg.Server.PasswordScore(); got != c.want {
+			t.Errorf("%s: score = %d, want %d", c.json, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Package mail sends transactional email such as password reset links.
+package mail
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+)
+
+// Sender delivers a plain-text message to one recipient
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+type Config struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// New returns an SMTP sender, or a sender that only logs messages when no
+// SMTP host is configured (development)
+func New(cfg Config) Sender {
+	if cfg.Host == "" {
+		return logSender{}
+	}
+	return &smtpSender{cfg: cfg}
+}
+
+type smtpSender struct {
+	cfg Config
+}
+
+func (s *smtpSender) Send(to, subject, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("mail: invalid header value")
+	}
+
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+	}
+
+	msg := "From: " + s.cfg.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body
+
+	return smtp.SendMail(addr, auth, s.cfg.From, []string{to}, []byte(msg))
+}
+
+type logSender struct{}
+
+func (logSender) Send(to, subject, body string) error {
+	log.Printf("mail (not sent, no smtp host configured) to=%s subject=%q\n%s", to, subject, body)
+	return nil
+}
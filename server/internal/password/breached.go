@@ -0,0 +1,85 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachList looks passwords up in a local copy of a breached-password
+// corpus split by k-anonymity prefix, in the format of the Pwned Passwords
+// range API: one file per 5-character SHA-1 prefix (e.g. "5BAA6" or
+// "5BAA6.txt"), each line "<35-character suffix>:<count>". Nothing is sent
+// over the network. A nil *BreachList treats every password as unbreached.
+type BreachList struct {
+	dir string
+}
+
+// OpenBreachList returns a list backed by dir, or nil when dir is empty
+func OpenBreachList(dir string) (*BreachList, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("breached password list: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("breached password list: %s is not a directory", dir)
+	}
+	return &BreachList{dir: dir}, nil
+}
+
+// Count returns how many times pw appears in the corpus
+func (b *BreachList) Count(pw string) (int, error) {
+	if b == nil {
+		return 0, nil
+	}
+
+	sum := sha1.Sum([]byte(pw))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := b.open(prefix)
+	if err != nil {
+		return 0, err
+	}
+	if f == nil {
+		return 0, nil
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		s, count, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(s, suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 1, nil
+		}
+		return n, nil
+	}
+	return 0, scanner.Err()
+}
+
+// open returns the range file for prefix, or nil when the corpus has none
+func (b *BreachList) open(prefix string) (*os.File, error) {
+	for _, name := range []string{prefix, prefix + ".txt", strings.ToLower(prefix), strings.ToLower(prefix) + ".txt"} {
+		f, err := os.Open(filepath.Join(b.dir, name))
+		if err == nil {
+			return f, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,82 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeRange stores a range file holding pw with count, as the Pwned
+// Passwords range API serves it
+func writeRange(t *testing.T, dir, name, pw, count string) {
+	t.Helper()
+	sum := sha1.Sum([]byte(pw))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	lines := "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n" + hash[5:] + ":" + count + "\r\n"
+	if err := os.WriteFile(filepath.Join(dir, strings.ReplaceAll(name, "PREFIX", hash[:5])), []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBreachList(t *testing.T) {
+	dir := t.TempDir()
+	writeRange(t, dir, "PREFIX", "password", "3730471")
+	writeRange(t, dir, "PREFIX.txt", "hunter2", "17043")
+	writeRange(t, dir, "PREFIX", "no count", "")
+
+	list, err := OpenBreachList(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		pw   string
+		want int
+	}{
+		{"password", 3730471},
+		{"hunter2", 17043},
+		{"no count", 1},
+		{"Password", 0},                     // no range file for its prefix
+		{"correct horse battery staple", 0}, // likewise
+	}
+	for _, c := range cases {
+		got, err := list.Count(c.pw)
+		if err != nil {
+			t.Fatalf("%q: %v", c.pw, err)
+		}
+		if got != c.want {
+			t.Errorf("%q: count = %d, want %d", c.pw, got, c.want)
+		}
+	}
+
+	// A miss inside an existing range file
+	sum := sha1.Sum([]byte("password"))
+	other := strings.ToUpper(hex.EncodeToString(sum[:]))[:5] + strings.Repeat("0", 35)
+	if err := os.WriteFile(filepath.Join(dir, other[:5]), []byte(other[5:]+":9\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := list.Count("password"); err != nil || got != 0 {
+		t.Errorf("miss in range file: count = %d, %v", got, err)
+	}
+}
+
+func TestOpenBreachList(t *testing.T) {
+	list, err := OpenBreachList("")
+	if err != nil || list != nil {
+		t.Fatalf("empty dir: %v, %v", list, err)
+	}
+	if n, err := list.Count("password"); n != 0 || err != nil {
+		t.Fatalf("nil list: %d, %v", n, err)
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, nil, 0o644)
+	if _, err := OpenBreachList(file); err == nil {
+		t.Error("accepted a file as the corpus directory")
+	}
+	if _, err := OpenBreachList(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("accepted a missing directory")
+	}
+}
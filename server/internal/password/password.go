@@ -0,0 +1,103 @@
+// Package password checks login passwords (strength estimate, breached
+// corpus) and hashes them with Argon2id in PHC string format, so the
+// parameters travel with each hash:
+//
+//	$argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>
+//
+// Salt and hash are unpadded standard base64.
+package password
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Params are the Argon2id cost parameters
+type Params struct {
+	Time      uint32
+	MemoryKiB uint32
+	Threads   uint8
+	KeyLen    uint32
+}
+
+// legacy are the parameters of the bare hex hashes stored before PHC encoding
+var legacy = Params{Time: 1, MemoryKiB: 64 * 1024, Threads: 4, KeyLen: 32}
+
+var b64 = base64.RawStdEncoding
+
+var errFormat = errors.New("password: unrecognized hash format")
+
+// Hash returns the PHC-encoded Argon2id hash of password
+func Hash(password, salt string, p Params) string {
+	key := argon2.IDKey([]byte(password), []byte(salt), p.Time, p.MemoryKiB, p.Threads, p.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.MemoryKiB, p.Time, p.Threads,
+		b64.EncodeToString([]byte(salt)), b64.EncodeToString(key))
+}
+
+// Verify checks password against an encoded hash in constant time.
+// rehash reports that the password matched but was hashed with parameters
+// weaker than current (or in the legacy format) and should be hashed again.
+func Verify(password, salt, encoded string, current Params) (ok, rehash bool) {
+	p, hashSalt, want, err := decode(encoded, salt)
+	if err != nil {
+		return false, false
+	}
+
+	got := argon2.IDKey([]byte(password), hashSalt, p.Time, p.MemoryKiB, p.Threads, uint32(len(want)))
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return false, false
+	}
+	return true, weaker(p, current) || !strings.HasPrefix(encoded, "$")
+}
+
+func weaker(p, current Params) bool {
+	return p.Time < current.Time || p.MemoryKiB < current.MemoryKiB ||
+		p.Threads < current.Threads || p.KeyLen < current.KeyLen
+}
+
+// decode parses a PHC string, or a legacy bare hex hash salted with salt
+func decode(encoded, salt string) (Params, []byte, []byte, error) {
+	if !strings.HasPrefix(encoded, "$") {
+		want, err := hex.DecodeString(encoded)
+		if err != nil || len(want) != int(legacy.KeyLen) {
+			return Params{}, nil, nil, errFormat
+		}
+		return legacy, []byte(salt), want, nil
+	}
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Params{}, nil, nil, errFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Params{}, nil, nil, errFormat
+	}
+
+	var p Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.MemoryKiB, &p.Time, &p.Threads); err != nil {
+		return Params{}, nil, nil, errFormat
+	}
+	if p.Time == 0 || p.MemoryKiB == 0 || p.Threads == 0 {
+		return Params{}, nil, nil, errFormat
+	}
+
+	hashSalt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return Params{}, nil, nil, errFormat
+	}
+	want, err := b64.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return Params{}, nil, nil, errFormat
+	}
+	p.KeyLen = uint32(len(want))
+	return p, hashSalt, want, nil
+}
@@ -0,0 +1,303 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Reason explains why a password was rejected
+type Reason struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Reason codes
+const (
+	ReasonTooShort      = "too_short"
+	ReasonComplexity    = "complexity"
+	ReasonWeak          = "weak"
+	ReasonCommon        = "common_password"
+	ReasonPersonal      = "personal_info"
+	ReasonRepeated      = "repeated_characters"
+	ReasonSequence      = "sequence"
+	ReasonKeyboard      = "keyboard_pattern"
+	ReasonDate          = "date"
+	ReasonBreached      = "breached"
+	ReasonBreachUnknown = "breach_check_unavailable"
+)
+
+// Strength is a zxcvbn-style estimate: Score runs from 0 (trivially
+// guessable) to 4 (very unguessable), Guesses is log10 of the estimated
+// number of guesses, and Feedback lists the patterns that weakened it.
+type Strength struct {
+	Score    int      `json:"score"`
+	Guesses  float64  `json:"guesses_log10"`
+	Feedback []Reason `json:"feedback,omitempty"`
+}
+
+// Common passwords and words, most common first; the rank is the guess count
+var common = []string{
+	"password", "123456", "12345678", "qwerty", "abc123", "monkey", "letmein",
+	"dragon", "111111", "baseball", "iloveyou", "trustno1", "sunshine", "master",
+	"welcome", "shadow", "ashley", "football", "jesus", "michael", "ninja",
+	"mustang", "admin", "login", "princess", "starwars", "passw0rd", "whatever",
+	"freedom", "hello", "charlie", "secret", "summer", "winter", "spring",
+	"autumn", "flower", "hunter", "soccer", "hockey", "killer", "batman",
+	"superman", "computer", "internet", "access", "love", "pepper", "ginger",
+	"cheese", "orange", "banana", "apple", "chocolate", "cookie", "tigger",
+	"jordan", "harley", "ranger", "buster", "thomas", "robert", "daniel",
+	"andrew", "joshua", "matthew", "jennifer", "jessica", "michelle", "maggie",
+	"qazwsx", "zxcvbn", "asdfgh", "654321", "666666", "121212", "000000",
+	"changeme", "default", "company", "office", "manila", "philippines",
+	"payroll", "lettersheets", "test", "guest", "user", "root", "pass",
+}
+
+var commonRank = func() map[string]int {
+	m := make(map[string]int, len(common))
+	for i, w := range common {
+		m[w] = i + 1
+	}
+	return m
+}()
+
+var keyboardRows = []string{
+	"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./",
+}
+
+var leet = strings.NewReplacer(
+	"@", "a", "4", "a", "0", "o", "1", "i", "!", "i", "3", "e",
+	"$", "s", "5", "s", "7", "t", "+", "t",
+)
+
+// Estimate scores pw. userInputs (email, username, company name) count as
+// known to an attacker.
+func Estimate(pw string, userInputs ...string) Strength {
+	var s Strength
+	if pw == "" {
+		return s
+	}
+
+	lower := strings.ToLower(pw)
+	unleet := leet.Replace(lower)
+	runes := []rune(lower)
+
+	feedback := map[string]bool{}
+	add := func(code, msg string) {
+		if !feedback[code] {
+			feedback[code] = true
+			s.Feedback = append(s.Feedback, Reason{Code: code, Message: msg})
+		}
+	}
+
+	// Whole password is a common one (allowing leet and a trailing digit or two)
+	if rank, ok := commonRank[strings.TrimRight(unleet, "0123456789!")]; ok {
+		guesses := float64(rank)
+		if unleet != lower || lower != pw {
+			guesses *= 4
+		}
+		guesses *= math.Pow(10, float64(len(unleet)-len(strings.TrimRight(unleet, "0123456789!"))))
+		s.Guesses = math.Log10(guesses + 1)
+		s.Score = score(s.Guesses)
+		add(ReasonCommon, "this is a commonly used password")
+		return s
+	}
+
+	// Mark characters covered by a pattern; they are charged per pattern, not per character
+	covered := make([]bool, len(runes))
+	bits := 0.0
+
+	markWord := func(word string, code, msg string, wordBits float64) {
+		if len([]rune(word)) < 3 {
+			return
+		}
+		for _, src := range []string{lower, unleet} {
+			i := strings.Index(src, word)
+			if i < 0 {
+				continue
+			}
+			start := len([]rune(src[:i]))
+			for j := start; j < start+len([]rune(word)) && j < len(covered); j++ {
+				covered[j] = true
+			}
+			bits += wordBits
+			add(code, msg)
+			return
+		}
+	}
+
+	for _, input := range userInputs {
+		for _, part := range strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			markWord(part, ReasonPersonal, "avoid your name, email or company in the password", 2)
+		}
+	}
+	for _, w := range common {
+		if len(w) >= 4 {
+			markWord(w, ReasonCommon, "avoid common words and passwords", math.Log2(float64(commonRank[w]+1)))
+		}
+	}
+
+	// Years 1900-2039
+	for i := 0; i+4 <= len(runes); i++ {
+		y := string(runes[i : i+4])
+		if (strings.HasPrefix(y, "19") || strings.HasPrefix(y, "20")) && allDigits(y) && y[:3] <= "203" && !covered[i] {
+			for j := i; j < i+4; j++ {
+				covered[j] = true
+			}
+			bits += math.Log2(140)
+			add(ReasonDate, "avoid dates and years")
+		}
+	}
+
+	// Runs of repeats, sequences (abc, 987) and keyboard neighbours (qwer)
+	for i := 0; i < len(runes); {
+		n, code := run(runes, i)
+		if n >= 3 {
+			allCovered := true
+			for j := i; j < i+n; j++ {
+				allCovered = allCovered && covered[j]
+				covered[j] = true
+			}
+			if !allCovered {
+				bits += math.Log2(float64(charset(string(runes[i:i+1])))) + math.Log2(float64(n))
+				switch code {
+				case ReasonRepeated:
+					add(code, "avoid repeated characters")
+				case ReasonSequence:
+					add(code, "avoid sequences like abc or 123")
+				case ReasonKeyboard:
+					add(code, "avoid keyboard patterns like qwerty")
+				}
+			}
+			i += n
+			continue
+		}
+		i++
+	}
+
+	// Remaining characters are charged at the size of the character set in use
+	var rest []rune
+	for i, c := range []rune(pw) {
+		if i < len(covered) && !covered[i] {
+			rest = append(rest, c)
+		}
+	}
+	if len(rest) > 0 {
+		bits += float64(len(rest)) * math.Log2(float64(charset(string(rest))))
+	}
+
+	s.Guesses = bits * math.Log10(2)
+	s.Score = score(s.Guesses)
+	return s
+}
+
+// score maps log10(guesses) to zxcvbn's 0-4 scale
+func score(log10Guesses float64) int {
+	switch {
+	case log10Guesses < 3:
+		return 0
+	case log10Guesses < 6:
+		return 1
+	case log10Guesses < 8:
+		return 2
+	case log10Guesses < 10:
+		return 3
+	}
+	return 4
+}
+
+// run returns the length of the repeat, sequence or keyboard run starting at i
+func run(r []rune, i int) (int, string) {
+	if i+1 >= len(r) {
+		return 1, ""
+	}
+
+	n := 1
+	for i+n < len(r) && r[i+n] == r[i] {
+		n++
+	}
+	if n >= 3 {
+		return n, ReasonRepeated
+	}
+
+	delta := r[i+1] - r[i]
+	if delta == 1 || delta == -1 {
+		n = 2
+		for i+n < len(r) && r[i+n]-r[i+n-1] == delta {
+			n++
+		}
+		if n >= 3 {
+			return n, ReasonSequence
+		}
+	}
+
+	n = 1
+	for i+n < len(r) && adjacent(r[i+n-1], r[i+n]) {
+		n++
+	}
+	if n >= 4 {
+		return n, ReasonKeyboard
+	}
+	return 1, ""
+}
+
+func adjacent(a, b rune) bool {
+	for _, row := range keyboardRows {
+		ia, ib := strings.IndexRune(row, a), strings.IndexRune(row, b)
+		if ia >= 0 && ib >= 0 && (ib-ia == 1 || ia-ib == 1) {
+			return true
+		}
+	}
+	return false
+}
+
+// charset returns the size of the character classes used in s
+func charset(s string) int {
+	var lower, upper, digit, symbol, other bool
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		case c < 128:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	n := 0
+	if lower {
+		n += 26
+	}
+	if upper {
+		n += 26
+	}
+	if digit {
+		n += 10
+	}
+	if symbol {
+		n += 33
+	}
+	if other {
+		n += 100
+	}
+	if n < 2 {
+		n = 2
+	}
+	return n
+}
+
+func allDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package password
+
+import "testing"
+
+func TestEstimate(t *testing.T) {
+	// Every weak case scores below the default minimum of 2
+	cases := []struct {
+		pw       string
+		inputs   []string
+		maxScore int
+		reason   string
+	}{
+		{"password", nil, 0, ReasonCommon},
+		{"Password1", nil, 1, ReasonCommon},
+		{"p@ssw0rd", nil, 1, ReasonCommon},
+		{"$unsh1ne", nil, 1, ReasonCommon},
+		{"qwertyuiop", nil, 1, ReasonCommon},
+		{"zxcvbnm,./", nil, 1, ReasonKeyboard},
+		{"asdfghjkl;", nil, 1, ReasonKeyboard},
+		{"abcdefgh", nil, 1, ReasonSequence},
+		{"98765432", nil, 1, ReasonSequence},
+		{"aaaaaaaaaa", nil, 1, ReasonRepeated},
+		{"mustang1987", nil, 1, ReasonDate},
+		{"annsmith2024", []string{"ann.smith@example.com", "annsmith"}, 1, ReasonPersonal},
+		{"Acmecorp!!", []string{"", "", "Acmecorp"}, 1, ReasonPersonal},
+	}
+	for _, c := range cases {
+		s := Estimate(c.pw, c.inputs...)
+		if s.Score > c.maxScore {
+			t.Errorf("%q: score = %d, want at most %d", c.pw, s.Score, c.maxScore)
+		}
+		if c.reason != "" && !hasReason(s.Feedback, c.reason) {
+			t.Errorf("%q: feedback %v lacks %s", c.pw, s.Feedback, c.reason)
+		}
+	}
+
+	for _, pw := range []string{"correct horse battery staple", "vK7#pQ2!xL9$wR4z", "Gloomy-Tulip-Ferry-43"} {
+		if s := Estimate(pw); s.Score < 3 {
+			t.Errorf("%q: score = %d (%v), want at least 3", pw, s.Score, s.Feedback)
+		}
+	}
+
+	// The same password is weaker once it is known to belong to the user
+	if without, with := Estimate("ashwood-quarry"), Estimate("ashwood-quarry", "jo@ashwood-quarry.com"); with.Guesses >= without.Guesses {
+		t.Errorf("user inputs did not weaken the estimate: %.1f >= %.1f", with.Guesses, without.Guesses)
+	}
+
+	if s := Estimate(""); s.Score != 0 || s.Guesses != 0 {
+		t.Errorf("empty password: %+v", s)
+	}
+}
+
+func hasReason(reasons []Reason, code string) bool {
+	for _, r := range reasons {
+		if r.Code == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+// Package permission evaluates what a member may do in a company. Each role
+// has a default set; user_company_access.permissions adjusts it per user:
+//
+//	{"grant": ["salary:decrypt"], "deny": ["users:delete"]}
+//
+// A deny always wins over the role default and over a grant.
+package permission
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"lettersheets/models"
+)
+
+// Permissions
+const (
+	CompanyRead   = "company:read"
+	CompanyWrite  = "company:write"
+	CompanyDelete = "company:delete"
+
+	PolicyRead  = "security_policy:read"
+	PolicyWrite = "security_policy:write"
+
+	UsersRead   = "users:read"
+	UsersCreate = "users:create"
+	UsersDelete = "users:delete"
+	UsersUnlock = "users:unlock"
+
+	AccessManage = "access:manage"
+	SessionsRead = "sessions:read"
+
+	HistoryRead      = "history:read"
+	LoginHistoryRead = "login_history:read"
+
+	// Encrypted HR data; the client gates decryption on these
+	EmployeesRead  = "employees:read"
+	EmployeesWrite = "employees:write"
+	SalaryRead     = "salary:read"
+	SalaryDecrypt  = "salary:decrypt"
+)
+
+// All lists every known permission
+var All = []string{
+	CompanyRead, CompanyWrite, CompanyDelete,
+	PolicyRead, PolicyWrite,
+	UsersRead, UsersCreate, UsersDelete, UsersUnlock,
+	AccessManage, SessionsRead,
+	HistoryRead, LoginHistoryRead,
+	EmployeesRead, EmployeesWrite, SalaryRead, SalaryDecrypt,
+}
+
+var known = func() map[string]bool {
+	m := make(map[string]bool, len(All))
+	for _, p := range All {
+		m[p] = true
+	}
+	return m
+}()
+
+var defaults = map[string][]string{
+	models.RoleSuperAdmin: All,
+	models.RoleAdmin:      without(All, CompanyDelete),
+	models.RoleHR: {
+		CompanyRead, UsersRead, HistoryRead,
+		EmployeesRead, EmployeesWrite, SalaryRead,
+	},
+	models.RolePayroll:  {CompanyRead, EmployeesRead, SalaryRead, SalaryDecrypt},
+	models.RoleManager:  {CompanyRead, EmployeesRead},
+	models.RoleEmployee: {CompanyRead},
+}
+
+// Overrides are the per-user grants and denies stored with the access row
+type Overrides struct {
+	Grant []string `json:"grant,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// Set is a collection of permissions
+type Set map[string]bool
+
+func (s Set) Has(p string) bool {
+	return s[p]
+}
+
+// List returns the permissions in s, sorted
+func (s Set) List() []string {
+	list := make([]string, 0, len(s))
+	for p := range s {
+		list = append(list, p)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Defaults returns the permissions a role has without overrides
+func Defaults(role string) Set {
+	s := Set{}
+	for _, p := range defaults[role] {
+		s[p] = true
+	}
+	return s
+}
+
+// Parse reads the stored overrides; NULL, empty and {} mean none
+func Parse(raw *string) (Overrides, error) {
+	var o Overrides
+	if raw == nil || strings.TrimSpace(*raw) == "" || strings.TrimSpace(*raw) == "null" {
+		return o, nil
+	}
+	dec := json.NewDecoder(strings.NewReader(*raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&o); err != nil {
+		return Overrides{}, errors.New(`permissions must be {"grant": [...], "deny": [...]}`)
+	}
+	return o, nil
+}
+
+// Validate rejects unknown permissions and permissions both granted and denied
+func (o Overrides) Validate() error {
+	granted := map[string]bool{}
+	for _, p := range o.Grant {
+		if !known[p] {
+			return fmt.Errorf("unknown permission: %s", p)
+		}
+		granted[p] = true
+	}
+	for _, p := range o.Deny {
+		if !known[p] {
+			return fmt.Errorf("unknown permission: %s", p)
+		}
+		if granted[p] {
+			return fmt.Errorf("permission both granted and denied: %s", p)
+		}
+	}
+	return nil
+}
+
+// Effective returns what a member with role and stored overrides may do
+func Effective(role string, raw *string) (Set, error) {
+	o, err := Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	s := Defaults(role)
+	for _, p := range o.Grant {
+		if known[p] {
+			s[p] = true
+		}
+	}
+	for _, p := range o.Deny {
+		delete(s, p)
+	}
+	return s, nil
+}
+
+func without(list []string, drop string) []string {
+	out := make([]string, 0, len(list))
+	for _, p := range list {
+		if p != drop {
+			out = append(out, p)
+		}
+	}
+	return out
+}
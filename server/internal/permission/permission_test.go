@@ -0,0 +1,118 @@
+package permission
+
+import (
+	"reflect"
+	"testing"
+
+	"lettersheets/models"
+)
+
+func TestDefaults(t *testing.T) {
+	cases := []struct {
+		role string
+		has  []string
+		not  []string
+	}{
+		{models.RoleSuperAdmin, All, nil},
+		{models.RoleAdmin, without(All, CompanyDelete), []string{CompanyDelete}},
+		{models.RoleHR, []string{UsersRead, EmployeesWrite, SalaryRead}, []string{SalaryDecrypt, AccessManage}},
+		{models.RolePayroll, []string{SalaryRead, SalaryDecrypt}, []string{EmployeesWrite, UsersRead}},
+		{models.RoleManager, []string{CompanyRead, EmployeesRead}, []string{SalaryRead}},
+		{models.RoleEmployee, []string{CompanyRead}, []string{EmployeesRead}},
+		{"owner", nil, []string{CompanyRead}},
+	}
+	for _, c := range cases {
+		s := Defaults(c.role)
+		for _, p := range c.has {
+			if !s.Has(p) {
+				t.Errorf("%s: missing %s", c.role, p)
+			}
+		}
+		for _, p := range c.not {
+			if s.Has(p) {
+				t.Errorf("%s: unexpectedly has %s", c.role, p)
+			}
+		}
+	}
+}
+
+func TestEffective(t *testing.T) {
+	str := func(s string) *string { return &s }
+	cases := []struct {
+		name string
+		role string
+		raw  *string
+		want []string
+	}{
+		{"defaults", models.RoleManager, nil, []string{CompanyRead, EmployeesRead}},
+		{"grant", models.RoleManager, str(`{"grant": ["salary:read"]}`), []string{CompanyRead, EmployeesRead, SalaryRead}},
+		{"deny", models.RoleManager, str(`{"deny": ["employees:read"]}`), []string{CompanyRead}},
+		{"deny wins over grant", models.RoleEmployee, str(`{"grant": ["salary:read"], "deny": ["salary:read"]}`), []string{CompanyRead}},
+		{"unknown grant ignored", models.RoleEmployee, str(`{"grant": ["salary:write"]}`), []string{CompanyRead}},
+	}
+	for _, c := range cases {
+		s, err := Effective(c.role, c.raw)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := s.List(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	if _, err := Effective(models.RoleEmployee, str(`["salary:read"]`)); err == nil {
+		t.Error("unreadable overrides: expected an error")
+	}
+}
+
+func TestParse(t *testing.T) {
+	str := func(s string) *string { return &s }
+	cases := []struct {
+		name    string
+		raw     *string
+		want    Overrides
+		wantErr bool
+	}{
+		{name: "NULL", raw: nil},
+		{name: "empty", raw: str("  ")},
+		{name: "null", raw: str("null")},
+		{name: "{}", raw: str("{}")},
+		{name: "grant and deny", raw: str(`{"grant": ["users:read"], "deny": ["users:delete"]}`),
+			want: Overrides{Grant: []string{UsersRead}, Deny: []string{UsersDelete}}},
+		{name: "unknown field", raw: str(`{"allow": ["users:read"]}`), wantErr: true},
+		{name: "not an object", raw: str(`["users:read"]`), wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := Parse(c.raw)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: error = %v, want error %v", c.name, err, c.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		o    Overrides
+		want string
+	}{
+		{"none", Overrides{}, ""},
+		{"known", Overrides{Grant: []string{SalaryDecrypt}, Deny: []string{UsersDelete}}, ""},
+		{"unknown grant", Overrides{Grant: []string{"salary:write"}}, "unknown permission: salary:write"},
+		{"unknown deny", Overrides{Deny: []string{"*"}}, "unknown permission: *"},
+		{"granted and denied", Overrides{Grant: []string{SalaryRead}, Deny: []string{SalaryRead}}, "permission both granted and denied: salary:read"},
+	}
+	for _, c := range cases {
+		got := ""
+		if err := c.o.Validate(); err != nil {
+			got = err.Error()
+		}
+		if got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
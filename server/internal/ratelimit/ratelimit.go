@@ -0,0 +1,99 @@
+// Package ratelimit implements token bucket rate limiting. Buckets live in
+// a Store: in memory for a single instance, or shared (e.g. MySQL) when
+// several instances serve the same clients.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket refilled at PerMinute tokens a minute and holding
+// at most Burst tokens. Each request takes one token.
+type Limit struct {
+	PerMinute int
+	Burst     int
+}
+
+func (l Limit) ratePerSecond() float64 {
+	return float64(l.PerMinute) / 60
+}
+
+// Store takes a token from the bucket for key. When none is left it returns
+// allowed = false and how long until one is available.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// sweepEvery is how many takes pass between removals of full (idle) buckets
+const sweepEvery = 1000
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// MemoryStore keeps buckets in process memory
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	takes   int
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.takes++
+	if s.takes%sweepEvery == 0 {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+
+	b.tokens = refill(b.tokens, now.Sub(b.last), limit)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	return false, wait(b.tokens, limit), nil
+}
+
+// sweep drops buckets that have refilled completely; they are
+// indistinguishable from new ones
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if refill(b.tokens, now.Sub(b.last), b.limit) >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func refill(tokens float64, elapsed time.Duration, limit Limit) float64 {
+	return math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.ratePerSecond())
+}
+
+// wait returns how long until the bucket holds a whole token
+func wait(tokens float64, limit Limit) time.Duration {
+	rate := limit.ratePerSecond()
+	if rate <= 0 {
+		return time.Hour
+	}
+	return time.Duration((1 - tokens) / rate * float64(time.Second))
+}
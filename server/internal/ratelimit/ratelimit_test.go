@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// clock is a MemoryStore time source moved by hand
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestStore(c *clock) *MemoryStore {
+	s := NewMemoryStore()
+	s.now = c.now
+	return s
+}
+
+// near compares durations computed with floating point token counts
+func near(got, want time.Duration) bool {
+	d := got - want
+	return d > -time.Millisecond && d < time.Millisecond
+}
+
+func TestBurst(t *testing.T) {
+	c := &clock{t: time.Unix(1700000000, 0)}
+	s := newTestStore(c)
+	limit := Limit{PerMinute: 6, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		if allowed, _, _ := s.Take(context.Background(), "k", limit); !allowed {
+			t.Fatalf("take %d within the burst was refused", i+1)
+		}
+	}
+	allowed, retryAfter, _ := s.Take(context.Background(), "k", limit)
+	if allowed {
+		t.Fatal("take beyond the burst was allowed")
+	}
+	// 6 a minute is one token every 10 seconds
+	if !near(retryAfter, 10*time.Second) {
+		t.Fatalf("retryAfter = %v, want 10s", retryAfter)
+	}
+
+	// Other keys have their own bucket
+	if allowed, _, _ := s.Take(context.Background(), "other", limit); !allowed {
+		t.Fatal("another key shared the empty bucket")
+	}
+}
+
+func TestRefill(t *testing.T) {
+	c := &clock{t: time.Unix(1700000000, 0)}
+	s := newTestStore(c)
+	limit := Limit{PerMinute: 6, Burst: 2}
+	take := func() (bool, time.Duration) {
+		allowed, retryAfter, err := s.Take(context.Background(), "k", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return allowed, retryAfter
+	}
+
+	take()
+	take()
+
+	// Part of a token: refused, told to wait for the rest
+	c.advance(4 * time.Second)
+	if allowed, retryAfter := take(); allowed || !near(retryAfter, 6*time.Second) {
+		t.Fatalf("after 4s: allowed = %v, retryAfter = %v, want false, 6s", allowed, retryAfter)
+	}
+	c.advance(6 * time.Second)
+	if allowed, _ := take(); !allowed {
+		t.Fatal("after 10s: refused")
+	}
+
+	// A long idle period refills no more than the burst
+	c.advance(time.Hour)
+	for i := 0; i < 2; i++ {
+		if allowed, _ := take(); !allowed {
+			t.Fatalf("after an hour: take %d refused", i+1)
+		}
+	}
+	if allowed, _ := take(); allowed {
+		t.Fatal("after an hour: allowed more than the burst")
+	}
+}
+
+func TestRetryAfterWithoutRefill(t *testing.T) {
+	c := &clock{t: time.Unix(1700000000, 0)}
+	s := newTestStore(c)
+	limit := Limit{PerMinute: 0, Burst: 1}
+
+	s.Take(context.Background(), "k", limit)
+	if allowed, retryAfter, _ := s.Take(context.Background(), "k", limit); allowed || retryAfter != time.Hour {
+		t.Fatalf("allowed = %v, retryAfter = %v, want false, 1h", allowed, retryAfter)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	c := &clock{t: time.Unix(1700000000, 0)}
+	s := newTestStore(c)
+	limit := Limit{PerMinute: 60, Burst: 1}
+
+	s.Take(context.Background(), "idle", limit)
+	c.advance(time.Minute)
+	for i := 1; i < sweepEvery; i++ {
+		s.Take(context.Background(), "busy", limit)
+	}
+	if _, ok := s.buckets["idle"]; ok {
+		t.Fatal("a refilled bucket survived the sweep")
+	}
+	if _, ok := s.buckets["busy"]; !ok {
+		t.Fatal("the sweep dropped a bucket in use")
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"database/sql"
 
-	"lettersheets/internal/models"
+	"lettersheets/models"
 )
 
 type AccessRepo struct {
@@ -15,10 +15,14 @@ func NewAccessRepo(db *sql.DB) *AccessRepo {
 	return &AccessRepo{db: db}
 }
 
-func (r *AccessRepo) Create(ctx context.Context, access *models.UserCompanyAccess, meta *models.RequestMeta) error {
+// Create grants a user access to the scoped company; access.CompanyID is ignored
+func (r *AccessRepo) Create(ctx context.Context, scope Scope, access *models.UserCompanyAccess, meta *models.RequestMeta) error {
+	if err := scope.check(); err != nil {
+		return err
+	}
 	_, err := r.db.ExecContext(ctx,
 		"CALL sp_create_user_company_access(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		access.ID, access.UserID, access.CompanyID,
+		access.ID, access.UserID, scope.companyID,
 		access.WrappedCompanyKey, access.KeyWrapAlgorithm, access.PublicKey,
 		access.Role, access.Permissions,
 		meta.UserID, meta.SessionID, meta.IPAddress, meta.UserAgent,
@@ -39,7 +43,7 @@ func (r *AccessRepo) GetUserCompanies(ctx context.Context, userID string) ([]mod
 		err := rows.Scan(
 			&a.ID, &a.CompanyID, &a.WrappedCompanyKey, &a.KeyWrapAlgorithm,
 			&a.KeyVersion, &a.PublicKey, &a.Role, &a.Permissions, &a.JoinedAt,
-			&a.CompanyName, &a.CompanyPlan,
+			&a.CompanyName, &a.CompanyPlan, &a.KeyLostAt,
 		)
 		if err != nil {
 			return nil, err
@@ -51,8 +55,11 @@ func (r *AccessRepo) GetUserCompanies(ctx context.Context, userID string) ([]mod
 	return result, rows.Err()
 }
 
-func (r *AccessRepo) GetCompanyUsers(ctx context.Context, companyID string) ([]models.UserCompanyAccess, error) {
-	rows, err := r.db.QueryContext(ctx, "CALL sp_get_company_users(?)", companyID)
+func (r *AccessRepo) GetCompanyUsers(ctx context.Context, scope Scope) ([]models.UserCompanyAccess, error) {
+	if err := scope.check(); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, "CALL sp_get_company_users(?)", scope.companyID)
 	if err != nil {
 		return nil, err
 	}
@@ -71,28 +78,38 @@ func (r *AccessRepo) GetCompanyUsers(ctx context.Context, companyID string) ([]m
 		if err != nil {
 			return nil, err
 		}
-		a.CompanyID = companyID
+		a.CompanyID = scope.companyID
 		a.IsActive = true
 		result = append(result, a)
 	}
 	return result, rows.Err()
 }
 
-func (r *AccessRepo) Update(ctx context.Context, access *models.UserCompanyAccess, meta *models.RequestMeta) error {
-	_, err := r.db.ExecContext(ctx,
+// Update changes an access row of the scoped company; ErrNotFound when
+// access.ID is not an active member of it
+func (r *AccessRepo) Update(ctx context.Context, scope Scope, access *models.UserCompanyAccess, meta *models.RequestMeta) error {
+	if err := scope.check(); err != nil {
+		return err
+	}
+	row := r.db.QueryRowContext(ctx,
 		"CALL sp_update_user_company_access(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		access.ID, access.Role, access.Permissions,
 		access.WrappedCompanyKey, access.KeyWrapAlgorithm, access.KeyVersion,
 		access.PublicKey,
-		meta.CompanyID, meta.UserID, meta.SessionID, meta.IPAddress, meta.UserAgent,
+		scope.companyID, meta.UserID, meta.SessionID, meta.IPAddress, meta.UserAgent,
 	)
-	return err
+	return foundOrNotFound(row)
 }
 
-func (r *AccessRepo) Delete(ctx context.Context, id string, meta *models.RequestMeta) error {
-	_, err := r.db.ExecContext(ctx,
+// Delete revokes an access row of the scoped company and ends the member's
+// sessions in it; ErrNotFound when id is not an active member of it
+func (r *AccessRepo) Delete(ctx context.Context, scope Scope, id string, meta *models.RequestMeta) error {
+	if err := scope.check(); err != nil {
+		return err
+	}
+	row := r.db.QueryRowContext(ctx,
 		"CALL sp_delete_user_company_access(?, ?, ?, ?, ?, ?)",
-		id, meta.CompanyID, meta.UserID, meta.SessionID, meta.IPAddress, meta.UserAgent,
+		id, scope.companyID, meta.UserID, meta.SessionID, meta.IPAddress, meta.UserAgent,
 	)
-	return err
+	return foundOrNotFound(row)
 }
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type AuthTokenRepo struct {
+	db *sql.DB
+}
+
+func NewAuthTokenRepo(db *sql.DB) *AuthTokenRepo {
+	return &AuthTokenRepo{db: db}
+}
+
+func (r *AuthTokenRepo) Create(ctx context.Context, id, userID, tokenHash, purpose, ipAddress string, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx,
+		"CALL sp_create_auth_token(?, ?, ?, ?, ?, ?)",
+		id, userID, tokenHash, purpose, ipAddress, expiresAt,
+	)
+	return err
+}
+
+// Consume marks the token as used and returns the user it was issued to.
+// An empty user ID means the token is invalid, expired, already used, or
+// was issued for a different purpose.
+func (r *AuthTokenRepo) Consume(ctx context.Context, tokenHash, purpose string) (string, error) {
+	row := r.db.QueryRowContext(ctx, "CALL sp_consume_auth_token(?, ?)", tokenHash, purpose)
+
+	var userID sql.NullString
+	if err := row.Scan(&userID); err != nil {
+		return "", err
+	}
+	return userID.String, nil
+}
+
+// Peek returns the user a valid token was issued to without consuming it
+func (r *AuthTokenRepo) Peek(ctx context.Context, tokenHash, purpose string) (string, error) {
+	row := r.db.QueryRowContext(ctx, "CALL sp_peek_auth_token(?, ?)", tokenHash, purpose)
+
+	var userID string
+	err := row.Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return userID, err
+}
+
+// CreatePasswordReset stores a reset token unless the user or IP has reached
+// maxPerHour requests in the last hour; false means the limit was hit
+func (r *AuthTokenRepo) CreatePasswordReset(ctx context.Context, id, userID, tokenHash string, expiresAt time.Time, maxPerHour int, ipAddress, userAgent string) (bool, error) {
+	row := r.db.QueryRowContext(ctx,
+		"CALL sp_create_password_reset(?, ?, ?, ?, ?, ?, ?, ?)",
+		id, userID, tokenHash, expiresAt, maxPerHour, maxPerHour*3, ipAddress, userAgent,
+	)
+
+	var created bool
+	if err := row.Scan(&created); err != nil {
+		return false, err
+	}
+	return created, nil
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"database/sql"
 
-	"lettersheets/internal/models"
+	"lettersheets/models"
 )
 
 type ChangeHistoryRepo struct {
@@ -15,10 +15,13 @@ func NewChangeHistoryRepo(db *sql.DB) *ChangeHistoryRepo {
 	return &ChangeHistoryRepo{db: db}
 }
 
-func (r *ChangeHistoryRepo) Get(ctx context.Context, companyID string, tableName, recordID *string, limit, offset int) ([]models.ChangeHistory, error) {
+func (r *ChangeHistoryRepo) Get(ctx context.Context, scope Scope, tableName, recordID *string, limit, offset int) ([]models.ChangeHistory, error) {
+	if err := scope.check(); err != nil {
+		return nil, err
+	}
 	rows, err := r.db.QueryContext(ctx,
 		"CALL sp_get_change_history(?, ?, ?, ?, ?)",
-		companyID, tableName, recordID, limit, offset,
+		scope.companyID, tableName, recordID, limit, offset,
 	)
 	if err != nil {
 		return nil, err
@@ -42,3 +45,15 @@ func (r *ChangeHistoryRepo) Get(ctx context.Context, companyID string, tableName
 	}
 	return result, rows.Err()
 }
+
+// Log records a single change entry via sp_log_change
+func (r *ChangeHistoryRepo) Log(ctx context.Context, ch *models.ChangeHistory) error {
+	_, err := r.db.ExecContext(ctx,
+		"CALL sp_log_change(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		ch.CompanyID, ch.ChangedBy, ch.SessionID,
+		ch.TableName, ch.RecordID, ch.ChangeType,
+		ch.FieldName, ch.OldValue, ch.NewValue, ch.IsEncrypted,
+		ch.IPAddress, ch.UserAgent,
+	)
+	return err
+}
@@ -3,8 +3,9 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 
-	"lettersheets/internal/models"
+	"lettersheets/models"
 )
 
 type CompanyRepo struct {
@@ -66,3 +67,111 @@ func (r *CompanyRepo) Delete(ctx context.Context, id string, meta *models.Reques
 	)
 	return err
 }
+
+// ==================== SECURITY POLICY ====================
+
+// GetSecurityPolicy returns the company's policy along with the user's
+// password age and MFA enrollment
+func (r *CompanyRepo) GetSecurityPolicy(ctx context.Context, companyID, userID string) (*models.SecurityPolicy, error) {
+	row := r.db.QueryRowContext(ctx, "CALL sp_get_security_policy(?, ?)", companyID, userID)
+
+	var p models.SecurityPolicy
+	var roles, cidrs []byte
+	var mfaEnrolled sql.NullBool
+	err := row.Scan(
+		&p.CompanyID, &roles, &p.PasswordMinLength,
+		&p.PasswordRequireComplexity, &p.PasswordMaxAgeDays,
+		&p.SessionHours, &cidrs,
+		&p.MaxLoginAttempts, &p.LockoutMinutes,
+		&p.PasswordChangedAt, &mfaEnrolled,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.MFAEnrolled = mfaEnrolled.Bool
+
+	if err := unmarshalPolicyLists(&p, roles, cidrs); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetUserSecurityPolicies returns the policies of every company the user can access
+func (r *CompanyRepo) GetUserSecurityPolicies(ctx context.Context, userID string) ([]models.SecurityPolicy, error) {
+	rows, err := r.db.QueryContext(ctx, "CALL sp_get_user_security_policies(?)", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []models.SecurityPolicy
+	for rows.Next() {
+		var p models.SecurityPolicy
+		var roles, cidrs []byte
+		err := rows.Scan(
+			&p.CompanyID, &roles, &p.PasswordMinLength,
+			&p.PasswordRequireComplexity, &p.PasswordMaxAgeDays,
+			&p.SessionHours, &cidrs,
+			&p.MaxLoginAttempts, &p.LockoutMinutes,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := unmarshalPolicyLists(&p, roles, cidrs); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
+func (r *CompanyRepo) UpdateSecurityPolicy(ctx context.Context, p *models.SecurityPolicy, meta *models.RequestMeta) error {
+	roles, err := marshalPolicyList(p.MFARequiredRoles)
+	if err != nil {
+		return err
+	}
+	cidrs, err := marshalPolicyList(p.AllowedIPCIDRs)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx,
+		"CALL sp_update_security_policy(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		p.CompanyID, roles, p.PasswordMinLength,
+		p.PasswordRequireComplexity, p.PasswordMaxAgeDays,
+		p.SessionHours, cidrs,
+		p.MaxLoginAttempts, p.LockoutMinutes,
+		meta.UserID, meta.SessionID, meta.IPAddress, meta.UserAgent,
+	)
+	return err
+}
+
+func unmarshalPolicyLists(p *models.SecurityPolicy, roles, cidrs []byte) error {
+	if len(roles) > 0 {
+		if err := json.Unmarshal(roles, &p.MFARequiredRoles); err != nil {
+			return err
+		}
+	}
+	if len(cidrs) > 0 {
+		if err := json.Unmarshal(cidrs, &p.AllowedIPCIDRs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalPolicyList stores an empty list as NULL
+func marshalPolicyList(list []string) (*string, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
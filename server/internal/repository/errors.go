@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// erDupEntry is MySQL's duplicate key error
+const erDupEntry = 1062
+
+// sqlStateSignal is the SQLSTATE procedures raise with SIGNAL to refuse a
+// write, e.g. when wrapped keys no longer match the user's companies
+const sqlStateSignal = "45000"
+
+// Signalled reports whether err is a refusal SIGNALled by a procedure, as
+// opposed to a failure of the database itself
+func Signalled(err error) bool {
+	var me *mysql.MySQLError
+	return errors.As(err, &me) && string(me.SQLState[:]) == sqlStateSignal
+}
+
+// DuplicateKey reports the unique key a write violated, e.g.
+// "uk_users_email", so callers can say which value is taken
+func DuplicateKey(err error) (key string, ok bool) {
+	var me *mysql.MySQLError
+	if !errors.As(err, &me) || me.Number != erDupEntry {
+		return "", false
+	}
+	// Duplicate entry '...' for key 'users.uk_users_email' (the table
+	// prefix is only present on MySQL 8)
+	_, key, _ = strings.Cut(me.Message, "for key '")
+	key = strings.TrimSuffix(key, "'")
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		key = key[i+1:]
+	}
+	return key, true
+}
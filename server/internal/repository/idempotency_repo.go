@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"lettersheets/models"
+)
+
+// IdempotencyRepo stores the responses of requests sent with an
+// Idempotency-Key, per scope (a user ID or "email:<hash of the address>")
+type IdempotencyRepo struct {
+	db *sql.DB
+}
+
+func NewIdempotencyRepo(db *sql.DB) *IdempotencyRepo {
+	return &IdempotencyRepo{db: db}
+}
+
+// Begin claims key for a request. created is true when the caller holds the
+// claim and must Complete or Release it; otherwise prior is the request that
+// used the key first, with a nil StatusCode while it is still running.
+func (r *IdempotencyRepo) Begin(ctx context.Context, scope, key, action, requestHash string, expiresAt time.Time) (created bool, prior *models.IdempotentResponse, err error) {
+	row := r.db.QueryRowContext(ctx,
+		"CALL sp_begin_idempotent_request(?, ?, ?, ?, ?)",
+		scope, key, action, requestHash, expiresAt,
+	)
+
+	prior = &models.IdempotentResponse{}
+	var status sql.NullInt64
+	if err := row.Scan(&created, &prior.RequestHash, &status, &prior.ResponseBody); err != nil {
+		return false, nil, err
+	}
+	if status.Valid {
+		code := int(status.Int64)
+		prior.StatusCode = &code
+	}
+	return created, prior, nil
+}
+
+// Complete stores the response of a claimed request
+func (r *IdempotencyRepo) Complete(ctx context.Context, scope, key string, statusCode int, body []byte) error {
+	_, err := r.db.ExecContext(ctx,
+		"CALL sp_complete_idempotent_request(?, ?, ?, ?)",
+		scope, key, statusCode, body,
+	)
+	return err
+}
+
+// Release drops a claim so the request can run again
+func (r *IdempotencyRepo) Release(ctx context.Context, scope, key string) error {
+	_, err := r.db.ExecContext(ctx, "CALL sp_release_idempotent_request(?, ?)", scope, key)
+	return err
+}
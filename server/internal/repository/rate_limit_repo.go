@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"lettersheets/internal/ratelimit"
+)
+
+// RateLimitRepo is a ratelimit.Store shared by every server instance
+type RateLimitRepo struct {
+	db *sql.DB
+}
+
+func NewRateLimitRepo(db *sql.DB) *RateLimitRepo {
+	return &RateLimitRepo{db: db}
+}
+
+func (r *RateLimitRepo) Take(ctx context.Context, key string, limit ratelimit.Limit) (bool, time.Duration, error) {
+	row := r.db.QueryRowContext(ctx,
+		"CALL sp_take_rate_limit_token(?, ?, ?)",
+		key, limit.PerMinute, limit.Burst,
+	)
+
+	var allowed bool
+	var retryMS int64
+	if err := row.Scan(&allowed, &retryMS); err != nil {
+		return false, 0, err
+	}
+	return allowed, time.Duration(retryMS) * time.Millisecond, nil
+}
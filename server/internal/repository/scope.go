@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var (
+	// ErrNotFound means the row does not exist in the scoped company,
+	// including when it exists in another one
+	ErrNotFound = errors.New("repository: not found in company")
+
+	errNoScope = errors.New("repository: company scope is required")
+)
+
+// Scope confines a call to one company. Methods on company-owned rows take
+// a Scope instead of a bare ID and their procedures match every row against
+// it, so an ID taken from another company is simply not found.
+type Scope struct {
+	companyID string
+}
+
+// InCompany scopes calls to companyID, normally the session's company
+func InCompany(companyID string) Scope {
+	return Scope{companyID: companyID}
+}
+
+func (s Scope) CompanyID() string {
+	return s.companyID
+}
+
+// check refuses the zero Scope, which would match no company
+func (s Scope) check() error {
+	if s.companyID == "" {
+		return errNoScope
+	}
+	return nil
+}
+
+// foundOrNotFound reads the single flag a scoped write procedure returns
+func foundOrNotFound(row *sql.Row) error {
+	var found bool
+	if err := row.Scan(&found); err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
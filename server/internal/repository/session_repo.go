@@ -5,7 +5,7 @@ import (
 	"database/sql"
 	"time"
 
-	"lettersheets/internal/models"
+	"lettersheets/models"
 )
 
 type SessionRepo struct {
@@ -16,20 +16,26 @@ func NewSessionRepo(db *sql.DB) *SessionRepo {
 	return &SessionRepo{db: db}
 }
 
-func (r *SessionRepo) Create(ctx context.Context, id, userID, companyID, deviceInfo, ipAddress string, expiresAt time.Time) error {
+// Create stores a session and its first refresh token; only token hashes are stored
+func (r *SessionRepo) Create(ctx context.Context, params *SessionParams) error {
 	_, err := r.db.ExecContext(ctx,
-		"CALL sp_create_session(?, ?, ?, ?, ?, ?)",
-		id, userID, companyID, deviceInfo, ipAddress, expiresAt,
+		"CALL sp_create_session(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		params.ID, params.LoginID, params.TokenHash, params.UserID, params.CompanyID,
+		params.DeviceInfo, params.IPAddress,
+		params.ExpiresAt, params.AbsoluteExpiresAt,
+		params.RefreshID, params.RefreshTokenHash,
 	)
 	return err
 }
 
-func (r *SessionRepo) Validate(ctx context.Context, sessionID string) (*models.UserSession, error) {
-	row := r.db.QueryRowContext(ctx, "CALL sp_validate_session(?)", sessionID)
+// Validate looks up an active session by the hash of its bearer token
+func (r *SessionRepo) Validate(ctx context.Context, tokenHash string, idleMinutes int) (*models.UserSession, error) {
+	row := r.db.QueryRowContext(ctx, "CALL sp_validate_session(?, ?)", tokenHash, idleMinutes)
 
 	var s models.UserSession
 	err := row.Scan(
-		&s.ID, &s.UserID, &s.CompanyID, &s.ExpiresAt,
+		&s.ID, &s.UserID, &s.CompanyID, &s.LoginID,
+		&s.ExpiresAt, &s.AbsoluteExpiresAt,
 		&s.Email, &s.Username, &s.UserActive,
 		&s.Role, &s.Permissions, &s.WrappedCompanyKey,
 		&s.KeyWrapAlgorithm, &s.KeyVersion, &s.PublicKey,
@@ -52,3 +58,113 @@ func (r *SessionRepo) InvalidateAll(ctx context.Context, userID string) error {
 	_, err := r.db.ExecContext(ctx, "CALL sp_invalidate_all_sessions(?)", userID)
 	return err
 }
+
+// ListByUser returns a user's own active sessions across all companies
+func (r *SessionRepo) ListByUser(ctx context.Context, userID string) ([]models.SessionInfo, error) {
+	return r.list(ctx, userID, nil)
+}
+
+// ListInCompany returns a member's active sessions in the scoped company
+func (r *SessionRepo) ListInCompany(ctx context.Context, scope Scope, userID string) ([]models.SessionInfo, error) {
+	if err := scope.check(); err != nil {
+		return nil, err
+	}
+	return r.list(ctx, userID, &scope.companyID)
+}
+
+func (r *SessionRepo) list(ctx context.Context, userID string, companyID *string) ([]models.SessionInfo, error) {
+	rows, err := r.db.QueryContext(ctx, "CALL sp_get_user_sessions(?, ?)", userID, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []models.SessionInfo
+	for rows.Next() {
+		var s models.SessionInfo
+		err := rows.Scan(
+			&s.ID, &s.UserID, &s.CompanyID, &s.DeviceInfo, &s.IPAddress,
+			&s.ExpiresAt, &s.AbsoluteExpiresAt, &s.CreatedAt, &s.LastActivityAt,
+			&s.CompanyName,
+		)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+func (r *SessionRepo) Revoke(ctx context.Context, sessionID, userID string, meta *models.RequestMeta) error {
+	_, err := r.db.ExecContext(ctx,
+		"CALL sp_revoke_session(?, ?, ?, ?, ?, ?)",
+		sessionID, userID,
+		meta.UserID, meta.SessionID, meta.IPAddress, meta.UserAgent,
+	)
+	return err
+}
+
+// Refresh rotates the access and refresh tokens of the session owning refreshTokenHash.
+// Presenting an already-rotated refresh token revokes the session.
+func (r *SessionRepo) Refresh(ctx context.Context, params *RefreshParams) (*RefreshResult, error) {
+	row := r.db.QueryRowContext(ctx,
+		"CALL sp_refresh_session(?, ?, ?, ?, ?, ?, ?, ?)",
+		params.RefreshTokenHash, params.NewRefreshID, params.NewRefreshTokenHash,
+		params.NewTokenHash, params.AccessMinutes, params.IdleMinutes,
+		params.IPAddress, params.UserAgent,
+	)
+
+	var res RefreshResult
+	var sessionID sql.NullString
+	var expiresAt, absoluteExpiresAt sql.NullTime
+	if err := row.Scan(&res.Status, &sessionID, &expiresAt, &absoluteExpiresAt); err != nil {
+		return nil, err
+	}
+	res.SessionID = sessionID.String
+	res.ExpiresAt = expiresAt.Time
+	res.AbsoluteExpiresAt = absoluteExpiresAt.Time
+	return &res, nil
+}
+
+// SessionParams contains all data needed to open a session
+type SessionParams struct {
+	ID                string
+	LoginID           *string // nil starts a new login
+	TokenHash         string
+	UserID            string
+	CompanyID         string
+	DeviceInfo        string
+	IPAddress         string
+	ExpiresAt         time.Time
+	AbsoluteExpiresAt time.Time
+
+	RefreshID        string
+	RefreshTokenHash string
+}
+
+// RefreshParams contains the presented refresh token and its replacements
+type RefreshParams struct {
+	RefreshTokenHash    string
+	NewRefreshID        string
+	NewRefreshTokenHash string
+	NewTokenHash        string
+	AccessMinutes       int
+	IdleMinutes         int
+	IPAddress           string
+	UserAgent           string
+}
+
+// Refresh statuses returned by sp_refresh_session
+const (
+	RefreshOK      = "ok"
+	RefreshInvalid = "invalid"
+	RefreshExpired = "expired"
+	RefreshReused  = "reused"
+)
+
+type RefreshResult struct {
+	Status            string
+	SessionID         string
+	ExpiresAt         time.Time
+	AbsoluteExpiresAt time.Time
+}
@@ -3,18 +3,29 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"time"
 
-	"lettersheets/internal/models"
+	"lettersheets/models"
 )
 
 type UserRepo struct {
 	db *sql.DB
 }
 
-func (r *UserRepo) ResetPasswordWithKey(ctx context.Context, userID, passwordHash, salt, wrappedCompanyKey, keyWrapAlgorithm, publicKey, ipAddress, userAgent string) error {
-	_, err := r.db.ExecContext(ctx,
-		"CALL sp_reset_password_with_key(?, ?, ?, ?, ?, ?, ?, ?)",
-		userID, passwordHash, salt, wrappedCompanyKey, keyWrapAlgorithm, publicKey, ipAddress, userAgent,
+// ResetPasswordWithKey sets a new password together with the company keys
+// re-wrapped under it, and ends all of the user's sessions. Each key must
+// match an active access row at its current key version; active companies
+// without a key are marked as lost.
+func (r *UserRepo) ResetPasswordWithKey(ctx context.Context, userID, passwordHash, salt string, keys []models.WrappedKey, publicKey []byte, ipAddress, userAgent string) error {
+	wrapped, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx,
+		"CALL sp_reset_password_with_key(?, ?, ?, ?, ?, ?, ?)",
+		userID, passwordHash, salt, string(wrapped), publicKey, ipAddress, userAgent,
 	)
 	return err
 }
@@ -38,7 +49,7 @@ func (r *UserRepo) GetByID(ctx context.Context, id string) (*models.User, error)
 	var u models.User
 	err := row.Scan(
 		&u.ID, &u.Email, &u.Username, &u.IsActive,
-		&u.LastLoginAt, &u.PasswordChangedAt,
+		&u.LastLoginAt, &u.PasswordChangedAt, &u.TOTPEnabledAt,
 		&u.CreatedAt, &u.UpdatedAt,
 	)
 	if err == sql.ErrNoRows {
@@ -77,23 +88,118 @@ func (r *UserRepo) Update(ctx context.Context, user *models.User, meta *models.R
 	return err
 }
 
-func (r *UserRepo) ChangePassword(ctx context.Context, userID, passwordHash, salt string, meta *models.RequestMeta) error {
-	_, err := r.db.ExecContext(ctx,
-		"CALL sp_change_password(?, ?, ?, ?, ?, ?, ?)",
-		userID, passwordHash, salt,
+// ChangePassword stores the new password and the re-wrapped company keys
+// atomically. keys must cover exactly the companies the user can access.
+func (r *UserRepo) ChangePassword(ctx context.Context, userID, passwordHash, salt string, keys []models.WrappedKey, meta *models.RequestMeta) error {
+	wrapped, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx,
+		"CALL sp_change_password(?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, passwordHash, salt, string(wrapped),
 		meta.CompanyID, meta.SessionID, meta.IPAddress, meta.UserAgent,
 	)
 	return err
 }
 
-func (r *UserRepo) Delete(ctx context.Context, id string, meta *models.RequestMeta) error {
-	_, err := r.db.ExecContext(ctx,
+// RehashPassword replaces the stored hash of an unchanged password, unless
+// the password was changed since oldHash was read
+func (r *UserRepo) RehashPassword(ctx context.Context, userID, oldHash, newHash string) error {
+	_, err := r.db.ExecContext(ctx, "CALL sp_rehash_password(?, ?, ?)", userID, oldHash, newHash)
+	return err
+}
+
+// RemoveFromCompany ends a user's access to the scoped company and their
+// sessions in it. Users are global, so the user itself stays active.
+func (r *UserRepo) RemoveFromCompany(ctx context.Context, scope Scope, id string, meta *models.RequestMeta) error {
+	if err := scope.check(); err != nil {
+		return err
+	}
+	row := r.db.QueryRowContext(ctx,
 		"CALL sp_delete_user(?, ?, ?, ?, ?, ?)",
-		id, meta.CompanyID, meta.UserID, meta.SessionID, meta.IPAddress, meta.UserAgent,
+		id, scope.companyID, meta.UserID, meta.SessionID, meta.IPAddress, meta.UserAgent,
+	)
+	return foundOrNotFound(row)
+}
+
+// ==================== LOGIN ATTEMPTS ====================
+
+func (r *UserRepo) RecordLoginAttempt(ctx context.Context, a *models.LoginAttempt) error {
+	_, err := r.db.ExecContext(ctx,
+		"CALL sp_record_login_attempt(?, ?, ?, ?, ?, ?, ?)",
+		a.ID, a.UserID, a.Email, a.Outcome, a.Reason, a.IPAddress, a.UserAgent,
 	)
 	return err
 }
 
+// GetLoginHistory returns the user's attempts, newest first, provided the
+// user has or had access to the scoped company
+func (r *UserRepo) GetLoginHistory(ctx context.Context, scope Scope, userID string, limit, offset int) ([]models.LoginAttempt, error) {
+	if err := scope.check(); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx,
+		"CALL sp_get_login_history(?, ?, ?, ?)",
+		userID, scope.companyID, limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []models.LoginAttempt{}
+	for rows.Next() {
+		var a models.LoginAttempt
+		err := rows.Scan(
+			&a.ID, &a.UserID, &a.Email, &a.Outcome, &a.Reason,
+			&a.IPAddress, &a.UserAgent, &a.AttemptedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, a)
+	}
+	return result, rows.Err()
+}
+
+// GetLockState returns the failure counter and lockout of a user in the
+// scoped company; found is false when the user has never had access to it
+func (r *UserRepo) GetLockState(ctx context.Context, scope Scope, userID string) (attempts int, lockedUntil *time.Time, found bool, err error) {
+	if err := scope.check(); err != nil {
+		return 0, nil, false, err
+	}
+	row := r.db.QueryRowContext(ctx, "CALL sp_get_user_lock_state(?, ?)", userID, scope.companyID)
+
+	err = row.Scan(&attempts, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return 0, nil, false, nil
+	}
+	if err != nil {
+		return 0, nil, false, err
+	}
+	return attempts, lockedUntil, true, nil
+}
+
+// Unlock clears the lockout of a user with active access to the scoped
+// company; false means no such user
+func (r *UserRepo) Unlock(ctx context.Context, scope Scope, userID string, meta *models.RequestMeta) (bool, error) {
+	if err := scope.check(); err != nil {
+		return false, err
+	}
+	row := r.db.QueryRowContext(ctx,
+		"CALL sp_unlock_user(?, ?, ?, ?, ?, ?)",
+		userID, scope.companyID, meta.UserID, meta.SessionID, meta.IPAddress, meta.UserAgent,
+	)
+
+	var unlocked bool
+	if err := row.Scan(&unlocked); err != nil {
+		return false, err
+	}
+	return unlocked, nil
+}
+
 func (r *UserRepo) LoginSuccess(ctx context.Context, userID string) error {
 	_, err := r.db.ExecContext(ctx, "CALL sp_login_success(?)", userID)
 	return err
@@ -106,3 +212,86 @@ func (r *UserRepo) LoginFailure(ctx context.Context, userID string, maxAttempts,
 	)
 	return err
 }
+
+// ==================== TOTP ====================
+
+// GetTOTPState returns the encrypted TOTP secrets; SecretEnc is nil unless enrollment was confirmed
+func (r *UserRepo) GetTOTPState(ctx context.Context, userID string) (*models.User, error) {
+	row := r.db.QueryRowContext(ctx, "CALL sp_get_totp_state(?)", userID)
+
+	u := models.User{ID: userID}
+	err := row.Scan(&u.TOTPSecretEnc, &u.TOTPPendingEnc, &u.TOTPEnabledAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (r *UserRepo) SetTOTPPending(ctx context.Context, userID string, pendingEnc []byte) error {
+	_, err := r.db.ExecContext(ctx, "CALL sp_set_totp_pending(?, ?)", userID, pendingEnc)
+	return err
+}
+
+func (r *UserRepo) EnableTOTP(ctx context.Context, userID string, counter int64, meta *models.RequestMeta) error {
+	_, err := r.db.ExecContext(ctx,
+		"CALL sp_enable_totp(?, ?, ?, ?, ?, ?)",
+		userID, counter,
+		meta.CompanyID, meta.SessionID, meta.IPAddress, meta.UserAgent,
+	)
+	return err
+}
+
+func (r *UserRepo) DisableTOTP(ctx context.Context, userID string, meta *models.RequestMeta) error {
+	_, err := r.db.ExecContext(ctx,
+		"CALL sp_disable_totp(?, ?, ?, ?, ?)",
+		userID,
+		meta.CompanyID, meta.SessionID, meta.IPAddress, meta.UserAgent,
+	)
+	return err
+}
+
+// UseTOTPCounter records a used time step; false means the code was already used
+func (r *UserRepo) UseTOTPCounter(ctx context.Context, userID string, counter int64) (bool, error) {
+	row := r.db.QueryRowContext(ctx, "CALL sp_use_totp_counter(?, ?)", userID, counter)
+
+	var accepted int64
+	if err := row.Scan(&accepted); err != nil {
+		return false, err
+	}
+	return accepted > 0, nil
+}
+
+// ==================== BACKUP CODES ====================
+
+// ReplaceBackupCodes discards all existing backup codes and stores the given hashes
+func (r *UserRepo) ReplaceBackupCodes(ctx context.Context, userID string, codeHashes []string, meta *models.RequestMeta) error {
+	hashes, err := json.Marshal(codeHashes)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx,
+		"CALL sp_replace_backup_codes(?, ?, ?, ?, ?, ?)",
+		userID, string(hashes),
+		meta.CompanyID, meta.SessionID, meta.IPAddress, meta.UserAgent,
+	)
+	return err
+}
+
+// UseBackupCode consumes an unused backup code and reports how many remain
+func (r *UserRepo) UseBackupCode(ctx context.Context, userID, codeHash, ipAddress, userAgent string) (bool, int, error) {
+	row := r.db.QueryRowContext(ctx,
+		"CALL sp_use_backup_code(?, ?, ?, ?)",
+		userID, codeHash, ipAddress, userAgent,
+	)
+
+	var accepted bool
+	var remaining int
+	if err := row.Scan(&accepted, &remaining); err != nil {
+		return false, 0, err
+	}
+	return accepted, remaining, nil
+}
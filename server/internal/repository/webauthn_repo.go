@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"lettersheets/models"
+)
+
+type WebAuthnRepo struct {
+	db *sql.DB
+}
+
+func NewWebAuthnRepo(db *sql.DB) *WebAuthnRepo {
+	return &WebAuthnRepo{db: db}
+}
+
+// ==================== CHALLENGES ====================
+
+// CreateChallenge stores a challenge hash; userID is empty for passwordless login
+func (r *WebAuthnRepo) CreateChallenge(ctx context.Context, id, userID, challengeHash, purpose string, expiresAt time.Time) error {
+	var user *string
+	if userID != "" {
+		user = &userID
+	}
+
+	_, err := r.db.ExecContext(ctx,
+		"CALL sp_create_webauthn_challenge(?, ?, ?, ?, ?)",
+		id, user, challengeHash, purpose, expiresAt,
+	)
+	return err
+}
+
+// ConsumeChallenge marks the challenge as used. found is false when it is
+// invalid, expired, already used, or was issued for a different purpose;
+// userID is empty for challenges not bound to a user.
+func (r *WebAuthnRepo) ConsumeChallenge(ctx context.Context, challengeHash, purpose string) (found bool, userID string, err error) {
+	row := r.db.QueryRowContext(ctx, "CALL sp_consume_webauthn_challenge(?, ?)", challengeHash, purpose)
+
+	var user sql.NullString
+	if err := row.Scan(&found, &user); err != nil {
+		return false, "", err
+	}
+	return found, user.String, nil
+}
+
+// ==================== CREDENTIALS ====================
+
+func (r *WebAuthnRepo) CreateCredential(ctx context.Context, c *models.WebAuthnCredential, meta *models.RequestMeta) error {
+	_, err := r.db.ExecContext(ctx,
+		"CALL sp_create_webauthn_credential(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		c.ID, c.UserID, c.CredentialID, c.PublicKey, c.SignCount, c.AAGUID,
+		c.Name, c.Transports,
+		meta.CompanyID, meta.SessionID, meta.IPAddress, meta.UserAgent,
+	)
+	return err
+}
+
+// GetCredential looks up a credential by its authenticator-assigned ID
+func (r *WebAuthnRepo) GetCredential(ctx context.Context, credentialID []byte) (*models.WebAuthnCredential, error) {
+	row := r.db.QueryRowContext(ctx, "CALL sp_get_webauthn_credential(?)", credentialID)
+
+	var c models.WebAuthnCredential
+	err := row.Scan(
+		&c.ID, &c.UserID, &c.CredentialID, &c.PublicKey, &c.SignCount,
+		&c.Name, &c.Transports, &c.CreatedAt, &c.LastUsedAt, &c.Email,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *WebAuthnRepo) ListByUser(ctx context.Context, userID string) ([]models.WebAuthnCredential, error) {
+	rows, err := r.db.QueryContext(ctx, "CALL sp_get_user_webauthn_credentials(?)", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []models.WebAuthnCredential
+	for rows.Next() {
+		var c models.WebAuthnCredential
+		err := rows.Scan(
+			&c.ID, &c.UserID, &c.CredentialID, &c.PublicKey, &c.SignCount,
+			&c.Name, &c.Transports, &c.CreatedAt, &c.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
+
+// UseCredential stores the new signature counter; false means the counter
+// did not increase and the assertion must be rejected
+func (r *WebAuthnRepo) UseCredential(ctx context.Context, id string, signCount uint32) (bool, error) {
+	row := r.db.QueryRowContext(ctx, "CALL sp_use_webauthn_credential(?, ?)", id, signCount)
+
+	var accepted bool
+	if err := row.Scan(&accepted); err != nil {
+		return false, err
+	}
+	return accepted, nil
+}
+
+// DeleteCredential removes one of the user's credentials; false means it was not found
+func (r *WebAuthnRepo) DeleteCredential(ctx context.Context, id, userID string, meta *models.RequestMeta) (bool, error) {
+	row := r.db.QueryRowContext(ctx,
+		"CALL sp_delete_webauthn_credential(?, ?, ?, ?, ?, ?)",
+		id, userID,
+		meta.CompanyID, meta.SessionID, meta.IPAddress, meta.UserAgent,
+	)
+
+	var deleted bool
+	if err := row.Scan(&deleted); err != nil {
+		return false, err
+	}
+	return deleted, nil
+}
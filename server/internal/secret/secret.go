@@ -0,0 +1,65 @@
+// Package secret encrypts server-held secrets (such as TOTP seeds) at rest
+// with AES-256-GCM under the server key from config.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+var ErrNoKey = errors.New("server secret key is not configured")
+
+// ParseKey decodes a hex-encoded 32-byte key
+func ParseKey(hexKey string) ([]byte, error) {
+	if hexKey == "" {
+		return nil, ErrNoKey
+	}
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server secret key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("server secret key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext; aad binds the ciphertext to its owner (e.g. a user ID)
+func Seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// Open decrypts a value produced by Seal with the same aad
+func Open(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed value too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
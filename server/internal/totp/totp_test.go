@@ -0,0 +1,82 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the RFC 6238 appendix B test vectors
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCodeRFC6238(t *testing.T) {
+	// The RFC lists 8-digit codes; 6-digit codes are their last 6 digits
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, c := range cases {
+		if got := Code(rfcSecret, Counter(time.Unix(c.unix, 0))); got != c.code {
+			t.Errorf("T = %d: code = %s, want %s", c.unix, got, c.code)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Counter(now)
+
+	cases := []struct {
+		name   string
+		offset int64
+		skew   int
+		ok     bool
+	}{
+		{"current step", 0, 0, true},
+		{"previous step without skew", -1, 0, false},
+		{"previous step", -1, 1, true},
+		{"next step", 1, 1, true},
+		{"two steps behind", -2, 1, false},
+		{"two steps ahead", 2, 1, false},
+		{"two steps behind, skew 2", -2, 2, true},
+	}
+	for _, c := range cases {
+		got, ok := Validate(rfcSecret, Code(rfcSecret, step+c.offset), now, c.skew)
+		if ok != c.ok {
+			t.Errorf("%s: ok = %v, want %v", c.name, ok, c.ok)
+			continue
+		}
+		// The matched step is what callers store to refuse the code again
+		if ok && got != step+c.offset {
+			t.Errorf("%s: step = %d, want %d", c.name, got, step+c.offset)
+		}
+	}
+
+	for _, code := range []string{"", "05047", "0050471", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now, 1); ok {
+			t.Errorf("accepted %q", code)
+		}
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	u, err := url.Parse(ProvisioningURI(rfcSecret, "Lettersheets", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Lettersheets:ann@example.com" {
+		t.Fatalf("URI = %s", u)
+	}
+	q := u.Query()
+	if q.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || q.Get("issuer") != "Lettersheets" ||
+		q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Fatalf("query = %v", q)
+	}
+}
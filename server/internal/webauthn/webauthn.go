@@ -0,0 +1,377 @@
+// Package webauthn implements the relying-party side of WebAuthn
+// registration and assertion ceremonies for passkey login.
+//
+// Attestation statements are not verified: registration requests
+// attestation "none", which is what platform and software
+// authenticators return for it.
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// COSE algorithm identifiers
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+const ChallengeSize = 32
+
+var (
+	ErrChallengeMismatch = errors.New("webauthn: challenge mismatch")
+	ErrOriginMismatch    = errors.New("webauthn: origin not allowed")
+	ErrRPIDMismatch      = errors.New("webauthn: relying party ID mismatch")
+	ErrUserNotPresent    = errors.New("webauthn: user presence flag not set")
+	ErrUserNotVerified   = errors.New("webauthn: user verification required")
+	ErrBadSignature      = errors.New("webauthn: signature verification failed")
+	ErrCloned            = errors.New("webauthn: signature counter did not increase, authenticator may be cloned")
+)
+
+// RelyingParty identifies this server to authenticators
+type RelyingParty struct {
+	ID      string   // effective domain, e.g. "app.example.com"
+	Name    string   // display name
+	Origins []string // allowed origins, e.g. "https://app.example.com"
+}
+
+// Bytes is a byte slice carried as base64url in JSON, as browsers'
+// PublicKeyCredential.toJSON() produce
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return fmt.Errorf("webauthn: invalid base64url: %w", err)
+	}
+	*b = decoded
+	return nil
+}
+
+// NewChallenge returns a random challenge
+func NewChallenge() ([]byte, error) {
+	c := make([]byte, ChallengeSize)
+	if _, err := rand.Read(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ClientChallenge extracts the challenge echoed in clientDataJSON so the
+// server can look up the challenge it issued. The value is untrusted until
+// the ceremony has been verified against it.
+func ClientChallenge(clientDataJSON []byte) ([]byte, error) {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return nil, fmt.Errorf("webauthn: invalid clientDataJSON: %w", err)
+	}
+	c, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(cd.Challenge, "="))
+	if err != nil || len(c) == 0 {
+		return nil, ErrChallengeMismatch
+	}
+	return c, nil
+}
+
+// ==================== CEREMONY PAYLOADS ====================
+
+// AttestationResponse is the client's answer to navigator.credentials.create()
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes    `json:"clientDataJSON"`
+		AttestationObject Bytes    `json:"attestationObject"`
+		Transports        []string `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the client's answer to navigator.credentials.get()
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes `json:"clientDataJSON"`
+		AuthenticatorData Bytes `json:"authenticatorData"`
+		Signature         Bytes `json:"signature"`
+		UserHandle        Bytes `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// Credential is what must be stored after a successful registration
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE_Key
+	SignCount uint32
+	AAGUID    []byte
+}
+
+// ==================== REGISTRATION ====================
+
+// VerifyRegistration checks an attestation response against the challenge
+// that was issued and returns the new credential
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, resp *AttestationResponse, requireUV bool) (*Credential, error) {
+	if resp.Type != "public-key" {
+		return nil, errors.New("webauthn: unexpected credential type")
+	}
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	obj, _, err := decodeCBOR(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: invalid attestation object: %w", err)
+	}
+	att, ok := obj.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("webauthn: invalid attestation object")
+	}
+	authData, ok := att["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("webauthn: missing authData")
+	}
+
+	ad, err := rp.parseAuthData(authData, requireUV)
+	if err != nil {
+		return nil, err
+	}
+	if ad.flags&flagAttestedData == 0 {
+		return nil, errors.New("webauthn: missing attested credential data")
+	}
+
+	rest := ad.rest
+	if len(rest) < 18 {
+		return nil, errors.New("webauthn: truncated attested credential data")
+	}
+	aaguid := rest[:16]
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLen {
+		return nil, errors.New("webauthn: truncated credential ID")
+	}
+	credID := rest[:idLen]
+	rest = rest[idLen:]
+
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: invalid credential public key: %w", err)
+	}
+	coseKey := rest[:len(rest)-len(after)]
+	if _, _, err := parseCOSEKey(coseKey); err != nil {
+		return nil, err
+	}
+
+	if len(resp.RawID) > 0 && !bytes.Equal(resp.RawID, credID) {
+		return nil, errors.New("webauthn: credential ID mismatch")
+	}
+
+	return &Credential{
+		ID:        append([]byte(nil), credID...),
+		PublicKey: append([]byte(nil), coseKey...),
+		SignCount: ad.signCount,
+		AAGUID:    append([]byte(nil), aaguid...),
+	}, nil
+}
+
+// ==================== ASSERTION ====================
+
+// VerifyAssertion checks an assertion made with a stored credential and
+// returns the authenticator's new signature counter
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, cred *Credential, resp *AssertionResponse, requireUV bool) (uint32, error) {
+	if resp.Type != "public-key" {
+		return 0, errors.New("webauthn: unexpected credential type")
+	}
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	ad, err := rp.parseAuthData(resp.Response.AuthenticatorData, requireUV)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte(nil), resp.Response.AuthenticatorData...), clientDataHash[:]...)
+
+	pub, alg, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	if err := verifySignature(pub, alg, signed, resp.Response.Signature); err != nil {
+		return 0, err
+	}
+
+	// Counters of zero mean the authenticator does not implement them
+	if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		return 0, ErrCloned
+	}
+	return ad.signCount, nil
+}
+
+// ==================== HELPERS ====================
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, wantType string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return fmt.Errorf("webauthn: invalid clientDataJSON: %w", err)
+	}
+	if cd.Type != wantType {
+		return fmt.Errorf("webauthn: unexpected client data type %q", cd.Type)
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(cd.Challenge, "="))
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrChallengeMismatch
+	}
+
+	for _, o := range rp.Origins {
+		if cd.Origin == o {
+			return nil
+		}
+	}
+	return ErrOriginMismatch
+}
+
+type authData struct {
+	flags     byte
+	signCount uint32
+	rest      []byte
+}
+
+func (rp *RelyingParty) parseAuthData(data []byte, requireUV bool) (*authData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("webauthn: authenticator data too short")
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(data[:32], rpIDHash[:]) != 1 {
+		return nil, ErrRPIDMismatch
+	}
+
+	ad := &authData{
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+		rest:      data[37:],
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return nil, ErrUserNotPresent
+	}
+	if requireUV && ad.flags&flagUserVerified == 0 {
+		return nil, ErrUserNotVerified
+	}
+	return ad, nil
+}
+
+// parseCOSEKey decodes a COSE_Key into a Go public key
+func parseCOSEKey(raw []byte) (crypto.PublicKey, int64, error) {
+	v, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, 0, fmt.Errorf("webauthn: invalid COSE key: %w", err)
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, errors.New("webauthn: invalid COSE key")
+	}
+
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+
+	switch {
+	case kty == 2 && alg == AlgES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, errors.New("webauthn: invalid P-256 key")
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, errors.New("webauthn: P-256 point not on curve")
+		}
+		return pub, alg, nil
+
+	case kty == 1 && alg == AlgEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("webauthn: invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), alg, nil
+
+	case kty == 3 && alg == AlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errors.New("webauthn: invalid RSA key")
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, alg, nil
+	}
+
+	return nil, 0, fmt.Errorf("webauthn: unsupported key type %d / algorithm %d", kty, alg)
+}
+
+func verifySignature(pub crypto.PublicKey, alg int64, signed, sig []byte) error {
+	switch alg {
+	case AlgES256:
+		digest := sha256.Sum256(signed)
+		if ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], sig) {
+			return nil
+		}
+	case AlgEdDSA:
+		if ed25519.Verify(pub.(ed25519.PublicKey), signed, sig) {
+			return nil
+		}
+	case AlgRS256:
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], sig) == nil {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
@@ -0,0 +1,378 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+const testOrigin = "https://app.example.com"
+
+func testRP() *RelyingParty {
+	return &RelyingParty{ID: "app.example.com", Name: "Lettersheets", Origins: []string{testOrigin}}
+}
+
+// authenticator is a software passkey: it answers create() and get() the
+// way a browser would hand them to the server, as JSON
+type authenticator struct {
+	key    crypto.Signer
+	credID []byte
+	rpID   string
+	origin string
+	flags  byte
+	count  uint32
+}
+
+func newAuthenticator(t *testing.T, alg int64) *authenticator {
+	t.Helper()
+	a := &authenticator{
+		credID: []byte(fmt.Sprintf("credential-%d", -alg)),
+		rpID:   "app.example.com",
+		origin: testOrigin,
+		flags:  flagUserPresent | flagUserVerified,
+	}
+	var err error
+	switch alg {
+	case AlgES256:
+		a.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, a.key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func (a *authenticator) coseKey() []byte {
+	switch pub := a.key.Public().(type) {
+	case *ecdsa.PublicKey:
+		x, y := make([]byte, 32), make([]byte, 32)
+		pub.X.FillBytes(x)
+		pub.Y.FillBytes(y)
+		return cborEncode(map[int64]interface{}{1: 2, 3: AlgES256, -1: 1, -2: x, -3: y})
+	case ed25519.PublicKey:
+		return cborEncode(map[int64]interface{}{1: 1, 3: AlgEdDSA, -1: 6, -2: []byte(pub)})
+	}
+	panic("unsupported key")
+}
+
+func (a *authenticator) authData(attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], a.flags)
+	data = binary.BigEndian.AppendUint32(data, a.count)
+	return append(data, attested...)
+}
+
+func (a *authenticator) clientData(typ string, challenge []byte) []byte {
+	raw, _ := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.origin,
+	})
+	return raw
+}
+
+func (a *authenticator) create(t *testing.T, challenge []byte) *AttestationResponse {
+	t.Helper()
+	attested := make([]byte, 16) // AAGUID of zeros
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credID)))
+	attested = append(append(attested, a.credID...), a.coseKey()...)
+	a.flags |= flagAttestedData
+	authData := a.authData(attested)
+	a.flags &^= flagAttestedData
+
+	return roundTrip[AttestationResponse](t, map[string]interface{}{
+		"id": base64.RawURLEncoding.EncodeToString(a.credID), "rawId": Bytes(a.credID), "type": "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON": Bytes(a.clientData("webauthn.create", challenge)),
+			"attestationObject": Bytes(cborEncode(map[string]interface{}{
+				"fmt": "none", "attStmt": map[string]interface{}{}, "authData": authData,
+			})),
+		},
+	})
+}
+
+func (a *authenticator) get(t *testing.T, challenge []byte) *AssertionResponse {
+	t.Helper()
+	a.count++
+	authData := a.authData(nil)
+	clientData := a.clientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+
+	var sig []byte
+	var err error
+	switch key := a.key.(type) {
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(signed)
+		sig, err = ecdsa.SignASN1(rand.Reader, key, digest[:])
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(key, signed)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return roundTrip[AssertionResponse](t, map[string]interface{}{
+		"id": base64.RawURLEncoding.EncodeToString(a.credID), "rawId": Bytes(a.credID), "type": "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    Bytes(clientData),
+			"authenticatorData": Bytes(authData),
+			"signature":         Bytes(sig),
+		},
+	})
+}
+
+// roundTrip passes a response through JSON as the API receives it
+func roundTrip[T any](t *testing.T, v interface{}) *T {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(T)
+	if err := json.Unmarshal(raw, out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// cborEncode writes the subset of CBOR authenticators use, with map keys
+// in the canonical order
+func cborEncode(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		}
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	entries := func(keys [][]byte, values [][]byte) []byte {
+		order := make([]int, len(keys))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(i, j int) bool {
+			a, b := keys[order[i]], keys[order[j]]
+			return len(a) < len(b) || len(a) == len(b) && bytes.Compare(a, b) < 0
+		})
+		out := head(5, uint64(len(keys)))
+		for _, i := range order {
+			out = append(append(out, keys[i]...), values[i]...)
+		}
+		return out
+	}
+
+	switch v := v.(type) {
+	case int:
+		return cborEncode(int64(v))
+	case int64:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[int64]interface{}:
+		var keys, values [][]byte
+		for k, val := range v {
+			keys, values = append(keys, cborEncode(k)), append(values, cborEncode(val))
+		}
+		return entries(keys, values)
+	case map[string]interface{}:
+		var keys, values [][]byte
+		for k, val := range v {
+			keys, values = append(keys, cborEncode(k)), append(values, cborEncode(val))
+		}
+		return entries(keys, values)
+	}
+	panic(fmt.Sprintf("cborEncode: unsupported %T", v))
+}
+
+func TestCeremonies(t *testing.T) {
+	for _, alg := range []int64{AlgES256, AlgEdDSA} {
+		t.Run(fmt.Sprint(alg), func(t *testing.T) {
+			rp := testRP()
+			a := newAuthenticator(t, alg)
+
+			challenge, _ := NewChallenge()
+			cred, err := rp.VerifyRegistration(challenge, a.create(t, challenge), true)
+			if err != nil {
+				t.Fatalf("registration: %v", err)
+			}
+			if !bytes.Equal(cred.ID, a.credID) || !bytes.Equal(cred.PublicKey, a.coseKey()) || len(cred.AAGUID) != 16 {
+				t.Fatalf("registered credential = %+v", cred)
+			}
+
+			for i := 1; i <= 2; i++ {
+				challenge, _ := NewChallenge()
+				resp := a.get(t, challenge)
+				got, err := ClientChallenge(resp.Response.ClientDataJSON)
+				if err != nil || !bytes.Equal(got, challenge) {
+					t.Fatalf("ClientChallenge = %x, %v", got, err)
+				}
+				count, err := rp.VerifyAssertion(challenge, cred, resp, true)
+				if err != nil {
+					t.Fatalf("assertion %d: %v", i, err)
+				}
+				if count != uint32(i) {
+					t.Fatalf("assertion %d: counter = %d", i, count)
+				}
+				cred.SignCount = count
+			}
+		})
+	}
+}
+
+func TestRegistrationFailures(t *testing.T) {
+	cases := []struct {
+		name      string
+		setup     func(a *authenticator)
+		mutate    func(resp *AttestationResponse, challenge []byte)
+		requireUV bool
+		want      error
+	}{
+		{name: "wrong origin", setup: func(a *authenticator) { a.origin = "https://evil.example.com" }, want: ErrOriginMismatch},
+		{name: "wrong RP ID hash", setup: func(a *authenticator) { a.rpID = "evil.example.com" }, want: ErrRPIDMismatch},
+		{name: "missing UP", setup: func(a *authenticator) { a.flags = flagUserVerified }, want: ErrUserNotPresent},
+		{name: "missing UV", setup: func(a *authenticator) { a.flags = flagUserPresent }, requireUV: true, want: ErrUserNotVerified},
+		{name: "other challenge", mutate: func(resp *AttestationResponse, challenge []byte) {
+			challenge[0] ^= 1
+		}, want: ErrChallengeMismatch},
+		{name: "assertion instead of attestation", mutate: func(resp *AttestationResponse, challenge []byte) {
+			resp.Response.ClientDataJSON = bytes.Replace(resp.Response.ClientDataJSON, []byte("webauthn.create"), []byte("webauthn.get"), 1)
+		}},
+		{name: "truncated attestation object", mutate: func(resp *AttestationResponse, challenge []byte) {
+			resp.Response.AttestationObject = resp.Response.AttestationObject[:len(resp.Response.AttestationObject)-1]
+		}},
+		{name: "credential ID mismatch", mutate: func(resp *AttestationResponse, challenge []byte) {
+			resp.RawID = []byte("another")
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := newAuthenticator(t, AlgES256)
+			if c.setup != nil {
+				c.setup(a)
+			}
+			challenge, _ := NewChallenge()
+			resp := a.create(t, challenge)
+			if c.mutate != nil {
+				c.mutate(resp, challenge)
+			}
+			_, err := testRP().VerifyRegistration(challenge, resp, c.requireUV)
+			if err == nil || c.want != nil && !errors.Is(err, c.want) {
+				t.Fatalf("err = %v, want %v", err, c.want)
+			}
+		})
+	}
+}
+
+func TestAssertionFailures(t *testing.T) {
+	cases := []struct {
+		name      string
+		setup     func(a *authenticator, cred *Credential)
+		mutate    func(resp *AssertionResponse)
+		requireUV bool
+		want      error
+	}{
+		{name: "bad signature", mutate: func(resp *AssertionResponse) {
+			resp.Response.Signature[len(resp.Response.Signature)-1] ^= 1
+		}, want: ErrBadSignature},
+		{name: "signed other data", mutate: func(resp *AssertionResponse) {
+			resp.Response.AuthenticatorData[len(resp.Response.AuthenticatorData)-1]++
+		}, want: ErrBadSignature},
+		{name: "wrong origin", setup: func(a *authenticator, cred *Credential) { a.origin = "https://app.example.com.evil.net" }, want: ErrOriginMismatch},
+		{name: "wrong RP ID hash", setup: func(a *authenticator, cred *Credential) { a.rpID = "example.com" }, want: ErrRPIDMismatch},
+		{name: "missing UP", setup: func(a *authenticator, cred *Credential) { a.flags = 0 }, want: ErrUserNotPresent},
+		{name: "missing UV", setup: func(a *authenticator, cred *Credential) { a.flags = flagUserPresent }, requireUV: true, want: ErrUserNotVerified},
+		{name: "counter regression", setup: func(a *authenticator, cred *Credential) {
+			a.count = 4
+			cred.SignCount = 7
+		}, want: ErrCloned},
+		{name: "counter repeated", setup: func(a *authenticator, cred *Credential) {
+			a.count = 6
+			cred.SignCount = 7
+		}, want: ErrCloned},
+		{name: "counter reset to zero", setup: func(a *authenticator, cred *Credential) {
+			a.count = ^uint32(0) // get() wraps it to 0
+			cred.SignCount = 7
+		}, want: ErrCloned},
+		{name: "short authenticator data", mutate: func(resp *AssertionResponse) {
+			resp.Response.AuthenticatorData = resp.Response.AuthenticatorData[:36]
+		}},
+		{name: "key of another credential", setup: func(a *authenticator, cred *Credential) {
+			cred.PublicKey = newAuthenticator(t, AlgEdDSA).coseKey()
+		}, want: ErrBadSignature},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := newAuthenticator(t, AlgES256)
+			cred := &Credential{ID: a.credID, PublicKey: a.coseKey()}
+			if c.setup != nil {
+				c.setup(a, cred)
+			}
+			challenge, _ := NewChallenge()
+			resp := a.get(t, challenge)
+			if c.mutate != nil {
+				c.mutate(resp)
+			}
+			_, err := testRP().VerifyAssertion(challenge, cred, resp, c.requireUV)
+			if err == nil || c.want != nil && !errors.Is(err, c.want) {
+				t.Fatalf("err = %v, want %v", err, c.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORLimits(t *testing.T) {
+	key := newAuthenticator(t, AlgES256).coseKey()
+	if _, _, err := parseCOSEKey(key); err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(key); n++ {
+		if _, _, err := parseCOSEKey(key[:n]); err == nil {
+			t.Fatalf("accepted a COSE key truncated to %d of %d bytes", n, len(key))
+		}
+	}
+
+	nested := func(depth int) []byte {
+		return append(bytes.Repeat([]byte{0x81}, depth), 0x00) // [[[...0]]]
+	}
+	if _, _, err := decodeCBOR(nested(maxCBORDepth)); err != nil {
+		t.Fatalf("nesting at the limit: %v", err)
+	}
+	for name, data := range map[string][]byte{
+		"too deep":               nested(maxCBORDepth + 1),
+		"deep maps":              append(bytes.Repeat([]byte{0xa1, 0x01}, 100), 0x00),
+		"array longer than data": {0x9a, 0xff, 0xff, 0xff, 0xff, 0x00},
+		"bytes longer than data": {0x5b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"indefinite length":      {0x9f, 0x00, 0xff},
+		"missing argument":       {0x19, 0x01},
+		"missing map value":      {0xa1, 0x01},
+		"integer overflow":       {0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"array map key":          {0xa1, 0x80, 0x00},
+	} {
+		if _, _, err := decodeCBOR(data); err == nil {
+			t.Errorf("%s: decoded % x", name, data)
+		}
+	}
+}
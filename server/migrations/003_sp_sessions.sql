@@ -0,0 +1,58 @@
+-- ============================================================
+-- STORED PROCEDURES: SESSION MANAGEMENT
+-- Listing and revoking individual devices
+-- ============================================================
+
+USE lettersheets;
+
+DELIMITER //
+
+-- ============================================================
+-- USER SESSION: READ (active sessions for a user)
+-- p_company_id NULL returns sessions across all companies
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_get_user_sessions//
+CREATE PROCEDURE sp_get_user_sessions(
+    IN p_user_id VARCHAR(36),
+    IN p_company_id VARCHAR(36)
+)
+BEGIN
+    SELECT s.id, s.user_id, s.company_id, s.device_info, s.ip_address,
+           s.expires_at, s.created_at, s.last_activity_at,
+           c.name AS company_name
+    FROM user_sessions s
+    INNER JOIN companies c ON c.id = s.company_id
+    WHERE s.user_id = p_user_id
+      AND (p_company_id IS NULL OR s.company_id = p_company_id)
+      AND s.is_active = 1
+      AND s.expires_at > NOW()
+    ORDER BY s.last_activity_at DESC;
+END//
+
+-- ============================================================
+-- USER SESSION: REVOKE (single device, logged)
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_revoke_session//
+CREATE PROCEDURE sp_revoke_session(
+    IN p_id VARCHAR(36),
+    IN p_user_id VARCHAR(36),
+    IN p_changed_by VARCHAR(36),
+    IN p_session_id VARCHAR(36),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    DECLARE v_company_id VARCHAR(36);
+
+    SELECT company_id INTO v_company_id
+    FROM user_sessions WHERE id = p_id AND user_id = p_user_id AND is_active = 1;
+
+    IF v_company_id IS NOT NULL THEN
+        UPDATE user_sessions SET is_active = 0
+        WHERE id = p_id AND user_id = p_user_id;
+
+        CALL sp_log_change(v_company_id, p_changed_by, p_session_id, 'user_sessions', p_id, 'delete', 'is_active', '1', '0', 0, p_ip_address, p_user_agent);
+    END IF;
+END//
+
+DELIMITER ;
@@ -0,0 +1,93 @@
+-- ============================================================
+-- AUTH TOKENS
+-- Short-lived, single-purpose tokens bound to a user
+-- Only the SHA-256 hash of the token is stored
+-- ============================================================
+
+USE lettersheets;
+
+CREATE TABLE IF NOT EXISTS user_auth_tokens (
+    id VARCHAR(36) NOT NULL PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+
+    token_hash CHAR(64) NOT NULL,
+    purpose VARCHAR(50) NOT NULL,
+
+    ip_address VARCHAR(45),
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE KEY uk_auth_tokens_hash (token_hash),
+    CONSTRAINT fk_auth_tokens_user FOREIGN KEY (user_id) REFERENCES users(id)
+) ENGINE=InnoDB;
+
+CREATE INDEX idx_auth_tokens_user ON user_auth_tokens(user_id, purpose);
+CREATE INDEX idx_auth_tokens_expires ON user_auth_tokens(expires_at);
+
+DELIMITER //
+
+-- ============================================================
+-- AUTH TOKEN: CREATE
+-- Also purges the user's expired tokens
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_create_auth_token//
+CREATE PROCEDURE sp_create_auth_token(
+    IN p_id VARCHAR(36),
+    IN p_user_id VARCHAR(36),
+    IN p_token_hash CHAR(64),
+    IN p_purpose VARCHAR(50),
+    IN p_ip_address VARCHAR(45),
+    IN p_expires_at DATETIME
+)
+BEGIN
+    DELETE FROM user_auth_tokens
+    WHERE user_id = p_user_id AND expires_at < NOW();
+
+    INSERT INTO user_auth_tokens (
+        id, user_id, token_hash, purpose, ip_address, expires_at, created_at
+    ) VALUES (
+        p_id, p_user_id, p_token_hash, p_purpose, p_ip_address, p_expires_at, NOW()
+    );
+END//
+
+-- ============================================================
+-- AUTH TOKEN: CONSUME
+-- Marks a valid token as used and returns its user_id
+-- Returns NULL when the token is unknown, expired, used, or for another purpose
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_consume_auth_token//
+CREATE PROCEDURE sp_consume_auth_token(
+    IN p_token_hash CHAR(64),
+    IN p_purpose VARCHAR(50)
+)
+BEGIN
+    DECLARE v_id VARCHAR(36);
+    DECLARE v_user_id VARCHAR(36);
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    SELECT id, user_id INTO v_id, v_user_id
+    FROM user_auth_tokens
+    WHERE token_hash = p_token_hash
+      AND purpose = p_purpose
+      AND used_at IS NULL
+      AND expires_at > NOW()
+    FOR UPDATE;
+
+    IF v_id IS NOT NULL THEN
+        UPDATE user_auth_tokens SET used_at = NOW() WHERE id = v_id;
+    END IF;
+
+    COMMIT;
+
+    SELECT v_user_id AS user_id;
+END//
+
+DELIMITER ;
@@ -0,0 +1,74 @@
+-- ============================================================
+-- HASHED SESSION TOKENS
+-- The bearer token is a random secret; only its SHA-256 hash is stored.
+-- user_sessions.id stays the public session ID for listing/revoking.
+-- ============================================================
+
+USE lettersheets;
+
+ALTER TABLE user_sessions
+    ADD COLUMN token_hash CHAR(64) NULL AFTER company_id;
+
+-- Existing sessions used their id as the bearer token, and the id is
+-- visible in session listings, so they are ended: their users log in
+-- again. The hash of the id only fills the new unique column.
+UPDATE user_sessions SET
+    token_hash = SHA2(id, 256),
+    is_active = 0
+WHERE token_hash IS NULL;
+
+ALTER TABLE user_sessions
+    MODIFY COLUMN token_hash CHAR(64) NOT NULL,
+    ADD UNIQUE KEY uk_sessions_token_hash (token_hash);
+
+DELIMITER //
+
+-- ============================================================
+-- USER SESSION: CREATE
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_create_session//
+CREATE PROCEDURE sp_create_session(
+    IN p_id VARCHAR(36),
+    IN p_token_hash CHAR(64),
+    IN p_user_id VARCHAR(36),
+    IN p_company_id VARCHAR(36),
+    IN p_device_info VARCHAR(500),
+    IN p_ip_address VARCHAR(45),
+    IN p_expires_at DATETIME
+)
+BEGIN
+    INSERT INTO user_sessions (
+        id, user_id, company_id, token_hash, device_info, ip_address,
+        is_active, expires_at, created_at, last_activity_at
+    ) VALUES (
+        p_id, p_user_id, p_company_id, p_token_hash, p_device_info, p_ip_address,
+        1, p_expires_at, NOW(), NOW()
+    );
+END//
+
+-- ============================================================
+-- USER SESSION: VALIDATE (by token hash)
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_validate_session//
+CREATE PROCEDURE sp_validate_session(
+    IN p_token_hash CHAR(64)
+)
+BEGIN
+    SELECT s.id, s.user_id, s.company_id, s.expires_at,
+           u.email, u.username, u.is_active AS user_active,
+           uca.role, uca.permissions, uca.wrapped_company_key,
+           uca.key_wrap_algorithm, uca.key_version, uca.public_key
+    FROM user_sessions s
+    INNER JOIN users u ON u.id = s.user_id
+    INNER JOIN user_company_access uca ON uca.user_id = s.user_id AND uca.company_id = s.company_id AND uca.is_active = 1
+    WHERE s.token_hash = p_token_hash
+      AND s.is_active = 1
+      AND s.expires_at > NOW()
+      AND u.is_active = 1;
+
+    -- Update last activity
+    UPDATE user_sessions SET last_activity_at = NOW()
+    WHERE token_hash = p_token_hash AND is_active = 1;
+END//
+
+DELIMITER ;
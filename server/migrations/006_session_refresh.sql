@@ -0,0 +1,219 @@
+-- ============================================================
+-- SESSION LIFETIME AND REFRESH TOKENS
+-- expires_at           short-lived access token expiry
+-- absolute_expires_at  hard cap from session creation
+-- last_activity_at     enforced against the idle timeout
+-- Refresh tokens rotate on every use; presenting a used refresh
+-- token revokes the whole session family.
+-- ============================================================
+
+USE lettersheets;
+
+ALTER TABLE user_sessions
+    ADD COLUMN absolute_expires_at DATETIME NULL AFTER expires_at;
+
+UPDATE user_sessions SET absolute_expires_at = expires_at
+WHERE absolute_expires_at IS NULL;
+
+ALTER TABLE user_sessions
+    MODIFY COLUMN absolute_expires_at DATETIME NOT NULL;
+
+CREATE TABLE IF NOT EXISTS session_refresh_tokens (
+    id VARCHAR(36) NOT NULL PRIMARY KEY,
+    session_id VARCHAR(36) NOT NULL,
+
+    token_hash CHAR(64) NOT NULL,
+
+    used_at DATETIME,
+    revoked_at DATETIME,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE KEY uk_refresh_tokens_hash (token_hash),
+    CONSTRAINT fk_refresh_tokens_session FOREIGN KEY (session_id) REFERENCES user_sessions(id)
+) ENGINE=InnoDB;
+
+CREATE INDEX idx_refresh_tokens_session ON session_refresh_tokens(session_id);
+
+DELIMITER //
+
+-- ============================================================
+-- USER SESSION: CREATE (with initial refresh token)
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_create_session//
+CREATE PROCEDURE sp_create_session(
+    IN p_id VARCHAR(36),
+    IN p_token_hash CHAR(64),
+    IN p_user_id VARCHAR(36),
+    IN p_company_id VARCHAR(36),
+    IN p_device_info VARCHAR(500),
+    IN p_ip_address VARCHAR(45),
+    IN p_expires_at DATETIME,
+    IN p_absolute_expires_at DATETIME,
+    IN p_refresh_id VARCHAR(36),
+    IN p_refresh_token_hash CHAR(64)
+)
+BEGIN
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    INSERT INTO user_sessions (
+        id, user_id, company_id, token_hash, device_info, ip_address,
+        is_active, expires_at, absolute_expires_at, created_at, last_activity_at
+    ) VALUES (
+        p_id, p_user_id, p_company_id, p_token_hash, p_device_info, p_ip_address,
+        1, p_expires_at, p_absolute_expires_at, NOW(), NOW()
+    );
+
+    INSERT INTO session_refresh_tokens (id, session_id, token_hash, created_at)
+    VALUES (p_refresh_id, p_id, p_refresh_token_hash, NOW());
+
+    COMMIT;
+END//
+
+-- ============================================================
+-- USER SESSION: VALIDATE (by token hash, with idle timeout)
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_validate_session//
+CREATE PROCEDURE sp_validate_session(
+    IN p_token_hash CHAR(64),
+    IN p_idle_minutes INT
+)
+BEGIN
+    SELECT s.id, s.user_id, s.company_id, s.expires_at,
+           u.email, u.username, u.is_active AS user_active,
+           uca.role, uca.permissions, uca.wrapped_company_key,
+           uca.key_wrap_algorithm, uca.key_version, uca.public_key
+    FROM user_sessions s
+    INNER JOIN users u ON u.id = s.user_id
+    INNER JOIN user_company_access uca ON uca.user_id = s.user_id AND uca.company_id = s.company_id AND uca.is_active = 1
+    WHERE s.token_hash = p_token_hash
+      AND s.is_active = 1
+      AND s.expires_at > NOW()
+      AND s.absolute_expires_at > NOW()
+      AND s.last_activity_at > DATE_SUB(NOW(), INTERVAL p_idle_minutes MINUTE)
+      AND u.is_active = 1;
+
+    -- Slide the idle window
+    UPDATE user_sessions SET last_activity_at = NOW()
+    WHERE token_hash = p_token_hash
+      AND is_active = 1
+      AND last_activity_at > DATE_SUB(NOW(), INTERVAL p_idle_minutes MINUTE);
+END//
+
+-- ============================================================
+-- USER SESSION: REFRESH (rotate access + refresh token)
+-- Returns status: ok | invalid | expired | reused
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_refresh_session//
+CREATE PROCEDURE sp_refresh_session(
+    IN p_refresh_token_hash CHAR(64),
+    IN p_new_refresh_id VARCHAR(36),
+    IN p_new_refresh_token_hash CHAR(64),
+    IN p_new_token_hash CHAR(64),
+    IN p_access_minutes INT,
+    IN p_idle_minutes INT,
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    DECLARE v_refresh_id VARCHAR(36);
+    DECLARE v_session_id VARCHAR(36);
+    DECLARE v_used_at DATETIME;
+    DECLARE v_revoked_at DATETIME;
+    DECLARE v_user_id VARCHAR(36);
+    DECLARE v_company_id VARCHAR(36);
+    DECLARE v_is_active TINYINT(1);
+    DECLARE v_absolute_expires_at DATETIME;
+    DECLARE v_last_activity_at DATETIME;
+    DECLARE v_expires_at DATETIME;
+    DECLARE v_status VARCHAR(20) DEFAULT 'invalid';
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    SELECT id, session_id, used_at, revoked_at
+    INTO v_refresh_id, v_session_id, v_used_at, v_revoked_at
+    FROM session_refresh_tokens
+    WHERE token_hash = p_refresh_token_hash
+    FOR UPDATE;
+
+    IF v_refresh_id IS NOT NULL THEN
+        SELECT user_id, company_id, is_active, absolute_expires_at, last_activity_at
+        INTO v_user_id, v_company_id, v_is_active, v_absolute_expires_at, v_last_activity_at
+        FROM user_sessions WHERE id = v_session_id
+        FOR UPDATE;
+
+        IF v_used_at IS NOT NULL THEN
+            -- Replay of a rotated token: revoke the whole family
+            SET v_status = 'reused';
+
+            UPDATE user_sessions SET is_active = 0 WHERE id = v_session_id;
+            UPDATE session_refresh_tokens SET revoked_at = NOW()
+            WHERE session_id = v_session_id AND revoked_at IS NULL;
+
+            IF v_is_active = 1 THEN
+                CALL sp_log_change(v_company_id, v_user_id, v_session_id, 'user_sessions', v_session_id, 'delete', 'is_active', '1', '0', 0, p_ip_address, p_user_agent);
+            END IF;
+        ELSEIF v_revoked_at IS NOT NULL
+            OR v_is_active = 0
+            OR v_absolute_expires_at <= NOW()
+            OR v_last_activity_at <= DATE_SUB(NOW(), INTERVAL p_idle_minutes MINUTE) THEN
+            SET v_status = 'expired';
+        ELSE
+            SET v_status = 'ok';
+            SET v_expires_at = LEAST(DATE_ADD(NOW(), INTERVAL p_access_minutes MINUTE), v_absolute_expires_at);
+
+            UPDATE session_refresh_tokens SET used_at = NOW() WHERE id = v_refresh_id;
+
+            INSERT INTO session_refresh_tokens (id, session_id, token_hash, created_at)
+            VALUES (p_new_refresh_id, v_session_id, p_new_refresh_token_hash, NOW());
+
+            UPDATE user_sessions SET
+                token_hash = p_new_token_hash,
+                expires_at = v_expires_at,
+                ip_address = p_ip_address,
+                last_activity_at = NOW()
+            WHERE id = v_session_id;
+        END IF;
+    END IF;
+
+    COMMIT;
+
+    SELECT v_status AS status, v_session_id AS session_id,
+           v_expires_at AS expires_at, v_absolute_expires_at AS absolute_expires_at;
+END//
+
+-- ============================================================
+-- USER SESSION: READ (active sessions for a user)
+-- Sessions stay listed while refreshable, not just while the
+-- current access token is valid
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_get_user_sessions//
+CREATE PROCEDURE sp_get_user_sessions(
+    IN p_user_id VARCHAR(36),
+    IN p_company_id VARCHAR(36)
+)
+BEGIN
+    SELECT s.id, s.user_id, s.company_id, s.device_info, s.ip_address,
+           s.expires_at, s.absolute_expires_at, s.created_at, s.last_activity_at,
+           c.name AS company_name
+    FROM user_sessions s
+    INNER JOIN companies c ON c.id = s.company_id
+    WHERE s.user_id = p_user_id
+      AND (p_company_id IS NULL OR s.company_id = p_company_id)
+      AND s.is_active = 1
+      AND s.absolute_expires_at > NOW()
+    ORDER BY s.last_activity_at DESC;
+END//
+
+DELIMITER ;
@@ -0,0 +1,93 @@
+-- ============================================================
+-- SESSION LOGIN LINK
+-- Sessions opened by switch_company share the login_id of the
+-- session they were switched from, and inherit its absolute expiry
+-- ============================================================
+
+USE lettersheets;
+
+ALTER TABLE user_sessions
+    ADD COLUMN login_id VARCHAR(36) NULL AFTER company_id;
+
+UPDATE user_sessions SET login_id = id WHERE login_id IS NULL;
+
+ALTER TABLE user_sessions
+    MODIFY COLUMN login_id VARCHAR(36) NOT NULL;
+
+CREATE INDEX idx_sessions_login ON user_sessions(login_id);
+
+DELIMITER //
+
+-- ============================================================
+-- USER SESSION: CREATE (with initial refresh token)
+-- p_login_id NULL starts a new login
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_create_session//
+CREATE PROCEDURE sp_create_session(
+    IN p_id VARCHAR(36),
+    IN p_login_id VARCHAR(36),
+    IN p_token_hash CHAR(64),
+    IN p_user_id VARCHAR(36),
+    IN p_company_id VARCHAR(36),
+    IN p_device_info VARCHAR(500),
+    IN p_ip_address VARCHAR(45),
+    IN p_expires_at DATETIME,
+    IN p_absolute_expires_at DATETIME,
+    IN p_refresh_id VARCHAR(36),
+    IN p_refresh_token_hash CHAR(64)
+)
+BEGIN
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    INSERT INTO user_sessions (
+        id, user_id, company_id, login_id, token_hash, device_info, ip_address,
+        is_active, expires_at, absolute_expires_at, created_at, last_activity_at
+    ) VALUES (
+        p_id, p_user_id, p_company_id, IFNULL(p_login_id, p_id), p_token_hash, p_device_info, p_ip_address,
+        1, p_expires_at, p_absolute_expires_at, NOW(), NOW()
+    );
+
+    INSERT INTO session_refresh_tokens (id, session_id, token_hash, created_at)
+    VALUES (p_refresh_id, p_id, p_refresh_token_hash, NOW());
+
+    COMMIT;
+END//
+
+-- ============================================================
+-- USER SESSION: VALIDATE (by token hash, with idle timeout)
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_validate_session//
+CREATE PROCEDURE sp_validate_session(
+    IN p_token_hash CHAR(64),
+    IN p_idle_minutes INT
+)
+BEGIN
+    SELECT s.id, s.user_id, s.company_id, s.login_id,
+           s.expires_at, s.absolute_expires_at,
+           u.email, u.username, u.is_active AS user_active,
+           uca.role, uca.permissions, uca.wrapped_company_key,
+           uca.key_wrap_algorithm, uca.key_version, uca.public_key
+    FROM user_sessions s
+    INNER JOIN users u ON u.id = s.user_id
+    INNER JOIN user_company_access uca ON uca.user_id = s.user_id AND uca.company_id = s.company_id AND uca.is_active = 1
+    WHERE s.token_hash = p_token_hash
+      AND s.is_active = 1
+      AND s.expires_at > NOW()
+      AND s.absolute_expires_at > NOW()
+      AND s.last_activity_at > DATE_SUB(NOW(), INTERVAL p_idle_minutes MINUTE)
+      AND u.is_active = 1;
+
+    -- Slide the idle window
+    UPDATE user_sessions SET last_activity_at = NOW()
+    WHERE token_hash = p_token_hash
+      AND is_active = 1
+      AND last_activity_at > DATE_SUB(NOW(), INTERVAL p_idle_minutes MINUTE);
+END//
+
+DELIMITER ;
@@ -0,0 +1,120 @@
+-- ============================================================
+-- TOTP TWO-FACTOR AUTHENTICATION
+-- Secrets are encrypted with the server key before storage.
+-- totp_pending_enc holds an unconfirmed enrollment;
+-- totp_secret_enc is only set once a code has been confirmed.
+-- totp_last_counter rejects replays of an already-used code.
+-- ============================================================
+
+USE lettersheets;
+
+ALTER TABLE users
+    ADD COLUMN totp_pending_enc BLOB AFTER totp_secret_enc,
+    ADD COLUMN totp_last_counter BIGINT AFTER totp_pending_enc,
+    ADD COLUMN totp_enabled_at DATETIME AFTER totp_last_counter;
+
+DELIMITER //
+
+-- ============================================================
+-- USER: READ (includes TOTP enrollment state)
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_get_user//
+CREATE PROCEDURE sp_get_user(
+    IN p_id VARCHAR(36)
+)
+BEGIN
+    SELECT id, email, username, is_active, last_login_at,
+           password_changed_at, totp_enabled_at, created_at, updated_at
+    FROM users
+    WHERE id = p_id AND is_active = 1;
+END//
+
+-- ============================================================
+-- TOTP: READ STATE
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_get_totp_state//
+CREATE PROCEDURE sp_get_totp_state(
+    IN p_user_id VARCHAR(36)
+)
+BEGIN
+    SELECT totp_secret_enc, totp_pending_enc, totp_enabled_at
+    FROM users
+    WHERE id = p_user_id AND is_active = 1;
+END//
+
+-- ============================================================
+-- TOTP: START ENROLLMENT (store pending secret)
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_set_totp_pending//
+CREATE PROCEDURE sp_set_totp_pending(
+    IN p_user_id VARCHAR(36),
+    IN p_pending_enc BLOB
+)
+BEGIN
+    UPDATE users SET totp_pending_enc = p_pending_enc
+    WHERE id = p_user_id AND is_active = 1 AND totp_secret_enc IS NULL;
+END//
+
+-- ============================================================
+-- TOTP: CONFIRM ENROLLMENT (promote pending secret)
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_enable_totp//
+CREATE PROCEDURE sp_enable_totp(
+    IN p_user_id VARCHAR(36),
+    IN p_counter BIGINT,
+    IN p_company_id VARCHAR(36),
+    IN p_session_id VARCHAR(36),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    UPDATE users SET
+        totp_secret_enc = totp_pending_enc,
+        totp_pending_enc = NULL,
+        totp_last_counter = p_counter,
+        totp_enabled_at = NOW()
+    WHERE id = p_user_id AND is_active = 1 AND totp_pending_enc IS NOT NULL;
+
+    CALL sp_log_change(p_company_id, p_user_id, p_session_id, 'users', p_user_id, 'update', 'totp_secret_enc', NULL, '[redacted]', 1, p_ip_address, p_user_agent);
+END//
+
+-- ============================================================
+-- TOTP: DISABLE
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_disable_totp//
+CREATE PROCEDURE sp_disable_totp(
+    IN p_user_id VARCHAR(36),
+    IN p_company_id VARCHAR(36),
+    IN p_session_id VARCHAR(36),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    UPDATE users SET
+        totp_secret_enc = NULL,
+        totp_pending_enc = NULL,
+        totp_last_counter = NULL,
+        totp_enabled_at = NULL
+    WHERE id = p_user_id;
+
+    CALL sp_log_change(p_company_id, p_user_id, p_session_id, 'users', p_user_id, 'update', 'totp_secret_enc', '[redacted]', NULL, 1, p_ip_address, p_user_agent);
+END//
+
+-- ============================================================
+-- TOTP: USE CODE (replay protection)
+-- Accepts a time step only if it is newer than the last one used
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_use_totp_counter//
+CREATE PROCEDURE sp_use_totp_counter(
+    IN p_user_id VARCHAR(36),
+    IN p_counter BIGINT
+)
+BEGIN
+    UPDATE users SET totp_last_counter = p_counter
+    WHERE id = p_user_id
+      AND (totp_last_counter IS NULL OR totp_last_counter < p_counter);
+
+    SELECT ROW_COUNT() AS accepted;
+END//
+
+DELIMITER ;
@@ -0,0 +1,143 @@
+-- ============================================================
+-- MFA BACKUP CODES
+-- One-time codes accepted at login instead of a TOTP code.
+-- Only an HMAC of each code (keyed with the server key) is stored.
+-- ============================================================
+
+USE lettersheets;
+
+CREATE TABLE IF NOT EXISTS user_backup_codes (
+    id VARCHAR(36) NOT NULL PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+
+    code_hash CHAR(64) NOT NULL,
+
+    used_at DATETIME,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE KEY uk_backup_codes_hash (user_id, code_hash),
+    CONSTRAINT fk_backup_codes_user FOREIGN KEY (user_id) REFERENCES users(id)
+) ENGINE=InnoDB;
+
+DELIMITER //
+
+-- ============================================================
+-- BACKUP CODES: REPLACE ALL
+-- p_code_hashes is a JSON array of hashes
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_replace_backup_codes//
+CREATE PROCEDURE sp_replace_backup_codes(
+    IN p_user_id VARCHAR(36),
+    IN p_code_hashes JSON,
+    IN p_company_id VARCHAR(36),
+    IN p_session_id VARCHAR(36),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    DECLARE v_old_count INT;
+    DECLARE v_new_count INT;
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    SELECT COUNT(*) INTO v_old_count
+    FROM user_backup_codes WHERE user_id = p_user_id AND used_at IS NULL;
+
+    DELETE FROM user_backup_codes WHERE user_id = p_user_id;
+
+    INSERT INTO user_backup_codes (id, user_id, code_hash, created_at)
+    SELECT UUID(), p_user_id, jt.code_hash, NOW()
+    FROM JSON_TABLE(p_code_hashes, '$[*]' COLUMNS (code_hash CHAR(64) PATH '$')) jt;
+
+    SET v_new_count = JSON_LENGTH(p_code_hashes);
+
+    CALL sp_log_change(p_company_id, p_user_id, p_session_id, 'user_backup_codes', p_user_id, 'update', 'unused_count', CAST(v_old_count AS CHAR), CAST(v_new_count AS CHAR), 0, p_ip_address, p_user_agent);
+
+    COMMIT;
+END//
+
+-- ============================================================
+-- BACKUP CODES: USE
+-- Consumes a code and audits it in every company the user can access
+-- (no company is selected yet at login)
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_use_backup_code//
+CREATE PROCEDURE sp_use_backup_code(
+    IN p_user_id VARCHAR(36),
+    IN p_code_hash CHAR(64),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    DECLARE v_id VARCHAR(36);
+    DECLARE v_remaining INT DEFAULT 0;
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    SELECT id INTO v_id
+    FROM user_backup_codes
+    WHERE user_id = p_user_id AND code_hash = p_code_hash AND used_at IS NULL
+    FOR UPDATE;
+
+    IF v_id IS NOT NULL THEN
+        UPDATE user_backup_codes SET used_at = NOW() WHERE id = v_id;
+
+        INSERT INTO change_history (
+            id, company_id, changed_by, session_id,
+            table_name, record_id, change_type,
+            field_name, old_value, new_value, is_encrypted,
+            ip_address, user_agent, changed_at
+        )
+        SELECT UUID(), uca.company_id, p_user_id, NULL,
+               'user_backup_codes', v_id, 'update',
+               'used_at', NULL, CAST(NOW() AS CHAR), 0,
+               p_ip_address, p_user_agent, NOW()
+        FROM user_company_access uca
+        WHERE uca.user_id = p_user_id AND uca.is_active = 1;
+    END IF;
+
+    SELECT COUNT(*) INTO v_remaining
+    FROM user_backup_codes WHERE user_id = p_user_id AND used_at IS NULL;
+
+    COMMIT;
+
+    SELECT v_id IS NOT NULL AS accepted, v_remaining AS remaining;
+END//
+
+-- ============================================================
+-- TOTP: DISABLE (also drops backup codes)
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_disable_totp//
+CREATE PROCEDURE sp_disable_totp(
+    IN p_user_id VARCHAR(36),
+    IN p_company_id VARCHAR(36),
+    IN p_session_id VARCHAR(36),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    UPDATE users SET
+        totp_secret_enc = NULL,
+        totp_pending_enc = NULL,
+        totp_last_counter = NULL,
+        totp_enabled_at = NULL
+    WHERE id = p_user_id;
+
+    DELETE FROM user_backup_codes WHERE user_id = p_user_id;
+
+    CALL sp_log_change(p_company_id, p_user_id, p_session_id, 'users', p_user_id, 'update', 'totp_secret_enc', '[redacted]', NULL, 1, p_ip_address, p_user_agent);
+END//
+
+DELIMITER ;
@@ -0,0 +1,239 @@
+-- ============================================================
+-- WEBAUTHN / PASSKEYS
+-- Public-key credentials registered per user, used as a second
+-- factor after the password or for passwordless login.
+-- Challenges are single-use; only their SHA-256 hash is stored.
+-- ============================================================
+
+USE lettersheets;
+
+CREATE TABLE IF NOT EXISTS user_webauthn_credentials (
+    id VARCHAR(36) NOT NULL PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+
+    credential_id VARBINARY(1023) NOT NULL,
+    public_key BLOB NOT NULL,
+    sign_count INT UNSIGNED NOT NULL DEFAULT 0,
+    aaguid BINARY(16),
+
+    name VARCHAR(100) NOT NULL,
+    transports VARCHAR(255),
+
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at DATETIME,
+
+    UNIQUE KEY uk_webauthn_credential_id (credential_id),
+    CONSTRAINT fk_webauthn_user FOREIGN KEY (user_id) REFERENCES users(id)
+) ENGINE=InnoDB;
+
+CREATE INDEX idx_webauthn_user ON user_webauthn_credentials(user_id);
+
+-- user_id is NULL for passwordless login, where the user is not known yet
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+    id VARCHAR(36) NOT NULL PRIMARY KEY,
+    user_id VARCHAR(36),
+
+    challenge_hash CHAR(64) NOT NULL,
+    purpose VARCHAR(50) NOT NULL,
+
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE KEY uk_webauthn_challenge_hash (challenge_hash),
+    CONSTRAINT fk_webauthn_challenge_user FOREIGN KEY (user_id) REFERENCES users(id)
+) ENGINE=InnoDB;
+
+CREATE INDEX idx_webauthn_challenges_expires ON webauthn_challenges(expires_at);
+
+DELIMITER //
+
+-- ============================================================
+-- WEBAUTHN CHALLENGE: CREATE
+-- Also purges expired challenges
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_create_webauthn_challenge//
+CREATE PROCEDURE sp_create_webauthn_challenge(
+    IN p_id VARCHAR(36),
+    IN p_user_id VARCHAR(36),
+    IN p_challenge_hash CHAR(64),
+    IN p_purpose VARCHAR(50),
+    IN p_expires_at DATETIME
+)
+BEGIN
+    DELETE FROM webauthn_challenges WHERE expires_at < NOW();
+
+    INSERT INTO webauthn_challenges (
+        id, user_id, challenge_hash, purpose, expires_at, created_at
+    ) VALUES (
+        p_id, p_user_id, p_challenge_hash, p_purpose, p_expires_at, NOW()
+    );
+END//
+
+-- ============================================================
+-- WEBAUTHN CHALLENGE: CONSUME
+-- Marks a valid challenge as used; found = 0 when it is unknown,
+-- expired, used, or for another purpose
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_consume_webauthn_challenge//
+CREATE PROCEDURE sp_consume_webauthn_challenge(
+    IN p_challenge_hash CHAR(64),
+    IN p_purpose VARCHAR(50)
+)
+BEGIN
+    DECLARE v_id VARCHAR(36);
+    DECLARE v_user_id VARCHAR(36);
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    SELECT id, user_id INTO v_id, v_user_id
+    FROM webauthn_challenges
+    WHERE challenge_hash = p_challenge_hash
+      AND purpose = p_purpose
+      AND used_at IS NULL
+      AND expires_at > NOW()
+    FOR UPDATE;
+
+    IF v_id IS NOT NULL THEN
+        UPDATE webauthn_challenges SET used_at = NOW() WHERE id = v_id;
+    END IF;
+
+    COMMIT;
+
+    SELECT v_id IS NOT NULL AS found, v_user_id AS user_id;
+END//
+
+-- ============================================================
+-- WEBAUTHN CREDENTIAL: CREATE
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_create_webauthn_credential//
+CREATE PROCEDURE sp_create_webauthn_credential(
+    IN p_id VARCHAR(36),
+    IN p_user_id VARCHAR(36),
+    IN p_credential_id VARBINARY(1023),
+    IN p_public_key BLOB,
+    IN p_sign_count INT UNSIGNED,
+    IN p_aaguid BINARY(16),
+    IN p_name VARCHAR(100),
+    IN p_transports VARCHAR(255),
+    IN p_company_id VARCHAR(36),
+    IN p_session_id VARCHAR(36),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    INSERT INTO user_webauthn_credentials (
+        id, user_id, credential_id, public_key, sign_count, aaguid,
+        name, transports, created_at
+    ) VALUES (
+        p_id, p_user_id, p_credential_id, p_public_key, p_sign_count, p_aaguid,
+        p_name, p_transports, NOW()
+    );
+
+    CALL sp_log_change(p_company_id, p_user_id, p_session_id, 'user_webauthn_credentials', p_id, 'insert', 'name', NULL, p_name, 0, p_ip_address, p_user_agent);
+END//
+
+-- ============================================================
+-- WEBAUTHN CREDENTIAL: READ BY CREDENTIAL ID
+-- Joins the owner's email so login can load the full user
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_get_webauthn_credential//
+CREATE PROCEDURE sp_get_webauthn_credential(
+    IN p_credential_id VARBINARY(1023)
+)
+BEGIN
+    SELECT c.id, c.user_id, c.credential_id, c.public_key, c.sign_count,
+           c.name, c.transports, c.created_at, c.last_used_at, u.email
+    FROM user_webauthn_credentials c
+    JOIN users u ON u.id = c.user_id
+    WHERE c.credential_id = p_credential_id;
+END//
+
+-- ============================================================
+-- WEBAUTHN CREDENTIAL: LIST FOR USER
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_get_user_webauthn_credentials//
+CREATE PROCEDURE sp_get_user_webauthn_credentials(
+    IN p_user_id VARCHAR(36)
+)
+BEGIN
+    SELECT id, user_id, credential_id, public_key, sign_count,
+           name, transports, created_at, last_used_at
+    FROM user_webauthn_credentials
+    WHERE user_id = p_user_id
+    ORDER BY created_at;
+END//
+
+-- ============================================================
+-- WEBAUTHN CREDENTIAL: USE
+-- Stores the new signature counter; rejects a counter that did not
+-- increase unless the authenticator does not keep one (both zero)
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_use_webauthn_credential//
+CREATE PROCEDURE sp_use_webauthn_credential(
+    IN p_id VARCHAR(36),
+    IN p_sign_count INT UNSIGNED
+)
+BEGIN
+    DECLARE v_old_count INT UNSIGNED;
+    DECLARE v_accepted TINYINT DEFAULT 0;
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    SELECT sign_count INTO v_old_count
+    FROM user_webauthn_credentials WHERE id = p_id
+    FOR UPDATE;
+
+    IF v_old_count IS NOT NULL
+       AND (p_sign_count > v_old_count OR (p_sign_count = 0 AND v_old_count = 0)) THEN
+        UPDATE user_webauthn_credentials
+        SET sign_count = p_sign_count, last_used_at = NOW()
+        WHERE id = p_id;
+        SET v_accepted = 1;
+    END IF;
+
+    COMMIT;
+
+    SELECT v_accepted AS accepted;
+END//
+
+-- ============================================================
+-- WEBAUTHN CREDENTIAL: DELETE
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_delete_webauthn_credential//
+CREATE PROCEDURE sp_delete_webauthn_credential(
+    IN p_id VARCHAR(36),
+    IN p_user_id VARCHAR(36),
+    IN p_company_id VARCHAR(36),
+    IN p_session_id VARCHAR(36),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    DECLARE v_name VARCHAR(100);
+
+    SELECT name INTO v_name
+    FROM user_webauthn_credentials WHERE id = p_id AND user_id = p_user_id;
+
+    IF v_name IS NOT NULL THEN
+        DELETE FROM user_webauthn_credentials WHERE id = p_id AND user_id = p_user_id;
+
+        CALL sp_log_change(p_company_id, p_user_id, p_session_id, 'user_webauthn_credentials', p_id, 'delete', 'name', v_name, NULL, 0, p_ip_address, p_user_agent);
+    END IF;
+
+    SELECT v_name IS NOT NULL AS deleted;
+END//
+
+DELIMITER ;
@@ -0,0 +1,154 @@
+-- ============================================================
+-- COMPANY SECURITY POLICY
+-- Stored with company_settings. NULL means "use the server default"
+-- (or, for password_max_age_days, "never expires").
+-- mfa_required_roles and allowed_ip_cidrs are JSON arrays of strings;
+-- NULL or empty means no MFA requirement / any network.
+-- ============================================================
+
+USE lettersheets;
+
+ALTER TABLE company_settings
+    ADD COLUMN mfa_required_roles JSON AFTER employee_number_auto,
+    ADD COLUMN password_min_length INT NOT NULL DEFAULT 8 AFTER mfa_required_roles,
+    ADD COLUMN password_require_complexity TINYINT(1) NOT NULL DEFAULT 0 AFTER password_min_length,
+    ADD COLUMN password_max_age_days INT AFTER password_require_complexity,
+    ADD COLUMN session_hours INT AFTER password_max_age_days,
+    ADD COLUMN allowed_ip_cidrs JSON AFTER session_hours,
+    ADD COLUMN max_login_attempts INT AFTER allowed_ip_cidrs,
+    ADD COLUMN lockout_minutes INT AFTER max_login_attempts;
+
+DELIMITER //
+
+-- ============================================================
+-- SECURITY POLICY: READ FOR COMPANY
+-- Also returns the user's password age and MFA enrollment, which
+-- the policy is checked against on every authenticated request
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_get_security_policy//
+CREATE PROCEDURE sp_get_security_policy(
+    IN p_company_id VARCHAR(36),
+    IN p_user_id VARCHAR(36)
+)
+BEGIN
+    SELECT cs.company_id, cs.mfa_required_roles, cs.password_min_length,
+           cs.password_require_complexity, cs.password_max_age_days,
+           cs.session_hours, cs.allowed_ip_cidrs,
+           cs.max_login_attempts, cs.lockout_minutes,
+           COALESCE(u.password_changed_at, u.created_at) AS password_changed_at,
+           (u.totp_secret_enc IS NOT NULL
+               OR EXISTS (SELECT 1 FROM user_webauthn_credentials c WHERE c.user_id = u.id)) AS mfa_enrolled
+    FROM company_settings cs
+    LEFT JOIN users u ON u.id = p_user_id
+    WHERE cs.company_id = p_company_id;
+END//
+
+-- ============================================================
+-- SECURITY POLICY: READ FOR ALL OF A USER'S COMPANIES
+-- Used before a company is selected (login, change_password)
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_get_user_security_policies//
+CREATE PROCEDURE sp_get_user_security_policies(
+    IN p_user_id VARCHAR(36)
+)
+BEGIN
+    SELECT cs.company_id, cs.mfa_required_roles, cs.password_min_length,
+           cs.password_require_complexity, cs.password_max_age_days,
+           cs.session_hours, cs.allowed_ip_cidrs,
+           cs.max_login_attempts, cs.lockout_minutes
+    FROM user_company_access uca
+    JOIN companies c ON c.id = uca.company_id AND c.is_active = 1
+    JOIN company_settings cs ON cs.company_id = uca.company_id
+    WHERE uca.user_id = p_user_id AND uca.is_active = 1;
+END//
+
+-- ============================================================
+-- SECURITY POLICY: UPDATE
+-- Replaces every policy field; logs only changed fields
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_update_security_policy//
+CREATE PROCEDURE sp_update_security_policy(
+    IN p_company_id VARCHAR(36),
+    IN p_mfa_required_roles JSON,
+    IN p_password_min_length INT,
+    IN p_password_require_complexity TINYINT(1),
+    IN p_password_max_age_days INT,
+    IN p_session_hours INT,
+    IN p_allowed_ip_cidrs JSON,
+    IN p_max_login_attempts INT,
+    IN p_lockout_minutes INT,
+    IN p_changed_by VARCHAR(36),
+    IN p_session_id VARCHAR(36),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    DECLARE v_old_mfa_required_roles JSON;
+    DECLARE v_old_password_min_length INT;
+    DECLARE v_old_password_require_complexity TINYINT(1);
+    DECLARE v_old_password_max_age_days INT;
+    DECLARE v_old_session_hours INT;
+    DECLARE v_old_allowed_ip_cidrs JSON;
+    DECLARE v_old_max_login_attempts INT;
+    DECLARE v_old_lockout_minutes INT;
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    -- Fetch old values
+    SELECT mfa_required_roles, password_min_length, password_require_complexity,
+           password_max_age_days, session_hours, allowed_ip_cidrs,
+           max_login_attempts, lockout_minutes
+    INTO v_old_mfa_required_roles, v_old_password_min_length, v_old_password_require_complexity,
+         v_old_password_max_age_days, v_old_session_hours, v_old_allowed_ip_cidrs,
+         v_old_max_login_attempts, v_old_lockout_minutes
+    FROM company_settings WHERE company_id = p_company_id
+    FOR UPDATE;
+
+    -- Update
+    UPDATE company_settings SET
+        mfa_required_roles = p_mfa_required_roles,
+        password_min_length = p_password_min_length,
+        password_require_complexity = p_password_require_complexity,
+        password_max_age_days = p_password_max_age_days,
+        session_hours = p_session_hours,
+        allowed_ip_cidrs = p_allowed_ip_cidrs,
+        max_login_attempts = p_max_login_attempts,
+        lockout_minutes = p_lockout_minutes
+    WHERE company_id = p_company_id;
+
+    -- Log only changed fields
+    IF NOT (p_mfa_required_roles <=> v_old_mfa_required_roles) THEN
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'company_settings', p_company_id, 'update', 'mfa_required_roles', CAST(v_old_mfa_required_roles AS CHAR), CAST(p_mfa_required_roles AS CHAR), 0, p_ip_address, p_user_agent);
+    END IF;
+    IF NOT (p_password_min_length <=> v_old_password_min_length) THEN
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'company_settings', p_company_id, 'update', 'password_min_length', CAST(v_old_password_min_length AS CHAR), CAST(p_password_min_length AS CHAR), 0, p_ip_address, p_user_agent);
+    END IF;
+    IF NOT (p_password_require_complexity <=> v_old_password_require_complexity) THEN
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'company_settings', p_company_id, 'update', 'password_require_complexity', CAST(v_old_password_require_complexity AS CHAR), CAST(p_password_require_complexity AS CHAR), 0, p_ip_address, p_user_agent);
+    END IF;
+    IF NOT (p_password_max_age_days <=> v_old_password_max_age_days) THEN
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'company_settings', p_company_id, 'update', 'password_max_age_days', CAST(v_old_password_max_age_days AS CHAR), CAST(p_password_max_age_days AS CHAR), 0, p_ip_address, p_user_agent);
+    END IF;
+    IF NOT (p_session_hours <=> v_old_session_hours) THEN
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'company_settings', p_company_id, 'update', 'session_hours', CAST(v_old_session_hours AS CHAR), CAST(p_session_hours AS CHAR), 0, p_ip_address, p_user_agent);
+    END IF;
+    IF NOT (p_allowed_ip_cidrs <=> v_old_allowed_ip_cidrs) THEN
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'company_settings', p_company_id, 'update', 'allowed_ip_cidrs', CAST(v_old_allowed_ip_cidrs AS CHAR), CAST(p_allowed_ip_cidrs AS CHAR), 0, p_ip_address, p_user_agent);
+    END IF;
+    IF NOT (p_max_login_attempts <=> v_old_max_login_attempts) THEN
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'company_settings', p_company_id, 'update', 'max_login_attempts', CAST(v_old_max_login_attempts AS CHAR), CAST(p_max_login_attempts AS CHAR), 0, p_ip_address, p_user_agent);
+    END IF;
+    IF NOT (p_lockout_minutes <=> v_old_lockout_minutes) THEN
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'company_settings', p_company_id, 'update', 'lockout_minutes', CAST(v_old_lockout_minutes AS CHAR), CAST(p_lockout_minutes AS CHAR), 0, p_ip_address, p_user_agent);
+    END IF;
+
+    COMMIT;
+END//
+
+DELIMITER ;
@@ -0,0 +1,190 @@
+-- ============================================================
+-- PASSWORD RESET
+-- Two steps: a single-use reset token is emailed to the user
+-- (user_auth_tokens, purpose 'password_reset'), then the reset is
+-- completed with that token, proof of the recovery key and a
+-- company key re-wrapped under the new password.
+-- ============================================================
+
+USE lettersheets;
+
+DELIMITER //
+
+-- ============================================================
+-- AUTH TOKEN: CREATE
+-- Expired tokens are kept for a day so reset requests can be
+-- counted for rate limiting
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_create_auth_token//
+CREATE PROCEDURE sp_create_auth_token(
+    IN p_id VARCHAR(36),
+    IN p_user_id VARCHAR(36),
+    IN p_token_hash CHAR(64),
+    IN p_purpose VARCHAR(50),
+    IN p_ip_address VARCHAR(45),
+    IN p_expires_at DATETIME
+)
+BEGIN
+    DELETE FROM user_auth_tokens
+    WHERE user_id = p_user_id AND expires_at < NOW() - INTERVAL 1 DAY;
+
+    INSERT INTO user_auth_tokens (
+        id, user_id, token_hash, purpose, ip_address, expires_at, created_at
+    ) VALUES (
+        p_id, p_user_id, p_token_hash, p_purpose, p_ip_address, p_expires_at, NOW()
+    );
+END//
+
+-- ============================================================
+-- AUTH TOKEN: PEEK
+-- Returns the user_id of a valid token without consuming it
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_peek_auth_token//
+CREATE PROCEDURE sp_peek_auth_token(
+    IN p_token_hash CHAR(64),
+    IN p_purpose VARCHAR(50)
+)
+BEGIN
+    SELECT user_id
+    FROM user_auth_tokens
+    WHERE token_hash = p_token_hash
+      AND purpose = p_purpose
+      AND used_at IS NULL
+      AND expires_at > NOW();
+END//
+
+-- ============================================================
+-- PASSWORD RESET: REQUEST
+-- Rate limited per user and per IP over the last hour. A new
+-- request supersedes the user's earlier unused reset tokens.
+-- Audited in every company the user can access.
+-- Returns created = 0 when the limit was reached.
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_create_password_reset//
+CREATE PROCEDURE sp_create_password_reset(
+    IN p_id VARCHAR(36),
+    IN p_user_id VARCHAR(36),
+    IN p_token_hash CHAR(64),
+    IN p_expires_at DATETIME,
+    IN p_max_per_user INT,
+    IN p_max_per_ip INT,
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    DECLARE v_user_count INT;
+    DECLARE v_ip_count INT;
+    DECLARE v_created TINYINT DEFAULT 0;
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    SELECT COUNT(*) INTO v_user_count
+    FROM user_auth_tokens
+    WHERE user_id = p_user_id AND purpose = 'password_reset'
+      AND created_at > NOW() - INTERVAL 1 HOUR;
+
+    SELECT COUNT(*) INTO v_ip_count
+    FROM user_auth_tokens
+    WHERE ip_address = p_ip_address AND purpose = 'password_reset'
+      AND created_at > NOW() - INTERVAL 1 HOUR;
+
+    IF v_user_count < p_max_per_user AND v_ip_count < p_max_per_ip THEN
+        UPDATE user_auth_tokens SET used_at = NOW()
+        WHERE user_id = p_user_id AND purpose = 'password_reset' AND used_at IS NULL;
+
+        INSERT INTO user_auth_tokens (
+            id, user_id, token_hash, purpose, ip_address, expires_at, created_at
+        ) VALUES (
+            p_id, p_user_id, p_token_hash, 'password_reset', p_ip_address, p_expires_at, NOW()
+        );
+
+        INSERT INTO change_history (
+            id, company_id, changed_by, session_id,
+            table_name, record_id, change_type,
+            field_name, old_value, new_value, is_encrypted,
+            ip_address, user_agent, changed_at
+        )
+        SELECT UUID(), uca.company_id, p_user_id, NULL,
+               'user_auth_tokens', p_id, 'insert',
+               'purpose', NULL, 'password_reset', 0,
+               p_ip_address, p_user_agent, NOW()
+        FROM user_company_access uca
+        WHERE uca.user_id = p_user_id AND uca.is_active = 1;
+
+        SET v_created = 1;
+    END IF;
+
+    COMMIT;
+
+    SELECT v_created AS created;
+END//
+
+-- ============================================================
+-- PASSWORD RESET: COMPLETE
+-- Sets the new password, stores the company key re-wrapped under
+-- it, clears any lockout and ends every session of the user
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_reset_password_with_key//
+CREATE PROCEDURE sp_reset_password_with_key(
+    IN p_user_id VARCHAR(36),
+    IN p_password_hash VARCHAR(255),
+    IN p_salt VARCHAR(255),
+    IN p_company_id VARCHAR(36),
+    IN p_wrapped_company_key BLOB,
+    IN p_key_wrap_algorithm VARCHAR(50),
+    IN p_public_key BLOB,
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    DECLARE v_access_id VARCHAR(36);
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    SELECT id INTO v_access_id
+    FROM user_company_access
+    WHERE user_id = p_user_id AND company_id = p_company_id AND is_active = 1
+    FOR UPDATE;
+
+    IF v_access_id IS NULL THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'no active access to this company';
+    END IF;
+
+    UPDATE user_company_access SET
+        wrapped_company_key = p_wrapped_company_key,
+        key_wrap_algorithm = IFNULL(p_key_wrap_algorithm, key_wrap_algorithm),
+        public_key = p_public_key
+    WHERE id = v_access_id;
+
+    UPDATE users SET
+        password_hash = p_password_hash,
+        salt = p_salt,
+        password_changed_at = NOW(),
+        failed_login_attempts = 0,
+        locked_until = NULL
+    WHERE id = p_user_id AND is_active = 1;
+
+    UPDATE user_sessions SET is_active = 0
+    WHERE user_id = p_user_id AND is_active = 1;
+
+    -- Log that password changed without storing actual hash or key
+    CALL sp_log_change(p_company_id, p_user_id, NULL, 'users', p_user_id, 'update', 'password_hash', '[redacted]', '[redacted]', 0, p_ip_address, p_user_agent);
+    CALL sp_log_change(p_company_id, p_user_id, NULL, 'users', p_user_id, 'update', 'password_changed_at', NULL, CAST(NOW() AS CHAR), 0, p_ip_address, p_user_agent);
+    CALL sp_log_change(p_company_id, p_user_id, NULL, 'user_company_access', v_access_id, 'update', 'wrapped_company_key', '[redacted]', '[redacted]', 1, p_ip_address, p_user_agent);
+
+    COMMIT;
+END//
+
+DELIMITER ;
@@ -0,0 +1,98 @@
+-- ============================================================
+-- CHANGE PASSWORD WITH RE-WRAPPED COMPANY KEYS
+-- The client derives its key-encryption key from the password, so
+-- every company key must be re-wrapped when the password changes.
+-- The new hash and all wrapped keys are stored in one transaction.
+-- ============================================================
+
+USE lettersheets;
+
+DELIMITER //
+
+-- ============================================================
+-- USER: CHANGE PASSWORD
+-- p_wrapped_keys is a JSON array of
+--   {company_id, wrapped_company_key (base64), key_wrap_algorithm}
+-- and must cover exactly the companies the user can access
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_change_password//
+CREATE PROCEDURE sp_change_password(
+    IN p_id VARCHAR(36),
+    IN p_password_hash VARCHAR(255),
+    IN p_salt VARCHAR(255),
+    IN p_wrapped_keys JSON,
+    IN p_company_id VARCHAR(36),
+    IN p_session_id VARCHAR(36),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    DECLARE v_access_count INT;
+    DECLARE v_matched_count INT;
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    -- Lock the user's access rows so no company is granted mid-change
+    SELECT COUNT(*) INTO v_access_count
+    FROM user_company_access uca
+    JOIN companies c ON c.id = uca.company_id AND c.is_active = 1
+    WHERE uca.user_id = p_id AND uca.is_active = 1
+    FOR UPDATE;
+
+    SELECT COUNT(DISTINCT k.company_id) INTO v_matched_count
+    FROM JSON_TABLE(p_wrapped_keys, '$[*]' COLUMNS (
+        company_id VARCHAR(36) PATH '$.company_id'
+    )) k
+    JOIN user_company_access uca ON uca.company_id = k.company_id AND uca.user_id = p_id AND uca.is_active = 1
+    JOIN companies c ON c.id = uca.company_id AND c.is_active = 1;
+
+    IF v_matched_count <> v_access_count OR JSON_LENGTH(p_wrapped_keys) <> v_access_count THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'wrapped keys do not match the companies the user can access';
+    END IF;
+
+    UPDATE user_company_access uca
+    JOIN JSON_TABLE(p_wrapped_keys, '$[*]' COLUMNS (
+        company_id VARCHAR(36) PATH '$.company_id',
+        wrapped_company_key TEXT PATH '$.wrapped_company_key',
+        key_wrap_algorithm VARCHAR(50) PATH '$.key_wrap_algorithm'
+    )) k ON k.company_id = uca.company_id
+    SET uca.wrapped_company_key = FROM_BASE64(k.wrapped_company_key),
+        uca.key_wrap_algorithm = IFNULL(k.key_wrap_algorithm, uca.key_wrap_algorithm)
+    WHERE uca.user_id = p_id AND uca.is_active = 1;
+
+    UPDATE users SET
+        password_hash = p_password_hash,
+        salt = p_salt,
+        password_changed_at = NOW(),
+        failed_login_attempts = 0,
+        locked_until = NULL
+    WHERE id = p_id AND is_active = 1;
+
+    -- Log that password changed without storing actual hash
+    CALL sp_log_change(p_company_id, p_id, p_session_id, 'users', p_id, 'update', 'password_hash', '[redacted]', '[redacted]', 0, p_ip_address, p_user_agent);
+    CALL sp_log_change(p_company_id, p_id, p_session_id, 'users', p_id, 'update', 'password_changed_at', NULL, CAST(NOW() AS CHAR), 0, p_ip_address, p_user_agent);
+
+    -- Each company sees its own key re-wrap
+    INSERT INTO change_history (
+        id, company_id, changed_by, session_id,
+        table_name, record_id, change_type,
+        field_name, old_value, new_value, is_encrypted,
+        ip_address, user_agent, changed_at
+    )
+    SELECT UUID(), uca.company_id, p_id, p_session_id,
+           'user_company_access', uca.id, 'update',
+           'wrapped_company_key', '[redacted]', '[redacted]', 1,
+           p_ip_address, p_user_agent, NOW()
+    FROM user_company_access uca
+    WHERE uca.user_id = p_id AND uca.is_active = 1;
+
+    COMMIT;
+END//
+
+DELIMITER ;
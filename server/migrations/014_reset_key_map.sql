@@ -0,0 +1,308 @@
+-- ============================================================
+-- MULTI-COMPANY PASSWORD RESET
+-- A reset re-wraps every company key the recovery file could
+-- recover. Companies it could not recover are marked with
+-- key_lost_at and their stale wrapped key is cleared; an admin
+-- re-shares the key (update_user_access) to restore access.
+-- ============================================================
+
+USE lettersheets;
+
+ALTER TABLE user_company_access
+    ADD COLUMN key_lost_at DATETIME AFTER public_key;
+
+DELIMITER //
+
+-- ============================================================
+-- USER COMPANY ACCESS: READ (get companies for a user)
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_get_user_companies//
+CREATE PROCEDURE sp_get_user_companies(
+    IN p_user_id VARCHAR(36)
+)
+BEGIN
+    SELECT uca.id, uca.company_id, uca.wrapped_company_key, uca.key_wrap_algorithm,
+           uca.key_version, uca.public_key, uca.role, uca.permissions, uca.joined_at,
+           c.name AS company_name, c.plan AS company_plan, uca.key_lost_at
+    FROM user_company_access uca
+    INNER JOIN companies c ON c.id = uca.company_id AND c.is_active = 1
+    WHERE uca.user_id = p_user_id AND uca.is_active = 1;
+END//
+
+-- ============================================================
+-- USER COMPANY ACCESS: UPDATE (role, permissions, key)
+-- A new wrapped key clears key_lost_at
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_update_user_company_access//
+CREATE PROCEDURE sp_update_user_company_access(
+    IN p_id VARCHAR(36),
+    IN p_role VARCHAR(50),
+    IN p_permissions JSON,
+    IN p_wrapped_company_key BLOB,
+    IN p_key_wrap_algorithm VARCHAR(50),
+    IN p_key_version INT,
+    IN p_public_key BLOB,
+    IN p_company_id VARCHAR(36),
+    IN p_changed_by VARCHAR(36),
+    IN p_session_id VARCHAR(36),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    DECLARE v_old_role VARCHAR(50);
+    DECLARE v_old_permissions JSON;
+    DECLARE v_old_key_wrap_algorithm VARCHAR(50);
+    DECLARE v_old_key_version INT;
+
+    SELECT role, permissions, key_wrap_algorithm, key_version
+    INTO v_old_role, v_old_permissions, v_old_key_wrap_algorithm, v_old_key_version
+    FROM user_company_access WHERE id = p_id AND is_active = 1;
+
+    UPDATE user_company_access SET
+        role = IFNULL(p_role, role),
+        permissions = IFNULL(p_permissions, permissions),
+        wrapped_company_key = IFNULL(p_wrapped_company_key, wrapped_company_key),
+        key_wrap_algorithm = IFNULL(p_key_wrap_algorithm, key_wrap_algorithm),
+        key_version = IFNULL(p_key_version, key_version),
+        public_key = IFNULL(p_public_key, public_key),
+        key_lost_at = IF(p_wrapped_company_key IS NULL, key_lost_at, NULL)
+    WHERE id = p_id AND is_active = 1;
+
+    IF p_role IS NOT NULL AND p_role != v_old_role THEN
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', p_id, 'update', 'role', v_old_role, p_role, 0, p_ip_address, p_user_agent);
+    END IF;
+    IF p_permissions IS NOT NULL AND CAST(p_permissions AS CHAR) != CAST(v_old_permissions AS CHAR) THEN
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', p_id, 'update', 'permissions', CAST(v_old_permissions AS CHAR), CAST(p_permissions AS CHAR), 0, p_ip_address, p_user_agent);
+    END IF;
+    IF p_key_wrap_algorithm IS NOT NULL AND p_key_wrap_algorithm != v_old_key_wrap_algorithm THEN
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', p_id, 'update', 'key_wrap_algorithm', v_old_key_wrap_algorithm, p_key_wrap_algorithm, 0, p_ip_address, p_user_agent);
+    END IF;
+    IF p_key_version IS NOT NULL AND p_key_version != v_old_key_version THEN
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', p_id, 'update', 'key_version', CAST(v_old_key_version AS CHAR), CAST(p_key_version AS CHAR), 0, p_ip_address, p_user_agent);
+    END IF;
+    IF p_wrapped_company_key IS NOT NULL THEN
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', p_id, 'update', 'wrapped_company_key', NULL, NULL, 1, p_ip_address, p_user_agent);
+    END IF;
+    IF p_public_key IS NOT NULL THEN
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', p_id, 'update', 'public_key', NULL, NULL, 1, p_ip_address, p_user_agent);
+    END IF;
+END//
+
+-- ============================================================
+-- USER: CHANGE PASSWORD
+-- Companies whose key was lost cannot be re-wrapped and are
+-- not expected in p_wrapped_keys
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_change_password//
+CREATE PROCEDURE sp_change_password(
+    IN p_id VARCHAR(36),
+    IN p_password_hash VARCHAR(255),
+    IN p_salt VARCHAR(255),
+    IN p_wrapped_keys JSON,
+    IN p_company_id VARCHAR(36),
+    IN p_session_id VARCHAR(36),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    DECLARE v_access_count INT;
+    DECLARE v_matched_count INT;
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    -- Lock the user's access rows so no company is granted mid-change
+    SELECT COUNT(*) INTO v_access_count
+    FROM user_company_access uca
+    JOIN companies c ON c.id = uca.company_id AND c.is_active = 1
+    WHERE uca.user_id = p_id AND uca.is_active = 1 AND uca.key_lost_at IS NULL
+    FOR UPDATE;
+
+    SELECT COUNT(DISTINCT k.company_id) INTO v_matched_count
+    FROM JSON_TABLE(p_wrapped_keys, '$[*]' COLUMNS (
+        company_id VARCHAR(36) PATH '$.company_id'
+    )) k
+    JOIN user_company_access uca ON uca.company_id = k.company_id AND uca.user_id = p_id
+        AND uca.is_active = 1 AND uca.key_lost_at IS NULL
+    JOIN companies c ON c.id = uca.company_id AND c.is_active = 1;
+
+    IF v_matched_count <> v_access_count OR JSON_LENGTH(p_wrapped_keys) <> v_access_count THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'wrapped keys do not match the companies the user can access';
+    END IF;
+
+    UPDATE user_company_access uca
+    JOIN JSON_TABLE(p_wrapped_keys, '$[*]' COLUMNS (
+        company_id VARCHAR(36) PATH '$.company_id',
+        wrapped_company_key TEXT PATH '$.wrapped_company_key',
+        key_wrap_algorithm VARCHAR(50) PATH '$.key_wrap_algorithm'
+    )) k ON k.company_id = uca.company_id
+    SET uca.wrapped_company_key = FROM_BASE64(k.wrapped_company_key),
+        uca.key_wrap_algorithm = IFNULL(k.key_wrap_algorithm, uca.key_wrap_algorithm)
+    WHERE uca.user_id = p_id AND uca.is_active = 1;
+
+    UPDATE users SET
+        password_hash = p_password_hash,
+        salt = p_salt,
+        password_changed_at = NOW(),
+        failed_login_attempts = 0,
+        locked_until = NULL
+    WHERE id = p_id AND is_active = 1;
+
+    -- Log that password changed without storing actual hash
+    CALL sp_log_change(p_company_id, p_id, p_session_id, 'users', p_id, 'update', 'password_hash', '[redacted]', '[redacted]', 0, p_ip_address, p_user_agent);
+    CALL sp_log_change(p_company_id, p_id, p_session_id, 'users', p_id, 'update', 'password_changed_at', NULL, CAST(NOW() AS CHAR), 0, p_ip_address, p_user_agent);
+
+    -- Each company sees its own key re-wrap
+    INSERT INTO change_history (
+        id, company_id, changed_by, session_id,
+        table_name, record_id, change_type,
+        field_name, old_value, new_value, is_encrypted,
+        ip_address, user_agent, changed_at
+    )
+    SELECT UUID(), uca.company_id, p_id, p_session_id,
+           'user_company_access', uca.id, 'update',
+           'wrapped_company_key', '[redacted]', '[redacted]', 1,
+           p_ip_address, p_user_agent, NOW()
+    FROM user_company_access uca
+    WHERE uca.user_id = p_id AND uca.is_active = 1 AND uca.key_lost_at IS NULL;
+
+    COMMIT;
+END//
+
+-- ============================================================
+-- PASSWORD RESET: COMPLETE
+-- p_wrapped_keys is a JSON array of
+--   {company_id, wrapped_company_key (base64), key_version, key_wrap_algorithm}
+-- Every entry must match an active access row at its current
+-- key_version. Active companies left out are marked key_lost_at
+-- and their old wrapped key is cleared. The new public key is
+-- stored for every company so admins can re-share lost keys.
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_reset_password_with_key//
+CREATE PROCEDURE sp_reset_password_with_key(
+    IN p_user_id VARCHAR(36),
+    IN p_password_hash VARCHAR(255),
+    IN p_salt VARCHAR(255),
+    IN p_wrapped_keys JSON,
+    IN p_public_key BLOB,
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    DECLARE v_matched_count INT;
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    SELECT COUNT(*) INTO v_matched_count
+    FROM user_company_access uca
+    JOIN JSON_TABLE(p_wrapped_keys, '$[*]' COLUMNS (
+        company_id VARCHAR(36) PATH '$.company_id',
+        key_version INT PATH '$.key_version'
+    )) k ON k.company_id = uca.company_id AND k.key_version = uca.key_version
+    WHERE uca.user_id = p_user_id AND uca.is_active = 1
+    FOR UPDATE;
+
+    IF JSON_LENGTH(p_wrapped_keys) = 0 OR v_matched_count <> JSON_LENGTH(p_wrapped_keys) THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'wrapped keys do not match the user''s active company access';
+    END IF;
+
+    -- Companies the recovery file could not recover
+    UPDATE user_company_access uca
+    LEFT JOIN JSON_TABLE(p_wrapped_keys, '$[*]' COLUMNS (
+        company_id VARCHAR(36) PATH '$.company_id'
+    )) k ON k.company_id = uca.company_id
+    SET uca.wrapped_company_key = '',
+        uca.key_lost_at = IFNULL(uca.key_lost_at, NOW())
+    WHERE uca.user_id = p_user_id AND uca.is_active = 1 AND k.company_id IS NULL;
+
+    INSERT INTO change_history (
+        id, company_id, changed_by, session_id,
+        table_name, record_id, change_type,
+        field_name, old_value, new_value, is_encrypted,
+        ip_address, user_agent, changed_at
+    )
+    SELECT UUID(), uca.company_id, p_user_id, NULL,
+           'user_company_access', uca.id, 'update',
+           'key_lost_at', NULL, CAST(uca.key_lost_at AS CHAR), 0,
+           p_ip_address, p_user_agent, NOW()
+    FROM user_company_access uca
+    LEFT JOIN JSON_TABLE(p_wrapped_keys, '$[*]' COLUMNS (
+        company_id VARCHAR(36) PATH '$.company_id'
+    )) k ON k.company_id = uca.company_id
+    WHERE uca.user_id = p_user_id AND uca.is_active = 1 AND k.company_id IS NULL;
+
+    -- Recovered companies
+    UPDATE user_company_access uca
+    JOIN JSON_TABLE(p_wrapped_keys, '$[*]' COLUMNS (
+        company_id VARCHAR(36) PATH '$.company_id',
+        wrapped_company_key TEXT PATH '$.wrapped_company_key',
+        key_wrap_algorithm VARCHAR(50) PATH '$.key_wrap_algorithm'
+    )) k ON k.company_id = uca.company_id
+    SET uca.wrapped_company_key = FROM_BASE64(k.wrapped_company_key),
+        uca.key_wrap_algorithm = IFNULL(k.key_wrap_algorithm, uca.key_wrap_algorithm),
+        uca.key_lost_at = NULL
+    WHERE uca.user_id = p_user_id AND uca.is_active = 1;
+
+    UPDATE user_company_access SET public_key = p_public_key
+    WHERE user_id = p_user_id AND is_active = 1;
+
+    UPDATE users SET
+        password_hash = p_password_hash,
+        salt = p_salt,
+        password_changed_at = NOW(),
+        failed_login_attempts = 0,
+        locked_until = NULL
+    WHERE id = p_user_id AND is_active = 1;
+
+    UPDATE user_sessions SET is_active = 0
+    WHERE user_id = p_user_id AND is_active = 1;
+
+    -- Log in every company without storing actual hash or key
+    INSERT INTO change_history (
+        id, company_id, changed_by, session_id,
+        table_name, record_id, change_type,
+        field_name, old_value, new_value, is_encrypted,
+        ip_address, user_agent, changed_at
+    )
+    SELECT UUID(), uca.company_id, p_user_id, NULL,
+           f.table_name, IF(f.table_name = 'users', p_user_id, uca.id), 'update',
+           f.field_name, '[redacted]', '[redacted]', f.is_encrypted,
+           p_ip_address, p_user_agent, NOW()
+    FROM user_company_access uca
+    CROSS JOIN (
+        SELECT 'users' AS table_name, 'password_hash' AS field_name, 0 AS is_encrypted
+        UNION ALL SELECT 'user_company_access', 'public_key', 1
+    ) f
+    WHERE uca.user_id = p_user_id AND uca.is_active = 1;
+
+    INSERT INTO change_history (
+        id, company_id, changed_by, session_id,
+        table_name, record_id, change_type,
+        field_name, old_value, new_value, is_encrypted,
+        ip_address, user_agent, changed_at
+    )
+    SELECT UUID(), uca.company_id, p_user_id, NULL,
+           'user_company_access', uca.id, 'update',
+           'wrapped_company_key', '[redacted]', '[redacted]', 1,
+           p_ip_address, p_user_agent, NOW()
+    FROM user_company_access uca
+    JOIN JSON_TABLE(p_wrapped_keys, '$[*]' COLUMNS (
+        company_id VARCHAR(36) PATH '$.company_id'
+    )) k ON k.company_id = uca.company_id
+    WHERE uca.user_id = p_user_id AND uca.is_active = 1;
+
+    COMMIT;
+END//
+
+DELIMITER ;
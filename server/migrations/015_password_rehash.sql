@@ -0,0 +1,29 @@
+-- ============================================================
+-- PASSWORD REHASH
+-- Password hashes are PHC strings ($argon2id$v=19$m=..,t=..,p=..$salt$hash).
+-- Legacy bare hex hashes and hashes with weaker parameters are
+-- replaced at the next successful login.
+-- ============================================================
+
+USE lettersheets;
+
+DELIMITER //
+
+-- ============================================================
+-- USER: REHASH PASSWORD
+-- Same password, new encoding; not a password change, so
+-- password_changed_at is untouched. Skipped if the hash changed
+-- since it was read.
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_rehash_password//
+CREATE PROCEDURE sp_rehash_password(
+    IN p_user_id VARCHAR(36),
+    IN p_old_hash VARCHAR(255),
+    IN p_new_hash VARCHAR(255)
+)
+BEGIN
+    UPDATE users SET password_hash = p_new_hash
+    WHERE id = p_user_id AND password_hash = p_old_hash AND is_active = 1;
+END//
+
+DELIMITER ;
@@ -0,0 +1,143 @@
+-- ============================================================
+-- LOGIN ATTEMPTS
+-- Every login attempt with its outcome and reason, so admins can
+-- see why an account was locked, plus an admin unlock.
+-- user_id is NULL when the email matched no user.
+-- ============================================================
+
+USE lettersheets;
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id VARCHAR(36) NOT NULL PRIMARY KEY,
+    user_id VARCHAR(36),
+    email VARCHAR(255) NOT NULL,
+
+    outcome VARCHAR(20) NOT NULL,          -- success, failure, blocked
+    reason VARCHAR(50) NOT NULL,
+
+    ip_address VARCHAR(45),
+    user_agent VARCHAR(500),
+    attempted_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    INDEX idx_login_attempts_user (user_id, attempted_at),
+    INDEX idx_login_attempts_ip (ip_address, attempted_at)
+) ENGINE=InnoDB;
+
+DELIMITER //
+
+-- ============================================================
+-- LOGIN ATTEMPT: RECORD
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_record_login_attempt//
+CREATE PROCEDURE sp_record_login_attempt(
+    IN p_id VARCHAR(36),
+    IN p_user_id VARCHAR(36),
+    IN p_email VARCHAR(255),
+    IN p_outcome VARCHAR(20),
+    IN p_reason VARCHAR(50),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    INSERT INTO login_attempts (
+        id, user_id, email, outcome, reason, ip_address, user_agent, attempted_at
+    ) VALUES (
+        p_id, p_user_id, p_email, p_outcome, p_reason, p_ip_address, p_user_agent, NOW()
+    );
+END//
+
+-- ============================================================
+-- LOGIN ATTEMPT: HISTORY
+-- Only for a user who has (or had) access to p_company_id
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_get_login_history//
+CREATE PROCEDURE sp_get_login_history(
+    IN p_user_id VARCHAR(36),
+    IN p_company_id VARCHAR(36),
+    IN p_limit INT,
+    IN p_offset INT
+)
+BEGIN
+    SELECT la.id, la.user_id, la.email, la.outcome, la.reason,
+           la.ip_address, la.user_agent, la.attempted_at
+    FROM login_attempts la
+    WHERE la.user_id = p_user_id
+      AND EXISTS (
+          SELECT 1 FROM user_company_access uca
+          WHERE uca.user_id = p_user_id AND uca.company_id = p_company_id
+      )
+    ORDER BY la.attempted_at DESC
+    LIMIT p_limit OFFSET p_offset;
+END//
+
+-- ============================================================
+-- USER: LOCK STATE
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_get_user_lock_state//
+CREATE PROCEDURE sp_get_user_lock_state(
+    IN p_user_id VARCHAR(36),
+    IN p_company_id VARCHAR(36)
+)
+BEGIN
+    SELECT u.failed_login_attempts, u.locked_until
+    FROM users u
+    JOIN user_company_access uca ON uca.user_id = u.id AND uca.company_id = p_company_id
+    WHERE u.id = p_user_id
+    LIMIT 1;
+END//
+
+-- ============================================================
+-- USER: UNLOCK
+-- Clears the failure counter and lockout of a user with active
+-- access to the admin's company. Returns unlocked = 0 otherwise.
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_unlock_user//
+CREATE PROCEDURE sp_unlock_user(
+    IN p_user_id VARCHAR(36),
+    IN p_company_id VARCHAR(36),
+    IN p_changed_by VARCHAR(36),
+    IN p_session_id VARCHAR(36),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    DECLARE v_old_attempts INT;
+    DECLARE v_old_locked_until DATETIME;
+    DECLARE v_found TINYINT DEFAULT 0;
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    SELECT 1, u.failed_login_attempts, u.locked_until
+    INTO v_found, v_old_attempts, v_old_locked_until
+    FROM users u
+    JOIN user_company_access uca ON uca.user_id = u.id
+        AND uca.company_id = p_company_id AND uca.is_active = 1
+    WHERE u.id = p_user_id AND u.is_active = 1
+    FOR UPDATE;
+
+    IF v_found = 1 THEN
+        UPDATE users SET
+            failed_login_attempts = 0,
+            locked_until = NULL
+        WHERE id = p_user_id;
+
+        IF v_old_attempts <> 0 THEN
+            CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'users', p_user_id, 'update', 'failed_login_attempts', CAST(v_old_attempts AS CHAR), '0', 0, p_ip_address, p_user_agent);
+        END IF;
+        IF v_old_locked_until IS NOT NULL THEN
+            CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'users', p_user_id, 'update', 'locked_until', CAST(v_old_locked_until AS CHAR), NULL, 0, p_ip_address, p_user_agent);
+        END IF;
+    END IF;
+
+    COMMIT;
+
+    SELECT v_found AS unlocked;
+END//
+
+DELIMITER ;
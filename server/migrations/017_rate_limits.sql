@@ -0,0 +1,79 @@
+-- ============================================================
+-- RATE LIMITS
+-- Token buckets shared by every server instance when
+-- rate_limit.store is "mysql". Keys are e.g. "ip:login:203.0.113.7",
+-- "email:<hash of the address>:203.0.113.7" or, across all IPs,
+-- "email:<hash of the address>".
+-- ============================================================
+
+USE lettersheets;
+
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    bucket_key VARCHAR(255) NOT NULL PRIMARY KEY,
+    tokens DOUBLE NOT NULL,
+    updated_at DATETIME(3) NOT NULL,
+
+    INDEX idx_rate_limit_updated (updated_at)
+) ENGINE=InnoDB;
+
+DELIMITER //
+
+-- ============================================================
+-- RATE LIMIT: TAKE TOKEN
+-- Refills the bucket for the time elapsed, then takes one token.
+-- Returns allowed and, when refused, retry_after_ms.
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_take_rate_limit_token//
+CREATE PROCEDURE sp_take_rate_limit_token(
+    IN p_key VARCHAR(255),
+    IN p_per_minute INT,
+    IN p_burst INT
+)
+BEGIN
+    DECLARE v_tokens DOUBLE;
+    DECLARE v_updated_at DATETIME(3);
+    DECLARE v_now DATETIME(3) DEFAULT NOW(3);
+    DECLARE v_rate DOUBLE DEFAULT p_per_minute / 60;
+    DECLARE v_allowed TINYINT DEFAULT 0;
+    DECLARE v_retry_ms BIGINT DEFAULT 0;
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    INSERT IGNORE INTO rate_limit_buckets (bucket_key, tokens, updated_at)
+    VALUES (p_key, p_burst, v_now);
+
+    SELECT tokens, updated_at INTO v_tokens, v_updated_at
+    FROM rate_limit_buckets WHERE bucket_key = p_key
+    FOR UPDATE;
+
+    SET v_tokens = LEAST(p_burst, v_tokens + TIMESTAMPDIFF(MICROSECOND, v_updated_at, v_now) / 1000000 * v_rate);
+
+    IF v_tokens >= 1 THEN
+        SET v_tokens = v_tokens - 1;
+        SET v_allowed = 1;
+    ELSEIF v_rate > 0 THEN
+        SET v_retry_ms = CEIL((1 - v_tokens) / v_rate * 1000);
+    ELSE
+        SET v_retry_ms = 3600000;
+    END IF;
+
+    UPDATE rate_limit_buckets SET tokens = v_tokens, updated_at = v_now
+    WHERE bucket_key = p_key;
+
+    COMMIT;
+
+    -- Occasionally drop idle buckets; an hour without requests refills any limit we use
+    IF RAND() < 0.01 THEN
+        DELETE FROM rate_limit_buckets WHERE updated_at < v_now - INTERVAL 1 HOUR;
+    END IF;
+
+    SELECT v_allowed AS allowed, v_retry_ms AS retry_after_ms;
+END//
+
+DELIMITER ;
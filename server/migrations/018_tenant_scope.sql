@@ -0,0 +1,188 @@
+-- ============================================================
+-- TENANT SCOPE
+-- The procedures that change a member take the caller's company
+-- and only match rows of that company, so an admin cannot update,
+-- revoke or remove a member of another company by passing its
+-- IDs. They return a 0 flag (updated, revoked, removed) instead
+-- of changing anything when the row belongs elsewhere. Removing
+-- a user ends their access and sessions in that company only.
+-- ============================================================
+
+USE lettersheets;
+
+DELIMITER //
+
+-- ============================================================
+-- USER COMPANY ACCESS: UPDATE (role, permissions, key)
+-- Only rows of p_company_id are matched; returns updated = 0
+-- when the access row belongs to another company
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_update_user_company_access//
+CREATE PROCEDURE sp_update_user_company_access(
+    IN p_id VARCHAR(36),
+    IN p_role VARCHAR(50),
+    IN p_permissions JSON,
+    IN p_wrapped_company_key BLOB,
+    IN p_key_wrap_algorithm VARCHAR(50),
+    IN p_key_version INT,
+    IN p_public_key BLOB,
+    IN p_company_id VARCHAR(36),
+    IN p_changed_by VARCHAR(36),
+    IN p_session_id VARCHAR(36),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    DECLARE v_found TINYINT DEFAULT 0;
+    DECLARE v_old_role VARCHAR(50);
+    DECLARE v_old_permissions JSON;
+    DECLARE v_old_key_wrap_algorithm VARCHAR(50);
+    DECLARE v_old_key_version INT;
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    SELECT 1, role, permissions, key_wrap_algorithm, key_version
+    INTO v_found, v_old_role, v_old_permissions, v_old_key_wrap_algorithm, v_old_key_version
+    FROM user_company_access
+    WHERE id = p_id AND company_id = p_company_id AND is_active = 1
+    FOR UPDATE;
+
+    IF v_found = 1 THEN
+        UPDATE user_company_access SET
+            role = IFNULL(p_role, role),
+            permissions = IFNULL(p_permissions, permissions),
+            wrapped_company_key = IFNULL(p_wrapped_company_key, wrapped_company_key),
+            key_wrap_algorithm = IFNULL(p_key_wrap_algorithm, key_wrap_algorithm),
+            key_version = IFNULL(p_key_version, key_version),
+            public_key = IFNULL(p_public_key, public_key),
+            key_lost_at = IF(p_wrapped_company_key IS NULL, key_lost_at, NULL)
+        WHERE id = p_id AND company_id = p_company_id;
+
+        IF p_role IS NOT NULL AND p_role != v_old_role THEN
+            CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', p_id, 'update', 'role', v_old_role, p_role, 0, p_ip_address, p_user_agent);
+        END IF;
+        IF p_permissions IS NOT NULL AND CAST(p_permissions AS CHAR) != IFNULL(CAST(v_old_permissions AS CHAR), '') THEN
+            CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', p_id, 'update', 'permissions', CAST(v_old_permissions AS CHAR), CAST(p_permissions AS CHAR), 0, p_ip_address, p_user_agent);
+        END IF;
+        IF p_key_wrap_algorithm IS NOT NULL AND p_key_wrap_algorithm != v_old_key_wrap_algorithm THEN
+            CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', p_id, 'update', 'key_wrap_algorithm', v_old_key_wrap_algorithm, p_key_wrap_algorithm, 0, p_ip_address, p_user_agent);
+        END IF;
+        IF p_key_version IS NOT NULL AND p_key_version != v_old_key_version THEN
+            CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', p_id, 'update', 'key_version', CAST(v_old_key_version AS CHAR), CAST(p_key_version AS CHAR), 0, p_ip_address, p_user_agent);
+        END IF;
+        IF p_wrapped_company_key IS NOT NULL THEN
+            CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', p_id, 'update', 'wrapped_company_key', NULL, NULL, 1, p_ip_address, p_user_agent);
+        END IF;
+        IF p_public_key IS NOT NULL THEN
+            CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', p_id, 'update', 'public_key', NULL, NULL, 1, p_ip_address, p_user_agent);
+        END IF;
+    END IF;
+
+    COMMIT;
+
+    SELECT v_found AS updated;
+END//
+
+-- ============================================================
+-- USER COMPANY ACCESS: SOFT DELETE (revoke access)
+-- Only rows of p_company_id are matched; returns revoked = 0
+-- when the access row belongs to another company
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_delete_user_company_access//
+CREATE PROCEDURE sp_delete_user_company_access(
+    IN p_id VARCHAR(36),
+    IN p_company_id VARCHAR(36),
+    IN p_changed_by VARCHAR(36),
+    IN p_session_id VARCHAR(36),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    DECLARE v_found TINYINT DEFAULT 0;
+    DECLARE v_user_id VARCHAR(36);
+    DECLARE v_role VARCHAR(50);
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    SELECT 1, user_id, role INTO v_found, v_user_id, v_role
+    FROM user_company_access
+    WHERE id = p_id AND company_id = p_company_id AND is_active = 1
+    FOR UPDATE;
+
+    IF v_found = 1 THEN
+        UPDATE user_company_access SET is_active = 0 WHERE id = p_id;
+
+        UPDATE user_sessions SET is_active = 0
+        WHERE user_id = v_user_id AND company_id = p_company_id AND is_active = 1;
+
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', p_id, 'delete', 'is_active', '1', '0', 0, p_ip_address, p_user_agent);
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', p_id, 'delete', 'user_id', v_user_id, NULL, 0, p_ip_address, p_user_agent);
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', p_id, 'delete', 'role', v_role, NULL, 0, p_ip_address, p_user_agent);
+    END IF;
+
+    COMMIT;
+
+    SELECT v_found AS revoked;
+END//
+
+-- ============================================================
+-- USER: REMOVE FROM COMPANY
+-- Users are global: an admin ends the user's access to their own
+-- company and the sessions in it. The users row and access to
+-- other companies are untouched. Returns removed = 0 when the
+-- user is not an active member of p_company_id.
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_delete_user//
+CREATE PROCEDURE sp_delete_user(
+    IN p_id VARCHAR(36),
+    IN p_company_id VARCHAR(36),
+    IN p_changed_by VARCHAR(36),
+    IN p_session_id VARCHAR(36),
+    IN p_ip_address VARCHAR(45),
+    IN p_user_agent VARCHAR(500)
+)
+BEGIN
+    DECLARE v_found TINYINT DEFAULT 0;
+    DECLARE v_access_id VARCHAR(36);
+
+    DECLARE EXIT HANDLER FOR SQLEXCEPTION
+    BEGIN
+        ROLLBACK;
+        RESIGNAL;
+    END;
+
+    START TRANSACTION;
+
+    SELECT 1, id INTO v_found, v_access_id
+    FROM user_company_access
+    WHERE user_id = p_id AND company_id = p_company_id AND is_active = 1
+    FOR UPDATE;
+
+    IF v_found = 1 THEN
+        UPDATE user_company_access SET is_active = 0 WHERE id = v_access_id;
+
+        UPDATE user_sessions SET is_active = 0
+        WHERE user_id = p_id AND company_id = p_company_id AND is_active = 1;
+
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', v_access_id, 'delete', 'is_active', '1', '0', 0, p_ip_address, p_user_agent);
+        CALL sp_log_change(p_company_id, p_changed_by, p_session_id, 'user_company_access', v_access_id, 'delete', 'user_id', p_id, NULL, 0, p_ip_address, p_user_agent);
+    END IF;
+
+    COMMIT;
+
+    SELECT v_found AS removed;
+END//
+
+DELIMITER ;
@@ -0,0 +1,104 @@
+-- ============================================================
+-- IDEMPOTENCY KEYS
+-- Responses of requests sent with an Idempotency-Key header, so
+-- a client retrying e.g. register or create_user gets the first
+-- response back instead of running the action twice. scope is
+-- the user ID, or "email:<hash of the address>" for actions without
+-- a session.
+-- request_hash is an HMAC under the server secret key of the
+-- action, company and body (which holds the password): the same
+-- key with a different request is refused rather than replayed.
+-- ============================================================
+
+USE lettersheets;
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    scope VARCHAR(255) NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+
+    action VARCHAR(100) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+
+    -- NULL while the first request is still running
+    status_code SMALLINT,
+    response_body MEDIUMBLOB,
+
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL,
+
+    PRIMARY KEY (scope, idempotency_key),
+    INDEX idx_idempotency_expires (expires_at)
+) ENGINE=InnoDB;
+
+DELIMITER //
+
+-- ============================================================
+-- IDEMPOTENCY: BEGIN
+-- Claims the key for a new request unless a live claim exists.
+-- A claim still running after a minute is taken to have died
+-- with its server and is replaced. Returns created = 1 when the
+-- caller must run the request, otherwise the stored request_hash,
+-- status_code and response_body of the earlier one.
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_begin_idempotent_request//
+CREATE PROCEDURE sp_begin_idempotent_request(
+    IN p_scope VARCHAR(255),
+    IN p_key VARCHAR(255),
+    IN p_action VARCHAR(100),
+    IN p_request_hash CHAR(64),
+    IN p_expires_at DATETIME
+)
+BEGIN
+    DECLARE v_created INT DEFAULT 0;
+
+    DELETE FROM idempotency_keys
+    WHERE scope = p_scope AND idempotency_key = p_key
+      AND (expires_at < NOW() OR (status_code IS NULL AND created_at < NOW() - INTERVAL 1 MINUTE));
+
+    INSERT IGNORE INTO idempotency_keys (scope, idempotency_key, action, request_hash, expires_at)
+    VALUES (p_scope, p_key, p_action, p_request_hash, p_expires_at);
+    SET v_created = ROW_COUNT();
+
+    -- Occasionally drop expired keys of every scope
+    IF RAND() < 0.01 THEN
+        DELETE FROM idempotency_keys WHERE expires_at < NOW();
+    END IF;
+
+    SELECT v_created > 0 AS created, request_hash, status_code, response_body
+    FROM idempotency_keys
+    WHERE scope = p_scope AND idempotency_key = p_key;
+END//
+
+-- ============================================================
+-- IDEMPOTENCY: COMPLETE
+-- Stores the response of a claimed request for replay
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_complete_idempotent_request//
+CREATE PROCEDURE sp_complete_idempotent_request(
+    IN p_scope VARCHAR(255),
+    IN p_key VARCHAR(255),
+    IN p_status_code SMALLINT,
+    IN p_response_body MEDIUMBLOB
+)
+BEGIN
+    UPDATE idempotency_keys
+    SET status_code = p_status_code, response_body = p_response_body
+    WHERE scope = p_scope AND idempotency_key = p_key AND status_code IS NULL;
+END//
+
+-- ============================================================
+-- IDEMPOTENCY: RELEASE
+-- Drops the claim of a request that failed on the server side,
+-- so a retry with the same key runs it again
+-- ============================================================
+DROP PROCEDURE IF EXISTS sp_release_idempotent_request//
+CREATE PROCEDURE sp_release_idempotent_request(
+    IN p_scope VARCHAR(255),
+    IN p_key VARCHAR(255)
+)
+BEGIN
+    DELETE FROM idempotency_keys
+    WHERE scope = p_scope AND idempotency_key = p_key AND status_code IS NULL;
+END//
+
+DELIMITER ;
@@ -0,0 +1,556 @@
+// Package models holds the stored records and the request and response types
+// of the API. It is not internal: callers of package client build these.
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Roles
+const (
+	RoleSuperAdmin = "superadmin"
+	RoleAdmin      = "admin"
+	RoleHR         = "hr"
+	RolePayroll    = "payroll"
+	RoleManager    = "manager"
+	RoleEmployee   = "employee"
+)
+
+// Roles lists every role, most privileged first
+var Roles = []string{RoleSuperAdmin, RoleAdmin, RoleHR, RolePayroll, RoleManager, RoleEmployee}
+
+// Auth token purposes
+const (
+	TokenPurposeSelectCompany = "select_company"
+	TokenPurposePasswordReset = "password_reset"
+	TokenPurposeResetProof    = "password_reset_proof"
+)
+
+// WebAuthn challenge purposes
+const (
+	WebAuthnPurposeRegister = "webauthn_register"
+	WebAuthnPurposeLogin    = "webauthn_login"
+)
+
+// Company represents a company record
+type Company struct {
+	ID           string    `json:"id" db:"id"`
+	Name         string    `json:"name" db:"name"`
+	Industry     *string   `json:"industry,omitempty" db:"industry"`
+	Address      *string   `json:"address,omitempty" db:"address"`
+	City         *string   `json:"city,omitempty" db:"city"`
+	State        *string   `json:"state,omitempty" db:"state"`
+	Province     *string   `json:"province,omitempty" db:"province"`
+	KeyAlgorithm string    `json:"key_algorithm" db:"key_algorithm"`
+	KeyVersion   int       `json:"key_version" db:"key_version"`
+	MaxEmployees int       `json:"max_employees" db:"max_employees"`
+	Plan         string    `json:"plan" db:"plan"`
+	IsActive     bool      `json:"is_active" db:"is_active"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+
+	// Joined from company_settings
+	Timezone                 *string  `json:"timezone,omitempty"`
+	DateFormat               *string  `json:"date_format,omitempty"`
+	Currency                 *string  `json:"currency,omitempty"`
+	FiscalYearStart          *int     `json:"fiscal_year_start,omitempty"`
+	PayFrequency             *string  `json:"pay_frequency,omitempty"`
+	PayDay1                  *int     `json:"pay_day_1,omitempty"`
+	PayDay2                  *int     `json:"pay_day_2,omitempty"`
+	OvertimeRequiredApproval *bool    `json:"overtime_required_approval,omitempty"`
+	DefaultVacationDays      *float64 `json:"default_vacation_days,omitempty"`
+	DefaultSickDays          *float64 `json:"default_sick_days,omitempty"`
+	LeaveAccrualType         *string  `json:"leave_accrual_type,omitempty"`
+	EmployeeNumberPrefix     *string  `json:"employee_number_prefix,omitempty"`
+	EmployeeNumberAuto       *bool    `json:"employee_number_auto,omitempty"`
+}
+
+// User represents a user record
+type User struct {
+	ID                  string     `json:"id" db:"id"`
+	Email               string     `json:"email" db:"email"`
+	Username            string     `json:"username" db:"username"`
+	PasswordHash        string     `json:"-" db:"password_hash"`
+	Salt                string     `json:"-" db:"salt"`
+	TOTPSecretEnc       []byte     `json:"-" db:"totp_secret_enc"`
+	TOTPPendingEnc      []byte     `json:"-" db:"totp_pending_enc"`
+	TOTPEnabledAt       *time.Time `json:"totp_enabled_at,omitempty" db:"totp_enabled_at"`
+	IsActive            bool       `json:"is_active" db:"is_active"`
+	LastLoginAt         *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
+	FailedLoginAttempts int        `json:"-" db:"failed_login_attempts"`
+	LockedUntil         *time.Time `json:"-" db:"locked_until"`
+	PasswordChangedAt   *time.Time `json:"password_changed_at,omitempty" db:"password_changed_at"`
+	CreatedAt           time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// UserCompanyAccess maps a user to a company with keys and role
+type UserCompanyAccess struct {
+	ID                string    `json:"id" db:"id"`
+	UserID            string    `json:"user_id" db:"user_id"`
+	CompanyID         string    `json:"company_id" db:"company_id"`
+	WrappedCompanyKey []byte    `json:"wrapped_company_key" db:"wrapped_company_key"`
+	KeyWrapAlgorithm  string    `json:"key_wrap_algorithm" db:"key_wrap_algorithm"`
+	KeyVersion        int       `json:"key_version" db:"key_version"`
+	PublicKey         []byte    `json:"public_key" db:"public_key"`
+	Role              string    `json:"role" db:"role"`
+	Permissions       *string   `json:"permissions,omitempty" db:"permissions"`
+	IsActive          bool      `json:"is_active" db:"is_active"`
+	JoinedAt          time.Time `json:"joined_at" db:"joined_at"`
+	UpdatedAt         time.Time `json:"updated_at" db:"updated_at"`
+
+	// Set when a password reset could not recover this company's key;
+	// cleared when an admin shares the key again
+	KeyLostAt *time.Time `json:"key_lost_at,omitempty" db:"key_lost_at"`
+
+	// Joined fields
+	CompanyName *string `json:"company_name,omitempty"`
+	CompanyPlan *string `json:"company_plan,omitempty"`
+}
+
+// UserSession represents an active session
+type UserSession struct {
+	ID                string    `json:"id" db:"id"`
+	UserID            string    `json:"user_id" db:"user_id"`
+	CompanyID         string    `json:"company_id" db:"company_id"`
+	LoginID           string    `json:"login_id" db:"login_id"`
+	ExpiresAt         time.Time `json:"expires_at" db:"expires_at"`
+	AbsoluteExpiresAt time.Time `json:"absolute_expires_at" db:"absolute_expires_at"`
+	Email             string    `json:"email"`
+	Username          string    `json:"username"`
+	UserActive        bool      `json:"user_active"`
+	Role              string    `json:"role"`
+	Permissions       *string   `json:"permissions,omitempty"`
+	WrappedCompanyKey []byte    `json:"wrapped_company_key"`
+	KeyWrapAlgorithm  string    `json:"key_wrap_algorithm"`
+	KeyVersion        int       `json:"key_version"`
+	PublicKey         []byte    `json:"public_key"`
+}
+
+// SessionInfo describes a session for device listings
+type SessionInfo struct {
+	ID                string    `json:"id" db:"id"`
+	UserID            string    `json:"user_id" db:"user_id"`
+	CompanyID         string    `json:"company_id" db:"company_id"`
+	DeviceInfo        *string   `json:"device_info,omitempty" db:"device_info"`
+	IPAddress         *string   `json:"ip_address,omitempty" db:"ip_address"`
+	ExpiresAt         time.Time `json:"expires_at" db:"expires_at"`
+	AbsoluteExpiresAt time.Time `json:"absolute_expires_at" db:"absolute_expires_at"`
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
+	LastActivityAt    time.Time `json:"last_activity_at" db:"last_activity_at"`
+	IsCurrent         bool      `json:"is_current"`
+
+	// Joined fields
+	CompanyName *string `json:"company_name,omitempty"`
+}
+
+// SecurityPolicy is a company's authentication policy, stored with company_settings.
+// Nil limits fall back to the server configuration.
+type SecurityPolicy struct {
+	CompanyID                 string   `json:"company_id"`
+	MFARequiredRoles          []string `json:"mfa_required_roles" validate:"enum=role"`
+	PasswordMinLength         int      `json:"password_min_length"`
+	PasswordRequireComplexity bool     `json:"password_require_complexity"`
+	PasswordMaxAgeDays        *int     `json:"password_max_age_days"`
+	SessionHours              *int     `json:"session_hours"`
+	AllowedIPCIDRs            []string `json:"allowed_ip_cidrs"`
+	MaxLoginAttempts          *int     `json:"max_login_attempts"`
+	LockoutMinutes            *int     `json:"lockout_minutes"`
+
+	// Joined for the requesting user
+	PasswordChangedAt *time.Time `json:"-"`
+	MFAEnrolled       bool       `json:"-"`
+}
+
+// WebAuthnCredential is a passkey registered by a user
+type WebAuthnCredential struct {
+	ID           string     `json:"id" db:"id"`
+	UserID       string     `json:"user_id" db:"user_id"`
+	CredentialID []byte     `json:"-" db:"credential_id"`
+	PublicKey    []byte     `json:"-" db:"public_key"`
+	SignCount    uint32     `json:"-" db:"sign_count"`
+	AAGUID       []byte     `json:"-" db:"aaguid"`
+	Name         string     `json:"name" db:"name"`
+	Transports   *string    `json:"transports,omitempty" db:"transports"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+
+	// Joined fields
+	Email string `json:"-"`
+}
+
+// ChangeHistory represents a single field change record
+type ChangeHistory struct {
+	ID          string    `json:"id" db:"id"`
+	CompanyID   string    `json:"company_id" db:"company_id"`
+	ChangedBy   string    `json:"changed_by" db:"changed_by"`
+	SessionID   *string   `json:"session_id,omitempty" db:"session_id"`
+	TableName   string    `json:"table_name" db:"table_name"`
+	RecordID    string    `json:"record_id" db:"record_id"`
+	ChangeType  string    `json:"change_type" db:"change_type"`
+	FieldName   string    `json:"field_name" db:"field_name"`
+	OldValue    *string   `json:"old_value,omitempty" db:"old_value"`
+	NewValue    *string   `json:"new_value,omitempty" db:"new_value"`
+	IsEncrypted bool      `json:"is_encrypted" db:"is_encrypted"`
+	IPAddress   *string   `json:"ip_address,omitempty" db:"ip_address"`
+	UserAgent   *string   `json:"user_agent,omitempty" db:"user_agent"`
+	ChangedAt   time.Time `json:"changed_at" db:"changed_at"`
+
+	// Joined fields
+	ChangedByEmail    *string `json:"changed_by_email,omitempty"`
+	ChangedByUsername *string `json:"changed_by_username,omitempty"`
+}
+
+// LoginAttempt is one login attempt with its outcome
+type LoginAttempt struct {
+	ID          string    `json:"id" db:"id"`
+	UserID      *string   `json:"user_id,omitempty" db:"user_id"`
+	Email       string    `json:"email" db:"email"`
+	Outcome     string    `json:"outcome" db:"outcome"`
+	Reason      string    `json:"reason" db:"reason"`
+	IPAddress   *string   `json:"ip_address,omitempty" db:"ip_address"`
+	UserAgent   *string   `json:"user_agent,omitempty" db:"user_agent"`
+	AttemptedAt time.Time `json:"attempted_at" db:"attempted_at"`
+}
+
+// Login attempt outcomes
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+	LoginBlocked = "blocked"
+)
+
+// Login attempt reasons: the method for a success, the cause otherwise
+const (
+	LoginReasonPassword        = "password"
+	LoginReasonTOTP            = "password_totp"
+	LoginReasonBackupCode      = "password_backup_code"
+	LoginReasonPasskey         = "passkey"
+	LoginReasonUnknownUser     = "unknown_user"
+	LoginReasonInvalidPassword = "invalid_password"
+	LoginReasonInvalidTOTP     = "invalid_totp"
+	LoginReasonInvalidPasskey  = "invalid_passkey"
+	LoginReasonLocked          = "locked"
+	LoginReasonDeactivated     = "deactivated"
+)
+
+// IdempotentResponse is the stored outcome of a request sent with an
+// Idempotency-Key; StatusCode is nil while the request is still running
+type IdempotentResponse struct {
+	RequestHash  string `db:"request_hash"`
+	StatusCode   *int   `db:"status_code"`
+	ResponseBody []byte `db:"response_body"`
+}
+
+// Request/Response types
+
+type RegisterRequest struct {
+	CompanyName     string `json:"company_name" validate:"required,max=255"`
+	CompanyIndustry string `json:"company_industry,omitempty" validate:"max=100"`
+	CompanyAddress  string `json:"company_address,omitempty" validate:"max=500"`
+	CompanyCity     string `json:"company_city,omitempty" validate:"max=100"`
+	CompanyState    string `json:"company_state,omitempty" validate:"max=100"`
+	CompanyProvince string `json:"company_province,omitempty" validate:"max=100"`
+	KeyAlgorithm    string `json:"key_algorithm,omitempty" validate:"max=50"`
+
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Username string `json:"username" validate:"required,max=100"`
+	Password string `json:"password" validate:"required"`
+
+	WrappedCompanyKey []byte `json:"wrapped_company_key" validate:"required"`
+	KeyWrapAlgorithm  string `json:"key_wrap_algorithm,omitempty" validate:"max=50"`
+	PublicKey         []byte `json:"public_key" validate:"required"`
+}
+
+type LoginRequest struct {
+	Email      string `json:"email" validate:"required"`
+	Password   string `json:"password" validate:"required"`
+	TOTPCode   string `json:"totp_code,omitempty"`
+	BackupCode string `json:"backup_code,omitempty"`
+
+	// Passkey assertion used as the second factor
+	WebAuthn json.RawMessage `json:"webauthn,omitempty"`
+}
+
+type LoginResponse struct {
+	PreAuthToken     string              `json:"pre_auth_token"`
+	PreAuthExpiresAt time.Time           `json:"pre_auth_expires_at"`
+	User             *User               `json:"user"`
+	Companies        []UserCompanyAccess `json:"companies"`
+
+	// Set when a backup code was used instead of a TOTP code
+	BackupCodesRemaining *int `json:"backup_codes_remaining,omitempty"`
+}
+
+// WrappedKey is a company key wrapped under a key derived from the user's password
+type WrappedKey struct {
+	CompanyID         string  `json:"company_id" validate:"uuid"`
+	WrappedCompanyKey []byte  `json:"wrapped_company_key" validate:"required"`
+	KeyWrapAlgorithm  *string `json:"key_wrap_algorithm,omitempty"`
+	KeyVersion        int     `json:"key_version,omitempty"`
+}
+
+// ChangePasswordRequest carries every company key re-wrapped under the new
+// password and salt; keys wrapped under the old password become unusable
+type ChangePasswordRequest struct {
+	CurrentPassword string       `json:"current_password" validate:"required"`
+	NewPassword     string       `json:"new_password" validate:"required"`
+	Salt            string       `json:"salt" validate:"required,max=255"`
+	WrappedKeys     []WrappedKey `json:"wrapped_keys"`
+}
+
+type RequestPasswordResetRequest struct {
+	Email string `json:"email" validate:"required,max=255"`
+}
+
+type BeginPasswordResetRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// PasswordResetChallenge is a random nonce encrypted to the user's public key
+// for one company; decrypting it proves possession of the recovery key
+type PasswordResetChallenge struct {
+	CompanyID   string  `json:"company_id"`
+	CompanyName *string `json:"company_name,omitempty"`
+	KeyVersion  int     `json:"key_version"`
+	Challenge   []byte  `json:"challenge"`
+}
+
+// CompletePasswordResetRequest proves the recovery key with one answered
+// challenge (CompanyID, Proof) and carries the keys it recovered, keyed by
+// company ID. Companies left out of WrappedKeys are marked as lost.
+type CompletePasswordResetRequest struct {
+	Token       string                `json:"token" validate:"required"`
+	CompanyID   string                `json:"company_id" validate:"required,uuid"`
+	Proof       []byte                `json:"proof" validate:"required"`
+	Password    string                `json:"password" validate:"required"`
+	Salt        string                `json:"salt" validate:"required,max=255"`
+	WrappedKeys map[string]WrappedKey `json:"wrapped_keys" validate:"required"`
+	PublicKey   []byte                `json:"public_key" validate:"required"`
+}
+
+type SelectCompanyRequest struct {
+	PreAuthToken string `json:"pre_auth_token" validate:"required"`
+	CompanyID    string `json:"company_id" validate:"required,uuid"`
+	DeviceInfo   string `json:"device_info,omitempty" validate:"max=500"`
+}
+
+// SelectCompanyResponse opens a session in one company; switch_company returns it too
+type SelectCompanyResponse struct {
+	SessionID            string     `json:"session_id"`
+	Token                string     `json:"token"`
+	RefreshToken         string     `json:"refresh_token"`
+	ExpiresAt            time.Time  `json:"expires_at"`
+	AbsoluteExpiresAt    time.Time  `json:"absolute_expires_at"`
+	WrappedCompanyKey    []byte     `json:"wrapped_company_key"`
+	KeyWrapAlgorithm     string     `json:"key_wrap_algorithm"`
+	KeyVersion           int        `json:"key_version"`
+	KeyLostAt            *time.Time `json:"key_lost_at"`
+	Role                 string     `json:"role"`
+	Permissions          *string    `json:"permissions"`
+	EffectivePermissions []string   `json:"effective_permissions"`
+
+	// Set when company policy restricts the session until the user complies
+	PasswordExpired       bool `json:"password_expired"`
+	MFAEnrollmentRequired bool `json:"mfa_enrollment_required"`
+}
+
+type SwitchCompanyRequest struct {
+	CompanyID  string `json:"company_id" validate:"required,uuid"`
+	DeviceInfo string `json:"device_info,omitempty" validate:"max=500"`
+}
+
+type RefreshSessionRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type RefreshSessionResponse struct {
+	SessionID         string    `json:"session_id"`
+	Token             string    `json:"token"`
+	RefreshToken      string    `json:"refresh_token"`
+	ExpiresAt         time.Time `json:"expires_at"`
+	AbsoluteExpiresAt time.Time `json:"absolute_expires_at"`
+}
+
+type TOTPEnrollResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type DisableTOTPRequest struct {
+	Password   string `json:"password" validate:"required"`
+	Code       string `json:"code,omitempty"`
+	BackupCode string `json:"backup_code,omitempty"`
+}
+
+type BackupCodesResponse struct {
+	BackupCodes []string `json:"backup_codes"`
+}
+
+// WebAuthnOptionsResponse carries the options for navigator.credentials.create() or .get()
+type WebAuthnOptionsResponse struct {
+	PublicKey map[string]interface{} `json:"public_key"`
+}
+
+type WebAuthnRegisterRequest struct {
+	Name       string          `json:"name" validate:"required,max=100"`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+}
+
+type WebAuthnLoginBeginRequest struct {
+	// Optional and ignored: the options are the same for every email, so
+	// they do not reveal accounts. Accepted from older clients.
+	Email string `json:"email,omitempty" validate:"max=255"`
+}
+
+type WebAuthnLoginRequest struct {
+	Credential json.RawMessage `json:"credential" validate:"required"`
+}
+
+type DeleteWebAuthnCredentialRequest struct {
+	ID       string `json:"id" validate:"required,uuid"`
+	Password string `json:"password" validate:"required"`
+}
+
+type RegenerateBackupCodesRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+type RevokeSessionRequest struct {
+	SessionID string `json:"session_id" validate:"required,uuid"`
+}
+
+// UserIDRequest names the user an admin action applies to
+type UserIDRequest struct {
+	UserID string `json:"user_id" validate:"required,uuid"`
+}
+
+// UpdateCompanyRequest changes only the fields that are set
+type UpdateCompanyRequest struct {
+	Name         *string `json:"name" validate:"max=255"`
+	Industry     *string `json:"industry" validate:"max=100"`
+	Address      *string `json:"address" validate:"max=500"`
+	City         *string `json:"city" validate:"max=100"`
+	State        *string `json:"state" validate:"max=100"`
+	Province     *string `json:"province" validate:"max=100"`
+	MaxEmployees *int    `json:"max_employees"`
+	Plan         *string `json:"plan" validate:"max=50"`
+}
+
+// UpdateUserRequest changes only the fields that are set
+type UpdateUserRequest struct {
+	Email    *string `json:"email" validate:"email,max=255"`
+	Username *string `json:"username" validate:"max=100"`
+}
+
+type CreateUserRequest struct {
+	Email             string `json:"email" validate:"required,email,max=255"`
+	Username          string `json:"username" validate:"required,max=100"`
+	Password          string `json:"password" validate:"required"`
+	Role              string `json:"role" validate:"enum=role"`
+	WrappedCompanyKey []byte `json:"wrapped_company_key" validate:"required"`
+	KeyWrapAlgorithm  string `json:"key_wrap_algorithm" validate:"max=50"`
+	PublicKey         []byte `json:"public_key" validate:"required"`
+}
+
+type UpdateUserAccessRequest struct {
+	AccessID          string  `json:"access_id" validate:"required,uuid"`
+	Role              *string `json:"role" validate:"enum=role"`
+	Permissions       *string `json:"permissions"`
+	WrappedCompanyKey []byte  `json:"wrapped_company_key"`
+	KeyWrapAlgorithm  *string `json:"key_wrap_algorithm" validate:"max=50"`
+	KeyVersion        *int    `json:"key_version"`
+	PublicKey         []byte  `json:"public_key"`
+}
+
+type RevokeUserAccessRequest struct {
+	AccessID string `json:"access_id" validate:"required,uuid"`
+}
+
+type HistoryRequest struct {
+	TableName *string `json:"table_name"`
+	RecordID  *string `json:"record_id"`
+	Limit     *int    `json:"limit"`
+	Offset    *int    `json:"offset"`
+}
+
+type LoginHistoryRequest struct {
+	UserID string `json:"user_id" validate:"uuid"`
+	Limit  *int   `json:"limit"`
+	Offset *int   `json:"offset"`
+}
+
+// MessageResponse confirms an action that returns nothing else
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+type RegisterResponse struct {
+	CompanyID string `json:"company_id"`
+	UserID    string `json:"user_id"`
+	AccessID  string `json:"access_id"`
+	Salt      string `json:"salt"`
+}
+
+type RevokeSessionResponse struct {
+	Message string `json:"message"`
+	Current bool   `json:"current"`
+}
+
+type ChangePasswordResponse struct {
+	Message string `json:"message"`
+	NewSalt string `json:"new_salt"`
+}
+
+type CreateUserResponse struct {
+	UserID   string `json:"user_id"`
+	AccessID string `json:"access_id"`
+	Salt     string `json:"salt"`
+}
+
+type ConfirmTOTPResponse struct {
+	Message     string   `json:"message"`
+	BackupCodes []string `json:"backup_codes"`
+}
+
+type BeginPasswordResetResponse struct {
+	Challenges []PasswordResetChallenge `json:"challenges"`
+	ExpiresAt  time.Time                `json:"expires_at"`
+}
+
+type CompletePasswordResetResponse struct {
+	Message          string   `json:"message"`
+	LostKeyCompanies []string `json:"lost_key_companies"`
+}
+
+type HistoryResponse struct {
+	Records []ChangeHistory `json:"records"`
+	Limit   int             `json:"limit"`
+	Offset  int             `json:"offset"`
+}
+
+type LoginHistoryResponse struct {
+	Records             []LoginAttempt `json:"records"`
+	FailedLoginAttempts int            `json:"failed_login_attempts"`
+	LockedUntil         *time.Time     `json:"locked_until"`
+	Limit               int            `json:"limit"`
+	Offset              int            `json:"offset"`
+}
+
+// RequestMeta holds common request metadata for audit logging
+type RequestMeta struct {
+	UserID    string
+	SessionID string
+	CompanyID string
+	IPAddress string
+	UserAgent string
+}